@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var promMissedTicks = promFactory.NewCounter(prometheus.CounterOpts{
+	Namespace: "envoy_exporter",
+	Name:      "scrape_missed_ticks_total",
+	Help:      "Aligned scrape ticks skipped because the previous scrape overran them.",
+})
+
+// validateAlignment checks the align_* settings against the interval.
+func (c *Config) validateAlignment() error {
+	if c.AlignJitter < 0 {
+		return fmt.Errorf("align_jitter must not be negative")
+	}
+	if c.AlignInterval && c.AlignJitter >= c.Interval {
+		return fmt.Errorf("align_jitter %ds must be shorter than interval %ds", c.AlignJitter, c.Interval)
+	}
+	return nil
+}
+
+// scrapeClock schedules scrapes on wall-clock multiples of the interval, so
+// exporters on different hosts write points with the same timestamps. Each
+// tick fires offset after its boundary, and the scrape's points carry the
+// boundary itself.
+type scrapeClock struct {
+	interval time.Duration
+	offset   time.Duration
+}
+
+// newScrapeClock returns a clock for cfg, with an offset chosen at random up
+// to align_jitter so exporters sharing a gateway do not poll it at once.
+func newScrapeClock(cfg *Config) scrapeClock {
+	c := scrapeClock{interval: time.Duration(cfg.Interval) * time.Second}
+	if cfg.AlignJitter > 0 {
+		c.offset = rand.N(time.Duration(cfg.AlignJitter) * time.Second)
+	}
+	return c
+}
+
+// slot returns the boundary whose tick is the latest at or before t.
+func (c scrapeClock) slot(t time.Time) time.Time {
+	return t.Add(-c.offset).Truncate(c.interval)
+}
+
+// next returns the boundary of the first tick after now and when it fires.
+// missed counts the boundaries after prev that were skipped because now is
+// already past their ticks, e.g. after a slow scrape; prev may be zero.
+func (c scrapeClock) next(prev, now time.Time) (slot, fire time.Time, missed int) {
+	slot = c.slot(now).Add(c.interval)
+	if !prev.IsZero() {
+		missed = max(0, int(slot.Sub(prev)/c.interval)-1)
+	}
+	return slot, slot.Add(c.offset), missed
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ValidateAlignment(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, (&Config{Interval: 30, AlignInterval: true, AlignJitter: 5}).validateAlignment())
+	assert.NoError(t, (&Config{Interval: 30, AlignJitter: 60}).validateAlignment(), "unused without alignment")
+	assert.Error(t, (&Config{Interval: 30, AlignInterval: true, AlignJitter: 30}).validateAlignment())
+	assert.Error(t, (&Config{Interval: 30, AlignJitter: -1}).validateAlignment())
+}
+
+func TestScrapeClock(t *testing.T) {
+	t.Parallel()
+
+	c := scrapeClock{interval: 30 * time.Second, offset: 2 * time.Second}
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	slot, fire, missed := c.next(time.Time{}, base.Add(7*time.Second))
+	assert.Equal(t, base.Add(30*time.Second), slot)
+	assert.Equal(t, base.Add(32*time.Second), fire)
+	assert.Zero(t, missed)
+
+	// Just before the offset the tick of the current boundary is still due.
+	slot, _, _ = c.next(time.Time{}, base.Add(time.Second))
+	assert.Equal(t, base, slot)
+
+	// A scrape of the 12:00:30 slot finishing at 12:00:45 waits for 12:01:00.
+	slot, _, missed = c.next(base.Add(30*time.Second), base.Add(45*time.Second))
+	assert.Equal(t, base.Add(time.Minute), slot)
+	assert.Zero(t, missed)
+
+	// One finishing at 12:01:40 has overrun the 12:01:00 and 12:01:30 ticks.
+	slot, fire, missed = c.next(base.Add(30*time.Second), base.Add(100*time.Second))
+	assert.Equal(t, base.Add(2*time.Minute), slot)
+	assert.Equal(t, base.Add(122*time.Second), fire)
+	assert.Equal(t, 2, missed)
+}
+
+func TestNewScrapeClock(t *testing.T) {
+	t.Parallel()
+
+	assert.Zero(t, newScrapeClock(&Config{Interval: 30, AlignInterval: true}).offset)
+	for range 20 {
+		c := newScrapeClock(&Config{Interval: 30, AlignInterval: true, AlignJitter: 3})
+		assert.Equal(t, 30*time.Second, c.interval)
+		assert.Less(t, c.offset, 3*time.Second)
+	}
+}
+
+func TestScrapeLoop_Aligned(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+
+	client := &MockEnvoyClient{
+		LiveDataFunc: func(_ context.Context) (gateway.LiveData, error) {
+			return makeLiveData(1000000, 0, 0, 1000000), nil
+		},
+	}
+	writer := &MockPointWriter{}
+	factory := func(context.Context, *Config) (EnvoyClient, error) { return client, nil }
+
+	scrapeLoop(ctx, &Config{Interval: 1, RetryInterval: 1, AlignInterval: true}, writer, factory, nil)
+
+	require.NotEmpty(t, writer.Written)
+	for _, pt := range writer.Written {
+		assert.Zero(t, pt.Time().Nanosecond(), "timestamped on the second boundary")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+)
+
+// apiVersion is reported in every /api/v1 response. Fields may be added
+// within a version; renaming or removing one requires a new version.
+const apiVersion = "v1"
+
+// apiResponse is the envelope around every /api/v1 payload.
+// ReadingAt is when the gateway data was scraped; Stale is set once the
+// reading is older than the staleness threshold.
+type apiResponse struct {
+	APIVersion  string    `json:"api_version"`
+	GeneratedAt time.Time `json:"generated_at"`
+	ReadingAt   time.Time `json:"reading_at"`
+	AgeSeconds  float64   `json:"age_seconds"`
+	Stale       bool      `json:"stale"`
+	Data        any       `json:"data"`
+}
+
+// apiError is returned with a non-2xx status.
+type apiError struct {
+	APIVersion string `json:"api_version"`
+	Error      string `json:"error"`
+}
+
+// apiSnapshot mirrors the energy-snapshot measurement.
+type apiSnapshot struct {
+	SolarW       float64 `json:"solar_w"`
+	BatteryW     float64 `json:"battery_w"`
+	GridW        float64 `json:"grid_w"`
+	LoadW        float64 `json:"load_w"`
+	BatterySOC   int     `json:"battery_soc"`
+	BatteryWh    int     `json:"battery_wh"`
+	SolarToLoadW float64 `json:"solar_to_load_w"`
+	SolarToGridW float64 `json:"solar_to_grid_w"`
+	SolarToBattW float64 `json:"solar_to_batt_w"`
+	GridToLoadW  float64 `json:"grid_to_load_w"`
+	BattToLoadW  float64 `json:"batt_to_load_w"`
+}
+
+type apiInverter struct {
+	Serial          string    `json:"serial"`
+	LastReportWatts int       `json:"last_report_watts"`
+	MaxReportWatts  int       `json:"max_report_watts"`
+	LastReportAt    time.Time `json:"last_report_at"`
+}
+
+type apiBattery struct {
+	Serial        string    `json:"serial"`
+	PercentFull   int       `json:"percent_full"`
+	TemperatureC  int       `json:"temperature_c"`
+	MaxCellTempC  int       `json:"max_cell_temp_c"`
+	CapacityWh    int       `json:"capacity_wh"`
+	Phase         string    `json:"phase"`
+	GridMode      string    `json:"grid_mode"`
+	Communicating bool      `json:"communicating"`
+	Firmware      string    `json:"firmware"`
+	DeviceStatus  []string  `json:"device_status"`
+	LastReportAt  time.Time `json:"last_report_at"`
+}
+
+type apiMeter struct {
+	MeasurementType  string  `json:"measurement_type"`
+	Line             int     `json:"line"`
+	ActivePowerW     float64 `json:"active_power_w"`
+	ReactivePowerVAr float64 `json:"reactive_power_var"`
+	ApparentPowerVA  float64 `json:"apparent_power_va"`
+	CurrentA         float64 `json:"current_a"`
+	VoltageV         float64 `json:"voltage_v"`
+	FrequencyHz      float64 `json:"frequency_hz"`
+	PowerFactor      float64 `json:"power_factor"`
+}
+
+func toAPISnapshot(s gateway.EnergySnapshot) apiSnapshot {
+	return apiSnapshot{
+		SolarW:       s.SolarW,
+		BatteryW:     s.BatteryW,
+		GridW:        s.GridW,
+		LoadW:        s.LoadW,
+		BatterySOC:   s.BatterySOC,
+		BatteryWh:    s.BatteryWh,
+		SolarToLoadW: s.SolarToLoad,
+		SolarToGridW: s.SolarToGrid,
+		SolarToBattW: s.SolarToBatt,
+		GridToLoadW:  s.GridToLoad,
+		BattToLoadW:  s.BattToLoad,
+	}
+}
+
+func toAPIInverters(invs []gateway.InverterReading) []apiInverter {
+	out := make([]apiInverter, 0, len(invs))
+	for _, inv := range invs {
+		out = append(out, apiInverter{
+			Serial:          inv.SerialNumber,
+			LastReportWatts: inv.LastReportWatts,
+			MaxReportWatts:  inv.MaxReportWatts,
+			LastReportAt:    time.Unix(inv.LastReportDate, 0).UTC(),
+		})
+	}
+	return out
+}
+
+func toAPIBatteries(bats []gateway.BatteryStatus) []apiBattery {
+	out := make([]apiBattery, 0, len(bats))
+	for _, b := range bats {
+		status := b.DeviceStatus
+		if status == nil {
+			status = []string{}
+		}
+		out = append(out, apiBattery{
+			Serial:        b.SerialNum,
+			PercentFull:   b.PercentFull,
+			TemperatureC:  b.Temperature,
+			MaxCellTempC:  b.MaxCellTemp,
+			CapacityWh:    b.CapacityWh,
+			Phase:         b.Phase,
+			GridMode:      b.GridMode,
+			Communicating: b.Communicating,
+			Firmware:      b.Firmware,
+			DeviceStatus:  status,
+			LastReportAt:  time.Unix(b.LastReportDate, 0).UTC(),
+		})
+	}
+	return out
+}
+
+// toAPIMeters flattens CT readings to one entry per phase channel.
+func toAPIMeters(readings []gateway.TypedCTReading) []apiMeter {
+	out := []apiMeter{}
+	for _, r := range readings {
+		for i, ch := range r.Channels {
+			out = append(out, apiMeter{
+				MeasurementType:  r.MeasurementType,
+				Line:             i,
+				ActivePowerW:     ch.ActivePower,
+				ReactivePowerVAr: ch.ReactivePower,
+				ApparentPowerVA:  ch.ApparentPower,
+				CurrentA:         ch.Current,
+				VoltageV:         ch.Voltage,
+				FrequencyHz:      ch.Freq,
+				PowerFactor:      ch.PowerFactor,
+			})
+		}
+	}
+	return out
+}
+
+// writeJSON encodes v with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("JSON response write failed", "error", err)
+	}
+}
+
+// apiHandler serves one data family. read extracts the payload and its reading
+// time from a store view; a zero time means the family has never been read.
+func apiHandler(store *scrapeStore, staleAfter time.Duration, read func(storeView) (any, time.Time)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, at := read(store.view())
+		if at.IsZero() {
+			writeJSON(w, http.StatusServiceUnavailable, apiError{APIVersion: apiVersion, Error: "no successful scrape yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIResponse(data, at, time.Now(), staleAfter))
+	}
+}
+
+// newAPIResponse wraps data read at the given time in the versioned envelope.
+func newAPIResponse(data any, at, now time.Time, staleAfter time.Duration) apiResponse {
+	age := now.Sub(at)
+	return apiResponse{
+		APIVersion:  apiVersion,
+		GeneratedAt: now,
+		ReadingAt:   at,
+		AgeSeconds:  age.Seconds(),
+		Stale:       age > staleAfter,
+		Data:        data,
+	}
+}
+
+// registerAPI adds the versioned JSON endpoints, backed by store, to mux.
+func registerAPI(mux *http.ServeMux, store *scrapeStore, staleAfter time.Duration) {
+	mux.HandleFunc("GET /api/v1/snapshot", apiHandler(store, staleAfter, func(v storeView) (any, time.Time) {
+		return toAPISnapshot(v.Snapshot), v.SnapshotAt
+	}))
+	mux.HandleFunc("GET /api/v1/inverters", apiHandler(store, staleAfter, func(v storeView) (any, time.Time) {
+		return toAPIInverters(v.Inverters), v.InvertersAt
+	}))
+	mux.HandleFunc("GET /api/v1/batteries", apiHandler(store, staleAfter, func(v storeView) (any, time.Time) {
+		return toAPIBatteries(v.Batteries), v.BatteriesAt
+	}))
+	mux.HandleFunc("GET /api/v1/meters", apiHandler(store, staleAfter, func(v storeView) (any, time.Time) {
+		return toAPIMeters(v.Meters), v.MetersAt
+	}))
+	mux.HandleFunc("GET /api/v1/stream", streamHandler(store, staleAfter, 15*time.Second))
+}
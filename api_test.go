@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getAPI issues a GET against mux and decodes the JSON body into out.
+func getAPI(t *testing.T, mux *http.ServeMux, path string, out any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(out))
+	return w.Code
+}
+
+func TestAPI_NoDataYet(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	registerAPI(mux, &scrapeStore{}, 15*time.Second)
+
+	for _, path := range []string{"/api/v1/snapshot", "/api/v1/inverters", "/api/v1/batteries", "/api/v1/meters"} {
+		var e apiError
+		assert.Equal(t, http.StatusServiceUnavailable, getAPI(t, mux, path, &e), path)
+		assert.Equal(t, "v1", e.APIVersion)
+		assert.NotEmpty(t, e.Error)
+	}
+}
+
+func TestAPI_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	store := &scrapeStore{}
+	at := time.Now().Add(-2 * time.Second)
+	store.setSnapshot(gateway.SnapshotFromLiveData(makeLiveData(5000000, -1000000, -2000000, 2000000)), at)
+
+	mux := http.NewServeMux()
+	registerAPI(mux, store, 15*time.Second)
+
+	var resp struct {
+		apiResponse
+		Data apiSnapshot `json:"data"`
+	}
+	require.Equal(t, http.StatusOK, getAPI(t, mux, "/api/v1/snapshot", &resp))
+	assert.Equal(t, "v1", resp.APIVersion)
+	assert.WithinDuration(t, at, resp.ReadingAt, time.Millisecond)
+	assert.InDelta(t, 2.0, resp.AgeSeconds, 1.0)
+	assert.False(t, resp.Stale)
+	assert.Equal(t, 5000.0, resp.Data.SolarW)
+	assert.Equal(t, 1000.0, resp.Data.SolarToBattW)
+}
+
+func TestAPI_StaleAndFamilies(t *testing.T) {
+	t.Parallel()
+
+	store := &scrapeStore{}
+	old := time.Now().Add(-time.Minute) // older than the 15s threshold
+	store.setInverters([]gateway.InverterReading{{SerialNumber: "INV1", LastReportWatts: 200, LastReportDate: 1700000000}}, old)
+	store.setBatteries([]gateway.BatteryStatus{{SerialNum: "BAT1", PercentFull: 42}}, old)
+	store.setMeters([]gateway.TypedCTReading{{
+		CTReading:       gateway.CTReading{Channels: []gateway.CTChannel{{ActivePower: 120, Freq: 60}}},
+		MeasurementType: MeasurementNetConsumption,
+	}}, old)
+
+	mux := http.NewServeMux()
+	registerAPI(mux, store, 15*time.Second)
+
+	var inv struct {
+		apiResponse
+		Data []apiInverter `json:"data"`
+	}
+	require.Equal(t, http.StatusOK, getAPI(t, mux, "/api/v1/inverters", &inv))
+	assert.True(t, inv.Stale)
+	require.Len(t, inv.Data, 1)
+	assert.Equal(t, "INV1", inv.Data[0].Serial)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), inv.Data[0].LastReportAt)
+
+	var bat struct {
+		apiResponse
+		Data []apiBattery `json:"data"`
+	}
+	require.Equal(t, http.StatusOK, getAPI(t, mux, "/api/v1/batteries", &bat))
+	require.Len(t, bat.Data, 1)
+	assert.Equal(t, 42, bat.Data[0].PercentFull)
+	assert.NotNil(t, bat.Data[0].DeviceStatus, "device_status encodes as [] rather than null")
+
+	var met struct {
+		apiResponse
+		Data []apiMeter `json:"data"`
+	}
+	require.Equal(t, http.StatusOK, getAPI(t, mux, "/api/v1/meters", &met))
+	require.Len(t, met.Data, 1)
+	assert.Equal(t, MeasurementNetConsumption, met.Data[0].MeasurementType)
+	assert.Equal(t, 60.0, met.Data[0].FrequencyHz)
+}
+
+// TestAPI_NotInstalled scrapes into the process-wide store, so it must not
+// run in parallel.
+func TestAPI_NotInstalled(t *testing.T) {
+	client := &MockEnvoyClient{
+		TypedMeterReadingsFunc: func(context.Context) ([]gateway.TypedCTReading, error) {
+			return nil, &gateway.Error{StatusCode: http.StatusNotFound}
+		},
+		BatteryInventoryFunc: func(context.Context) ([]gateway.BatteryStatus, error) {
+			return nil, &gateway.Error{StatusCode: http.StatusNotFound}
+		},
+	}
+	scrape(context.Background(), client, &MockPointWriter{}, &Schema{Source: "test"})
+
+	mux := http.NewServeMux()
+	registerAPI(mux, latest, 15*time.Second)
+	for _, path := range []string{"/api/v1/meters", "/api/v1/batteries"} {
+		var resp struct {
+			apiResponse
+			Data []json.RawMessage `json:"data"`
+		}
+		require.Equal(t, http.StatusOK, getAPI(t, mux, path, &resp), "%s: not installed is an empty reading", path)
+		assert.NotNil(t, resp.Data, path)
+		assert.Empty(t, resp.Data, path)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	promQueueDepth = promFactory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "envoy_exporter",
+		Name:      "write_queue_depth",
+		Help:      "Points waiting in a sink's write queue.",
+	}, []string{"sink"})
+	promQueueDropped = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "write_dropped_points_total",
+		Help:      "Points dropped by a sink's write queue, by reason (queue_full, write_failed).",
+	}, []string{"sink", "reason"})
+	promQueueRetries = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "write_retries_total",
+		Help:      "Batch writes retried after a transient failure.",
+	}, []string{"sink"})
+)
+
+// errBatchWriterClosed is returned by WritePoint after Close.
+var errBatchWriterClosed = errors.New("batch writer closed")
+
+// queueFullError reports points a BatchWriter dropped because its queue was
+// full. The points before them were enqueued.
+type queueFullError struct {
+	sink    string
+	dropped int
+}
+
+func (e *queueFullError) Error() string {
+	return fmt.Sprintf("%s write queue full: dropped %d points", e.sink, e.dropped)
+}
+
+// BatchOptions configures a BatchWriter.
+type BatchOptions struct {
+	BatchSize     int           // points per write; default 1000
+	FlushInterval time.Duration // longest a point waits for a full batch; default 5s
+	QueueSize     int           // points buffered before new points are dropped; default 50000
+	MaxRetries    int           // retries of a failed batch before it is dropped
+	RetryBase     time.Duration // first retry delay, doubled per attempt; default 1s
+	RetryMax      time.Duration // retry delay cap; default 30s
+}
+
+// BatchWriter decouples scrapes from a slow sink. WritePoint only enqueues;
+// a background goroutine writes batches to next, retrying transient failures
+// with exponential backoff. When the queue is full new points are dropped so
+// memory stays bounded.
+type BatchWriter struct {
+	name string
+	next PointWriter
+	opts BatchOptions
+
+	mu     sync.RWMutex // guards closed against sends on queue
+	closed bool
+	queue  chan *influxdb2write.Point
+
+	ctx    context.Context // cancelled to abandon retries when a drain times out
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBatchWriter starts a batch writer for the named sink. Call Close to
+// flush queued points and stop it.
+func NewBatchWriter(name string, next PointWriter, opts BatchOptions) *BatchWriter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 50000
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = time.Second
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &BatchWriter{
+		name:   name,
+		next:   next,
+		opts:   opts,
+		queue:  make(chan *influxdb2write.Point, opts.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// WritePoint enqueues points without blocking. Points that do not fit in
+// the queue are dropped and reported in the returned *queueFullError.
+func (b *BatchWriter) WritePoint(_ context.Context, point ...*influxdb2write.Point) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errBatchWriterClosed
+	}
+	for i, p := range point {
+		select {
+		case b.queue <- p:
+		default:
+			dropped := len(point) - i
+			promQueueDropped.WithLabelValues(b.name, "queue_full").Add(float64(dropped))
+			promQueueDepth.WithLabelValues(b.name).Set(float64(len(b.queue)))
+			return &queueFullError{sink: b.name, dropped: dropped}
+		}
+	}
+	promQueueDepth.WithLabelValues(b.name).Set(float64(len(b.queue)))
+	return nil
+}
+
+// Close stops accepting points and waits until everything queued has been
+// written. If ctx expires first, pending retries are abandoned and the
+// remaining points are dropped.
+func (b *BatchWriter) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		<-b.done
+		return fmt.Errorf("drain %s write queue: %w", b.name, ctx.Err())
+	}
+}
+
+func (b *BatchWriter) run() {
+	defer close(b.done)
+	defer b.cancel()
+
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*influxdb2write.Point, 0, b.opts.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			b.write(batch)
+			batch = make([]*influxdb2write.Point, 0, b.opts.BatchSize)
+		}
+		promQueueDepth.WithLabelValues(b.name).Set(float64(len(b.queue)))
+	}
+	for {
+		select {
+		case p, ok := <-b.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, p)
+			if len(batch) >= b.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// write sends one batch, retrying transient failures.
+func (b *BatchWriter) write(batch []*influxdb2write.Point) {
+	delay := b.opts.RetryBase
+	for attempt := 0; ; attempt++ {
+		err := b.next.WritePoint(b.ctx, batch...)
+		if err == nil {
+			return
+		}
+		if attempt >= b.opts.MaxRetries || !retryableWriteError(err) || b.ctx.Err() != nil {
+			promQueueDropped.WithLabelValues(b.name, "write_failed").Add(float64(len(batch)))
+			slog.Error("Batch write failed; dropping points",
+				"sink", b.name, "points", len(batch), "attempts", attempt+1, "error", err)
+			return
+		}
+		promQueueRetries.WithLabelValues(b.name).Inc()
+		slog.Warn("Batch write failed; retrying",
+			"sink", b.name, "points", len(batch), "retry_in", delay, "error", err)
+		select {
+		case <-b.ctx.Done():
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, b.opts.RetryMax)
+	}
+}
+
+// retryableWriteError reports whether a failed write may succeed if retried.
+// Requests rejected by InfluxDB as invalid (4xx other than 429), and points
+// that cannot be encoded, never will.
+func retryableWriteError(err error) bool {
+	if errors.Is(err, errNoEncodablePoints) {
+		return false
+	}
+	var ie *InfluxError
+	if errors.As(err, &ie) {
+		return ie.StatusCode == http.StatusTooManyRequests || ie.StatusCode >= 500
+	}
+	return true
+}
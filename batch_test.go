@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecorder is a concurrency-safe PointWriter recording batch sizes.
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches []int
+	fail    func(call int) error // optional; called with the 1-based call number
+	calls   int
+}
+
+func (r *batchRecorder) WritePoint(_ context.Context, point ...*influxdb2write.Point) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.fail != nil {
+		if err := r.fail(r.calls); err != nil {
+			return err
+		}
+	}
+	r.batches = append(r.batches, len(point))
+	return nil
+}
+
+func (r *batchRecorder) written() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.batches...)
+}
+
+func testPoints(n int) []*influxdb2write.Point {
+	pts := make([]*influxdb2write.Point, n)
+	for i := range pts {
+		pts[i] = influxdb2.NewPointWithMeasurement("m").AddField("v", i)
+	}
+	return pts
+}
+
+func TestBatchWriter_BatchesAndDrainsOnClose(t *testing.T) {
+	t.Parallel()
+
+	rec := &batchRecorder{}
+	b := NewBatchWriter("test-batch-size", rec, BatchOptions{BatchSize: 3, FlushInterval: time.Hour})
+	require.NoError(t, b.WritePoint(context.Background(), testPoints(7)...))
+	require.NoError(t, b.Close(context.Background()))
+
+	assert.Equal(t, []int{3, 3, 1}, rec.written(), "the partial batch is flushed on close")
+	assert.ErrorIs(t, b.WritePoint(context.Background(), testPoints(1)...), errBatchWriterClosed)
+}
+
+func TestBatchWriter_FlushInterval(t *testing.T) {
+	t.Parallel()
+
+	rec := &batchRecorder{}
+	b := NewBatchWriter("test-batch-interval", rec, BatchOptions{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer func() { _ = b.Close(context.Background()) }()
+
+	require.NoError(t, b.WritePoint(context.Background(), testPoints(2)...))
+	assert.Eventually(t, func() bool { return len(rec.written()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{2}, rec.written())
+}
+
+func TestBatchWriter_QueueFullDrops(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	blocking := &batchRecorder{fail: func(int) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}}
+	dropped := testutil.ToFloat64(promQueueDropped.WithLabelValues("test-batch-full", "queue_full"))
+	b := NewBatchWriter("test-batch-full", blocking, BatchOptions{BatchSize: 1, QueueSize: 2, FlushInterval: time.Hour})
+
+	require.NoError(t, b.WritePoint(context.Background(), testPoints(1)...))
+	<-started // the writer holds one point; the queue is empty again
+	require.NoError(t, b.WritePoint(context.Background(), testPoints(2)...))
+
+	err := b.WritePoint(context.Background(), testPoints(3)...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dropped 3 points")
+	var full *queueFullError
+	require.ErrorAs(t, err, &full)
+	assert.Equal(t, 3, full.dropped)
+	assert.Equal(t, dropped+3, testutil.ToFloat64(promQueueDropped.WithLabelValues("test-batch-full", "queue_full")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(promQueueDepth.WithLabelValues("test-batch-full")))
+
+	close(release)
+	require.NoError(t, b.Close(context.Background()))
+	assert.Equal(t, []int{1, 1, 1}, blocking.written())
+}
+
+func TestBatchWriter_RetriesTransientErrors(t *testing.T) {
+	t.Parallel()
+
+	rec := &batchRecorder{fail: func(call int) error {
+		if call <= 2 {
+			return &InfluxError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	}}
+	retries := testutil.ToFloat64(promQueueRetries.WithLabelValues("test-batch-retry"))
+	b := NewBatchWriter("test-batch-retry", rec, BatchOptions{BatchSize: 5, MaxRetries: 3, RetryBase: time.Millisecond})
+	require.NoError(t, b.WritePoint(context.Background(), testPoints(5)...))
+	require.NoError(t, b.Close(context.Background()))
+
+	assert.Equal(t, []int{5}, rec.written())
+	assert.Equal(t, retries+2, testutil.ToFloat64(promQueueRetries.WithLabelValues("test-batch-retry")))
+}
+
+func TestBatchWriter_DropsAfterPermanentError(t *testing.T) {
+	t.Parallel()
+
+	rec := &batchRecorder{fail: func(int) error {
+		return &InfluxError{StatusCode: http.StatusBadRequest, Message: "bad line"}
+	}}
+	dropped := testutil.ToFloat64(promQueueDropped.WithLabelValues("test-batch-permanent", "write_failed"))
+	b := NewBatchWriter("test-batch-permanent", rec, BatchOptions{BatchSize: 4, MaxRetries: 3, RetryBase: time.Millisecond})
+	require.NoError(t, b.WritePoint(context.Background(), testPoints(4)...))
+	require.NoError(t, b.Close(context.Background()))
+
+	assert.Equal(t, 1, rec.calls, "4xx is not retried")
+	assert.Equal(t, dropped+4, testutil.ToFloat64(promQueueDropped.WithLabelValues("test-batch-permanent", "write_failed")))
+}
+
+func TestBatchWriter_CloseTimeoutAbandonsRetries(t *testing.T) {
+	t.Parallel()
+
+	rec := &batchRecorder{fail: func(int) error { return errors.New("connection refused") }}
+	dropped := testutil.ToFloat64(promQueueDropped.WithLabelValues("test-batch-timeout", "write_failed"))
+	b := NewBatchWriter("test-batch-timeout", rec, BatchOptions{MaxRetries: 100, RetryBase: time.Hour})
+	require.NoError(t, b.WritePoint(context.Background(), testPoints(1)...))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := b.Close(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, dropped+1, testutil.ToFloat64(promQueueDropped.WithLabelValues("test-batch-timeout", "write_failed")))
+}
+
+func TestRetryableWriteError(t *testing.T) {
+	t.Parallel()
+	assert.True(t, retryableWriteError(errors.New("connection reset")))
+	assert.True(t, retryableWriteError(&InfluxError{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, retryableWriteError(&InfluxError{StatusCode: http.StatusBadGateway}))
+	assert.False(t, retryableWriteError(&InfluxError{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, retryableWriteError(fmt.Errorf("%w: NaN", errNoEncodablePoints)))
+}
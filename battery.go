@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// socHysteresis is how far, in SOC percentage points, the charge must
+	// move against the current direction before a discharge is considered to
+	// have started or ended. It keeps ±1% jitter from splitting one discharge
+	// into many shallow ones.
+	socHysteresis = 2
+
+	// minCapacitySwing is the smallest discharge, in SOC percentage points,
+	// used to estimate usable capacity; shallower ones are dominated by SOC
+	// rounding.
+	minCapacitySwing = 20
+
+	// capacitySmoothing weights each new usable capacity estimate against
+	// the running one.
+	capacitySmoothing = 0.2
+
+	// minEfficiencyWh is how much charge must have been seen before a
+	// round-trip efficiency is reported.
+	minEfficiencyWh = 1000
+
+	// maxIntegrationGap is the longest interval between snapshots that is
+	// integrated into energy totals; longer gaps (outages, restarts) are
+	// skipped rather than extrapolated.
+	maxIntegrationGap = 5 * time.Minute
+
+	// batterySaveInterval is how often the analytics state is written to
+	// battery_state_path while running; it is also written on shutdown.
+	batterySaveInterval = 5 * time.Minute
+
+	batteryStateVersion = 1
+)
+
+// dodBuckets are the depth-of-discharge histogram buckets, in SOC
+// percentage points; the bucket for depth d is d/10, capped at the last.
+var dodBuckets = [10]string{"0_10", "10_20", "20_30", "30_40", "40_50", "50_60", "60_70", "70_80", "80_90", "90_100"}
+
+var (
+	promBatteryCycles = promFactory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "envoy_exporter",
+		Name:      "battery_equivalent_full_cycles",
+		Help:      "Equivalent full cycles per battery unit, from state of charge changes.",
+	}, []string{"serial"})
+	promBatteryEfficiency = promFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "envoy_exporter",
+		Name:      "battery_round_trip_efficiency",
+		Help:      "Battery round-trip efficiency (energy out / energy in) since tracking began.",
+	})
+	promBatteryUsableCapacity = promFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "envoy_exporter",
+		Name:      "battery_usable_capacity_wh",
+		Help:      "Estimated usable battery capacity from measured discharges.",
+	})
+)
+
+// batteryAnalytics derives battery health figures from successive readings.
+// Like latest it is process-wide; run loads and saves its state so the
+// figures survive restarts.
+var batteryAnalytics = newBatteryTracker("")
+
+// socSwing follows one state of charge series and completes a discharge each
+// time the charge turns upwards again after falling.
+type socSwing struct {
+	Seen        bool `json:"seen"`
+	Last        int  `json:"last"`
+	Peak        int  `json:"peak"` // highest SOC since the last discharge ended
+	Low         int  `json:"low"`  // lowest SOC of the current discharge
+	Discharging bool `json:"discharging"`
+}
+
+// step records soc. It returns the SOC points discharged since the previous
+// reading and, when a discharge has just ended, its depth.
+func (s *socSwing) step(soc int) (fell, depth int, ended bool) {
+	if !s.Seen {
+		*s = socSwing{Seen: true, Last: soc, Peak: soc, Low: soc}
+		return 0, 0, false
+	}
+	if soc < s.Last {
+		fell = s.Last - soc
+	}
+	s.Last = soc
+	if !s.Discharging {
+		if soc > s.Peak {
+			s.Peak = soc
+		}
+		if soc <= s.Peak-socHysteresis {
+			s.Discharging, s.Low = true, soc
+		}
+		return fell, 0, false
+	}
+	if soc < s.Low {
+		s.Low = soc
+	}
+	if soc >= s.Low+socHysteresis {
+		depth = s.Peak - s.Low
+		s.Discharging, s.Peak = false, soc
+		return fell, depth, true
+	}
+	return fell, 0, false
+}
+
+// batteryUnitState is the analytics state of one battery unit.
+type batteryUnitState struct {
+	Swing         socSwing             `json:"swing"`
+	DischargedPct float64              `json:"discharged_pct"` // SOC points discharged, summed
+	DoD           [len(dodBuckets)]int `json:"dod"`            // completed discharges by depth
+}
+
+// cycles is the number of equivalent full cycles: SOC points discharged / 100.
+func (u batteryUnitState) cycles() float64 { return u.DischargedPct / 100 }
+
+// batterySystemState is the analytics state of the battery system as a
+// whole, from the live data snapshot.
+type batterySystemState struct {
+	LastAt       time.Time `json:"last_at"`
+	LastW        float64   `json:"last_w"`                // positive discharging, negative charging
+	BaselineWh   *int      `json:"baseline_wh,omitempty"` // stored energy when tracking began
+	StoredWh     int       `json:"stored_wh"`
+	ChargedWh    float64   `json:"charged_wh"`
+	DischargedWh float64   `json:"discharged_wh"`
+
+	Swing     socSwing `json:"swing"`
+	RunWh     float64  `json:"run_wh"` // net energy discharged since the last peak
+	UsableWh  float64  `json:"usable_wh"`
+	Estimates int      `json:"estimates"`
+}
+
+// efficiency is energy discharged over energy charged, not counting charge
+// still held in the battery. It reports false until enough charge has been
+// seen.
+func (s *batterySystemState) efficiency() (float64, bool) {
+	if s.BaselineWh == nil || s.ChargedWh < minEfficiencyWh {
+		return 0, false
+	}
+	in := s.ChargedWh - float64(s.StoredWh-*s.BaselineWh)
+	if in <= 0 {
+		return 0, false
+	}
+	return s.DischargedWh / in, true
+}
+
+// batteryStateFile is the on-disk form of the analytics state.
+type batteryStateFile struct {
+	Version int                          `json:"version"`
+	SavedAt time.Time                    `json:"saved_at"`
+	System  batterySystemState           `json:"system"`
+	Units   map[string]*batteryUnitState `json:"units"`
+}
+
+// batteryTracker accumulates battery analytics and persists them to path.
+type batteryTracker struct {
+	mu      sync.Mutex
+	path    string // empty: not persisted
+	system  batterySystemState
+	units   map[string]*batteryUnitState
+	savedAt time.Time
+}
+
+func newBatteryTracker(path string) *batteryTracker {
+	return &batteryTracker{path: path, units: make(map[string]*batteryUnitState)}
+}
+
+// load sets the state file and restores the state saved in it. A missing
+// file is not an error: tracking starts fresh.
+func (b *batteryTracker) load(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read battery state: %w", err)
+	}
+	var st batteryStateFile
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("decode battery state %s: %w", path, err)
+	}
+	if st.Version != batteryStateVersion {
+		return fmt.Errorf("battery state %s: unsupported version %d", path, st.Version)
+	}
+	b.system = st.System
+	b.units = st.Units
+	if b.units == nil {
+		b.units = make(map[string]*batteryUnitState)
+	}
+	b.savedAt = st.SavedAt
+	return nil
+}
+
+// save writes the state to the state file via a temporary file and rename,
+// so a crash mid-write leaves the previous state intact.
+func (b *batteryTracker) save(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(batteryStateFile{
+		Version: batteryStateVersion,
+		SavedAt: now,
+		System:  b.system,
+		Units:   b.units,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode battery state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), ".battery-state-*")
+	if err != nil {
+		return fmt.Errorf("create battery state: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }() // no-op after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write battery state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write battery state: %w", err)
+	}
+	if err := os.Rename(tmpName, b.path); err != nil {
+		return fmt.Errorf("replace battery state: %w", err)
+	}
+	b.savedAt = now
+	return nil
+}
+
+// saveIfDue saves the state when batterySaveInterval has passed since the
+// last save. Failures are logged: analytics must not fail a scrape.
+func (b *batteryTracker) saveIfDue(now time.Time) {
+	b.mu.Lock()
+	due := b.path != "" && now.Sub(b.savedAt) >= batterySaveInterval
+	b.mu.Unlock()
+	if !due {
+		return
+	}
+	if err := b.save(now); err != nil {
+		slog.Error("Failed to save battery analytics state", "error", err)
+	}
+}
+
+// observeSnapshot integrates battery power from a live data snapshot into
+// the charge and discharge totals and follows the aggregate state of charge
+// to estimate usable capacity.
+func (b *batteryTracker) observeSnapshot(snap gateway.EnergySnapshot, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &b.system
+	if s.BaselineWh == nil {
+		baseline := snap.BatteryWh
+		s.BaselineWh = &baseline
+	}
+	if dt := t.Sub(s.LastAt); !s.LastAt.IsZero() && dt > 0 && dt <= maxIntegrationGap {
+		// Average the power at both ends of the interval.
+		wh := (s.LastW + snap.BatteryW) / 2 * dt.Hours()
+		if wh > 0 {
+			s.DischargedWh += wh
+		} else {
+			s.ChargedWh -= wh
+		}
+		s.RunWh += wh
+	}
+	s.LastAt, s.LastW, s.StoredWh = t, snap.BatteryW, snap.BatteryWh
+
+	_, depth, ended := s.Swing.step(snap.BatterySOC)
+	if ended && depth >= minCapacitySwing {
+		est := s.RunWh / float64(depth) * 100
+		if s.Estimates == 0 {
+			s.UsableWh = est
+		} else {
+			s.UsableWh += capacitySmoothing * (est - s.UsableWh)
+		}
+		s.Estimates++
+	}
+	if !s.Swing.Discharging && s.Swing.Last >= s.Swing.Peak {
+		// At a new peak: the next discharge's energy is counted from here.
+		s.RunWh = 0
+	}
+	if s.Estimates > 0 {
+		promBatteryUsableCapacity.Set(s.UsableWh)
+	}
+	if eff, ok := s.efficiency(); ok {
+		promBatteryEfficiency.Set(eff)
+	}
+}
+
+// observeUnits follows each unit's state of charge, counting discharged
+// percentage points and completed discharges by depth.
+func (b *batteryTracker) observeUnits(batteries []gateway.BatteryStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, bat := range batteries {
+		u, ok := b.units[bat.SerialNum]
+		if !ok {
+			u = &batteryUnitState{}
+			b.units[bat.SerialNum] = u
+		}
+		fell, depth, ended := u.Swing.step(bat.PercentFull)
+		u.DischargedPct += float64(fell)
+		if ended {
+			u.DoD[min(depth/10, len(dodBuckets)-1)]++
+		}
+		promBatteryCycles.WithLabelValues(bat.SerialNum).Set(u.cycles())
+	}
+}
+
+// batteryReport is a copy of the analytics for the units in one scrape.
+type batteryReport struct {
+	System      batterySystemState
+	Units       map[string]batteryUnitState
+	NameplateWh int // summed nameplate capacity of the units
+}
+
+// report returns the analytics for batteries.
+func (b *batteryTracker) report(batteries []gateway.BatteryStatus) batteryReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := batteryReport{System: b.system, Units: make(map[string]batteryUnitState, len(batteries))}
+	for _, bat := range batteries {
+		if u, ok := b.units[bat.SerialNum]; ok {
+			r.Units[bat.SerialNum] = *u
+		}
+		r.NameplateWh += bat.CapacityWh
+	}
+	return r
+}
+
+// extractBatteryAnalyticsPoints builds one analytics point per battery unit
+// (equivalent full cycles and depth-of-discharge histogram) and one for the
+// battery system (energy totals, round-trip efficiency and usable capacity).
+func extractBatteryAnalyticsPoints(batteries []gateway.BatteryStatus, r batteryReport, schema *Schema, t time.Time) []*influxdb2write.Point {
+	var ps []*influxdb2write.Point
+	for _, bat := range batteries {
+		u, ok := r.Units[bat.SerialNum]
+		if !ok {
+			continue
+		}
+		k := pointKey{Family: FamilyBatteryAnalytics, Serial: bat.SerialNum, Legacy: "battery-analytics"}
+		pt := schema.newPoint(k, t).
+			AddTag(TagSerial, bat.SerialNum).
+			AddField("equivalent_cycles", u.cycles())
+		for i, name := range dodBuckets {
+			pt.AddField("dod_"+name, u.DoD[i])
+		}
+		ps = append(ps, schema.renameFields(FamilyBatteryAnalytics, pt))
+	}
+
+	s := r.System
+	pt := schema.newPoint(pointKey{Family: FamilyBatteryAnalytics, Legacy: "battery-analytics"}, t).
+		AddField("charged_wh", s.ChargedWh).
+		AddField("discharged_wh", s.DischargedWh)
+	if eff, ok := s.efficiency(); ok {
+		pt.AddField("round_trip_efficiency", eff)
+	}
+	if s.Estimates > 0 {
+		pt.AddField("usable_capacity_wh", s.UsableWh)
+		if r.NameplateWh > 0 {
+			pt.AddField("capacity_ratio", s.UsableWh/float64(r.NameplateWh))
+		}
+	}
+	return append(ps, schema.renameFields(FamilyBatteryAnalytics, pt))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSOCSwing(t *testing.T) {
+	t.Parallel()
+
+	var s socSwing
+	var depths []int
+	var fell int
+	// 90 → 89 → 90 is jitter; 90 → 40 → 41 → 80 is one 50-point discharge.
+	for _, soc := range []int{90, 89, 90, 70, 40, 41, 80, 79, 60, 58, 60} {
+		f, depth, ended := s.step(soc)
+		fell += f
+		if ended {
+			depths = append(depths, depth)
+		}
+	}
+	assert.Equal(t, []int{50, 22}, depths)
+	assert.Equal(t, 1+50+22, fell)
+}
+
+func TestBatteryTracker_Units(t *testing.T) {
+	t.Parallel()
+
+	b := newBatteryTracker("")
+	for _, soc := range []int{100, 50, 100, 5, 100} {
+		b.observeUnits([]gateway.BatteryStatus{{SerialNum: "B1", PercentFull: soc}, {SerialNum: "B2", PercentFull: 100}})
+	}
+	r := b.report([]gateway.BatteryStatus{{SerialNum: "B1", CapacityWh: 3360}, {SerialNum: "B2", CapacityWh: 3360}})
+	u := r.Units["B1"]
+	assert.InDelta(t, 1.45, u.cycles(), 1e-9)
+	assert.Equal(t, 1, u.DoD[5], "50 points deep")
+	assert.Equal(t, 1, u.DoD[9], "95 points deep")
+	assert.Zero(t, r.Units["B2"].cycles())
+	assert.Equal(t, 6720, r.NameplateWh)
+}
+
+func TestBatteryTracker_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	b := newBatteryTracker("")
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	step := func(i int, w float64, soc, wh int) {
+		b.observeSnapshot(gateway.EnergySnapshot{BatteryW: w, BatterySOC: soc, BatteryWh: wh}, t0.Add(time.Duration(i)*time.Minute))
+	}
+	// Charge at 6 kW for an hour (6 kWh in, 40% → 100%), then discharge
+	// 4.8 kWh at 4.8 kW back down to 50% and recharge a little.
+	i := 0
+	step(i, -6000, 40, 4000)
+	for ; i < 60; i++ {
+		step(i+1, -6000, 40+i+1, 4000+(i+1)*100)
+	}
+	step(i, 4800, 100, 10000)
+	for j := 1; j <= 60; j++ {
+		i++
+		step(i, 4800, 100-(j*50/60), 10000-j*80)
+	}
+	i++
+	step(i, -1000, 53, 5300)
+
+	s := b.report(nil).System
+	assert.InDelta(t, 6000, s.ChargedWh, 60)
+	assert.InDelta(t, 4800, s.DischargedWh, 60)
+	eff, ok := s.efficiency()
+	require.True(t, ok)
+	// 6 kWh charged, of which 1.3 kWh is still stored: 4.8 / 4.7.
+	assert.InDelta(t, 4.8/4.7, eff, 0.03)
+	assert.Equal(t, 1, s.Estimates)
+	assert.InDelta(t, 9600, s.UsableWh, 200, "4.8 kWh for 50 points")
+}
+
+func TestBatteryTracker_SkipsGaps(t *testing.T) {
+	t.Parallel()
+
+	b := newBatteryTracker("")
+	t0 := time.Now()
+	b.observeSnapshot(gateway.EnergySnapshot{BatteryW: 5000}, t0)
+	b.observeSnapshot(gateway.EnergySnapshot{BatteryW: 5000}, t0.Add(time.Hour))
+	assert.Zero(t, b.report(nil).System.DischargedWh)
+}
+
+func TestBatteryTracker_Persistence(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "battery.json")
+	b := newBatteryTracker("")
+	require.NoError(t, b.load(path), "missing state file starts fresh")
+	for _, soc := range []int{100, 60, 100} {
+		b.observeUnits([]gateway.BatteryStatus{{SerialNum: "B1", PercentFull: soc}})
+	}
+	require.NoError(t, b.save(time.Now()))
+
+	restored := newBatteryTracker("")
+	require.NoError(t, restored.load(path))
+	u := restored.report([]gateway.BatteryStatus{{SerialNum: "B1"}}).Units["B1"]
+	assert.InDelta(t, 0.4, u.cycles(), 1e-9)
+	assert.Equal(t, 1, u.DoD[4])
+	assert.Equal(t, 100, u.Swing.Last)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	assert.Error(t, newBatteryTracker("").load(path))
+}
+
+func TestExtractBatteryAnalyticsPoints(t *testing.T) {
+	t.Parallel()
+
+	baseline := 5000
+	r := batteryReport{
+		System: batterySystemState{
+			BaselineWh: &baseline, StoredWh: 5000, ChargedWh: 2000, DischargedWh: 1800,
+			UsableWh: 9000, Estimates: 2,
+		},
+		Units:       map[string]batteryUnitState{"B1": {DischargedPct: 250, DoD: [10]int{3: 2}}},
+		NameplateWh: 10000,
+	}
+	batteries := []gateway.BatteryStatus{{SerialNum: "B1"}}
+	pts := extractBatteryAnalyticsPoints(batteries, r, &Schema{Source: "home"}, time.Now())
+	require.Len(t, pts, 2)
+
+	assert.Equal(t, "battery-analytics", pts[0].Name())
+	assert.Equal(t, "B1", tagMap(pts[0])[TagSerial])
+	unit := fieldMap(pts[0])
+	assert.Equal(t, 2.5, unit["equivalent_cycles"])
+	assert.Equal(t, int64(2), unit["dod_30_40"])
+	assert.Equal(t, int64(0), unit["dod_90_100"])
+
+	assert.Equal(t, "battery-analytics", pts[1].Name())
+	assert.NotContains(t, tagMap(pts[1]), TagSerial, "the system point has no serial")
+	sys := fieldMap(pts[1])
+	assert.Equal(t, 0.9, sys["round_trip_efficiency"])
+	assert.Equal(t, 9000.0, sys["usable_capacity_wh"])
+	assert.Equal(t, 0.9, sys["capacity_ratio"])
+
+	// Before enough data: totals only.
+	pts = extractBatteryAnalyticsPoints(nil, batteryReport{}, &Schema{Source: "home"}, time.Now())
+	require.Len(t, pts, 1)
+	assert.NotContains(t, fieldMap(pts[0]), "round_trip_efficiency")
+	assert.NotContains(t, fieldMap(pts[0]), "usable_capacity_wh")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayClient_GetJSON(t *testing.T) {
+	t.Parallel()
+
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/ok":
+			_, _ = w.Write([]byte(`{"a": 1}`))
+		case "/bad":
+			_, _ = w.Write([]byte(`not json`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &Config{Address: srv.URL, JWT: "first"}
+	c := newGatewayClient(cfg)
+
+	var out struct{ A int }
+	require.NoError(t, c.getJSON(context.Background(), "/ok", &out))
+	assert.Equal(t, 1, out.A)
+	assert.Equal(t, "Bearer first", gotAuth)
+
+	cfg.SetJWT("refreshed")
+	require.NoError(t, c.getJSON(context.Background(), "/ok", &out))
+	assert.Equal(t, "Bearer refreshed", gotAuth, "refreshed JWT is used")
+
+	err := c.getJSON(context.Background(), "/missing", &out)
+	assert.True(t, gateway.IsNotFound(err), "404 is a gateway.Error: %v", err)
+
+	err = c.getJSON(context.Background(), "/bad", &out)
+	require.Error(t, err)
+	assert.Equal(t, ErrClassDecode, errorClass(err))
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// defaultClippingTolerance is the fraction below the rated limit that still
+// counts as clipping: inverters regulate slightly under their nameplate.
+const defaultClippingTolerance = 0.02
+
+// maxClipGap is the longest interval between reports counted towards clipped
+// time. Microinverters report about every 5 minutes.
+const maxClipGap = 15 * time.Minute
+
+// ClippingConfig configures solar clipping detection. Detection is enabled
+// when any inverter has a rated limit or system_limit_w is set.
+type ClippingConfig struct {
+	Models       map[string]float64 `yaml:"models"`         // rated AC output in W by inverter model
+	DefaultModel string             `yaml:"default_model"`  // model of inverters without an inverter_model
+	SystemLimitW float64            `yaml:"system_limit_w"` // system AC limit; default: sum of the inverter limits
+	Tolerance    float64            `yaml:"tolerance"`      // fraction of the limit; default 0.02
+}
+
+// validate checks that every referenced model has a rating.
+func (c ClippingConfig) validate(devices map[string]DeviceMetadata) error {
+	if c.Tolerance < 0 || c.Tolerance >= 0.5 {
+		return fmt.Errorf("clipping: tolerance %v must be between 0 and 0.5", c.Tolerance)
+	}
+	if c.SystemLimitW < 0 {
+		return fmt.Errorf("clipping: system_limit_w must not be negative")
+	}
+	for model, w := range c.Models {
+		if w <= 0 {
+			return fmt.Errorf("clipping: model %q: rated output must be positive", model)
+		}
+	}
+	if c.DefaultModel != "" {
+		if _, ok := c.Models[c.DefaultModel]; !ok {
+			return fmt.Errorf("clipping: default_model %q is not in models", c.DefaultModel)
+		}
+	}
+	if len(c.Models) == 0 {
+		return nil
+	}
+	for serial, d := range devices {
+		if _, ok := c.Models[d.InverterModel]; d.InverterModel != "" && !ok {
+			return fmt.Errorf("devices: %s: inverter_model %q is not in clipping models", serial, d.InverterModel)
+		}
+	}
+	return nil
+}
+
+// solarClipping detects clipping in inverter and system output. Like latest
+// it is process-wide; run configures it.
+var solarClipping = newClippingTracker(ClippingConfig{}, nil, nil)
+
+// clipSeries follows one power series (an inverter or the whole system)
+// against its rated limit.
+type clipSeries struct {
+	limitW float64
+	seen   bool
+
+	prevW  float64
+	prevAt time.Time
+
+	clipping   bool
+	since      time.Time // start of the current episode
+	entrySlope float64   // W/s rise into the current episode
+
+	clipped time.Duration // today
+	lostWh  float64       // today, completed episodes
+}
+
+// observe records power w at time at. Between two readings the interval
+// counts as clipped in proportion to how many of its ends are clipped.
+// When an episode ends, the energy lost to it is estimated from the ramps
+// into and out of the plateau.
+func (s *clipSeries) observe(w float64, at time.Time, tolerance float64) {
+	if s.seen && !at.After(s.prevAt) {
+		return // no new report
+	}
+	enter := w >= s.limitW*(1-tolerance)
+	exit := w < s.limitW*(1-2*tolerance) // hysteresis against noise at the limit
+	clipNow := enter || (s.clipping && !exit)
+
+	dt := at.Sub(s.prevAt)
+	valid := s.seen && dt <= maxClipGap
+	if valid {
+		ends := 0
+		if s.clipping {
+			ends++
+		}
+		if clipNow {
+			ends++
+		}
+		s.clipped += dt * time.Duration(ends) / 2
+	}
+	switch {
+	case clipNow && !s.clipping:
+		s.since, s.entrySlope = at, 0
+		if valid {
+			s.entrySlope = max(0, (w-s.prevW)/dt.Seconds())
+		}
+	case !clipNow && s.clipping:
+		exitSlope := 0.0
+		if valid {
+			exitSlope = max(0, (s.prevW-w)/dt.Seconds())
+		}
+		s.lostWh += lostEnergyWh(s.entrySlope, exitSlope, at.Sub(s.since), s.limitW)
+	}
+	s.clipping, s.seen, s.prevW, s.prevAt = clipNow, true, w, at
+}
+
+// lostEnergyWh estimates the energy clipped off a plateau of length d. The
+// unclipped output is modelled as a peak: rising past the limit at the entry
+// slope and falling back at the exit slope (either standing in for the other
+// when unknown). The peak is capped at twice the limit so a cloud edge
+// before the plateau cannot inflate the estimate.
+func lostEnergyWh(entry, exit float64, d time.Duration, limitW float64) float64 {
+	switch {
+	case entry <= 0 && exit <= 0:
+		return 0
+	case entry <= 0:
+		entry = exit
+	case exit <= 0:
+		exit = entry
+	}
+	secs := d.Seconds()
+	peak := min(entry*exit*secs/(entry+exit), limitW)
+	return peak * secs / 2 / 3600
+}
+
+// clippingDay is one series' clipping over a whole day.
+type clippingDay struct {
+	Day     time.Time // midnight in the site's zone
+	Serial  string    // empty for the system
+	Clipped time.Duration
+	LostWh  float64
+}
+
+// clippingTracker holds the clipping state of every inverter and the system
+// and summarises each day when it ends.
+type clippingTracker struct {
+	mu        sync.Mutex
+	cfg       ClippingConfig
+	devices   map[string]DeviceMetadata
+	zone      *time.Location // of the site, for day boundaries
+	day       time.Time
+	inverters map[string]*clipSeries
+	system    clipSeries
+	pending   []clippingDay
+}
+
+// newClippingTracker returns a tracker whose days end at midnight in loc's
+// time zone.
+func newClippingTracker(cfg ClippingConfig, devices map[string]DeviceMetadata, loc *Location) *clippingTracker {
+	if cfg.Tolerance == 0 {
+		cfg.Tolerance = defaultClippingTolerance
+	}
+	return &clippingTracker{cfg: cfg, devices: devices, zone: loc.zone(), inverters: make(map[string]*clipSeries)}
+}
+
+// configure replaces the configuration and clears all state.
+func (c *clippingTracker) configure(cfg ClippingConfig, devices map[string]DeviceMetadata, loc *Location) {
+	fresh := newClippingTracker(cfg, devices, loc)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg, c.devices, c.zone = fresh.cfg, fresh.devices, fresh.zone
+	c.day, c.inverters, c.system, c.pending = time.Time{}, fresh.inverters, clipSeries{}, nil
+}
+
+// enabled reports whether any limit is configured.
+func (c *clippingTracker) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.cfg.Models) > 0 || c.cfg.SystemLimitW > 0
+}
+
+// inverterLimit returns the rated AC output of the inverter, or 0.
+func (c *clippingTracker) inverterLimit(serial string) float64 {
+	model := c.devices[serial].InverterModel
+	if model == "" {
+		model = c.cfg.DefaultModel
+	}
+	return c.cfg.Models[model]
+}
+
+// rollover closes the current day once now falls on a later one, queueing
+// a summary for every series with a limit.
+func (c *clippingTracker) rollover(now time.Time) {
+	day := startOfDay(now, c.zone)
+	if c.day.IsZero() {
+		c.day = day
+		return
+	}
+	if !day.After(c.day) {
+		return
+	}
+	for _, serial := range slices.Sorted(maps.Keys(c.inverters)) {
+		s := c.inverters[serial]
+		c.pending = append(c.pending, clippingDay{Day: c.day, Serial: serial, Clipped: s.clipped, LostWh: s.lostWh})
+		s.clipped, s.lostWh = 0, 0
+	}
+	if c.system.seen {
+		c.pending = append(c.pending, clippingDay{Day: c.day, Clipped: c.system.clipped, LostWh: c.system.lostWh})
+		c.system.clipped, c.system.lostWh = 0, 0
+	}
+	c.day = day
+}
+
+// observeInverters records each inverter's latest report.
+func (c *clippingTracker) observeInverters(inverters []gateway.InverterReading, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollover(now)
+	for _, inv := range inverters {
+		limit := c.inverterLimit(inv.SerialNumber)
+		if limit <= 0 {
+			continue
+		}
+		s, ok := c.inverters[inv.SerialNumber]
+		if !ok {
+			s = &clipSeries{}
+			c.inverters[inv.SerialNumber] = s
+		}
+		s.limitW = limit
+		at := now
+		if inv.LastReportDate > 0 {
+			at = time.Unix(inv.LastReportDate, 0)
+		}
+		s.observe(float64(inv.LastReportWatts), at, c.cfg.Tolerance)
+	}
+}
+
+// observeSystem records total solar output against the system limit: the
+// configured one, or the sum of the known inverter limits.
+func (c *clippingTracker) observeSystem(solarW float64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollover(now)
+	limit := c.cfg.SystemLimitW
+	if limit == 0 {
+		for _, s := range c.inverters {
+			limit += s.limitW
+		}
+	}
+	if limit <= 0 {
+		return
+	}
+	c.system.limitW = limit
+	c.system.observe(solarW, now, c.cfg.Tolerance)
+}
+
+// clippingStatus is the current state of one series.
+type clippingStatus struct {
+	Serial   string
+	Clipping bool
+	LimitW   float64
+	Clipped  time.Duration // today
+	LostWh   float64       // today
+}
+
+// clippingReport is a copy of the tracker state for writing.
+type clippingReport struct {
+	Inverters []clippingStatus // by serial
+	System    *clippingStatus  // nil until the system limit is known
+	Days      []clippingDay    // days completed since the last report
+}
+
+// report returns the current state and takes the queued day summaries.
+func (c *clippingTracker) report() clippingReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var r clippingReport
+	for _, serial := range slices.Sorted(maps.Keys(c.inverters)) {
+		s := c.inverters[serial]
+		r.Inverters = append(r.Inverters, clippingStatus{Serial: serial, Clipping: s.clipping, LimitW: s.limitW, Clipped: s.clipped, LostWh: s.lostWh})
+	}
+	if c.system.seen {
+		r.System = &clippingStatus{Clipping: c.system.clipping, LimitW: c.system.limitW, Clipped: c.system.clipped, LostWh: c.system.lostWh}
+	}
+	r.Days, c.pending = c.pending, nil
+	return r
+}
+
+// extractClippingPoints builds one clipping point per inverter with a known
+// limit, one for the system, and one daily summary point per series for
+// each completed day, timestamped at the start of that day.
+func extractClippingPoints(r clippingReport, schema *Schema, t time.Time) []*influxdb2write.Point {
+	var ps []*influxdb2write.Point
+	clippingCount := 0
+	for _, s := range r.Inverters {
+		if s.Clipping {
+			clippingCount++
+		}
+		pt := clippingPoint(schema, FamilyClipping, s.Serial, t).
+			AddField("clipping", s.Clipping).
+			AddField("limit_w", s.LimitW).
+			AddField("clipped_s", s.Clipped.Seconds()).
+			AddField("lost_wh", s.LostWh)
+		ps = append(ps, schema.renameFields(FamilyClipping, pt))
+	}
+	if s := r.System; s != nil {
+		pt := clippingPoint(schema, FamilyClipping, "", t).
+			AddField("clipping", s.Clipping).
+			AddField("limit_w", s.LimitW).
+			AddField("clipped_s", s.Clipped.Seconds()).
+			AddField("lost_wh", s.LostWh).
+			AddField("inverters_clipping", clippingCount)
+		ps = append(ps, schema.renameFields(FamilyClipping, pt))
+	}
+	for _, d := range r.Days {
+		pt := clippingPoint(schema, FamilyClippingDaily, d.Serial, d.Day).
+			AddField("clipped_s", d.Clipped.Seconds()).
+			AddField("lost_wh", d.LostWh)
+		ps = append(ps, schema.renameFields(FamilyClippingDaily, pt))
+	}
+	return ps
+}
+
+// clippingPoint starts a point of family for the inverter serial, or for the
+// system when serial is empty. The measurement is the family in both
+// layouts.
+func clippingPoint(schema *Schema, family, serial string, t time.Time) *influxdb2write.Point {
+	if serial == "" {
+		return schema.newPoint(pointKey{Family: family, Legacy: family}, t)
+	}
+	k := pointKey{Family: family, Serial: serial, Legacy: family}
+	return schema.newPoint(k, t).AddTag(TagSerial, serial)
+}
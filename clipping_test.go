@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClippingConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	models := map[string]float64{"IQ8PLUS": 290}
+	assert.NoError(t, ClippingConfig{}.validate(nil))
+	assert.NoError(t, ClippingConfig{Models: models, DefaultModel: "IQ8PLUS"}.validate(
+		map[string]DeviceMetadata{"1": {InverterModel: "IQ8PLUS"}, "2": {Array: "east"}}))
+
+	for name, c := range map[string]struct {
+		cfg     ClippingConfig
+		devices map[string]DeviceMetadata
+	}{
+		"tolerance":      {cfg: ClippingConfig{Tolerance: 0.5}},
+		"negative limit": {cfg: ClippingConfig{SystemLimitW: -1}},
+		"zero rating":    {cfg: ClippingConfig{Models: map[string]float64{"IQ7": 0}}},
+		"default model":  {cfg: ClippingConfig{Models: models, DefaultModel: "IQ7"}},
+		"device model":   {cfg: ClippingConfig{Models: models}, devices: map[string]DeviceMetadata{"1": {InverterModel: "IQ7"}}},
+	} {
+		assert.Error(t, c.cfg.validate(c.devices), name)
+	}
+}
+
+func TestLostEnergyWh(t *testing.T) {
+	t.Parallel()
+
+	// Rising and falling at 1 W/min over a 2 h plateau: a 60 W peak.
+	slope := 1.0 / 60
+	assert.InDelta(t, 60.0, lostEnergyWh(slope, slope, 2*time.Hour, 300), 1e-9)
+	assert.InDelta(t, 60.0, lostEnergyWh(slope, 0, 2*time.Hour, 300), 1e-9, "missing exit slope")
+	assert.Zero(t, lostEnergyWh(0, 0, 2*time.Hour, 300))
+	// A steep cloud edge is capped at twice the limit.
+	assert.InDelta(t, 300.0, lostEnergyWh(10, 10, 2*time.Hour, 300), 1e-9)
+}
+
+func TestClipSeries(t *testing.T) {
+	t.Parallel()
+
+	s := clipSeries{limitW: 300}
+	t0 := time.Date(2026, 6, 1, 11, 0, 0, 0, time.UTC)
+	for i, w := range []float64{270, 285, 296, 299, 291, 297, 280, 270} {
+		s.observe(w, t0.Add(time.Duration(i)*5*time.Minute), defaultClippingTolerance)
+		if i == 4 {
+			assert.True(t, s.clipping, "291 W is within the hysteresis band")
+		}
+	}
+	assert.False(t, s.clipping)
+	// Clipped from 296 W to 297 W (3 full intervals) plus half of the
+	// intervals entering and leaving.
+	assert.Equal(t, 20*time.Minute, s.clipped)
+	assert.Greater(t, s.lostWh, 0.0)
+
+	before := s.clipped
+	s.observe(300, t0.Add(35*time.Minute), defaultClippingTolerance)
+	assert.Equal(t, before, s.clipped, "a repeated report is not counted again")
+}
+
+func TestClippingTracker(t *testing.T) {
+	t.Parallel()
+
+	c := newClippingTracker(ClippingConfig{
+		Models:       map[string]float64{"IQ8PLUS": 290, "IQ8M": 325},
+		DefaultModel: "IQ8PLUS",
+	}, map[string]DeviceMetadata{"B": {InverterModel: "IQ8M"}}, &Location{Latitude: 40, Longitude: -105, Timezone: "America/Denver"})
+	require.True(t, c.enabled())
+
+	denver, err := time.LoadLocation("America/Denver")
+	require.NoError(t, err)
+	day := time.Date(2026, 6, 1, 12, 0, 0, 0, denver)
+	for i := range 3 {
+		at := day.Add(time.Duration(i) * 5 * time.Minute)
+		c.observeInverters([]gateway.InverterReading{
+			{SerialNumber: "A", LastReportDate: at.Unix(), LastReportWatts: 290},
+			{SerialNumber: "B", LastReportDate: at.Unix(), LastReportWatts: 290},
+		}, at)
+		c.observeSystem(580, at)
+	}
+	r := c.report()
+	require.Len(t, r.Inverters, 2)
+	assert.Equal(t, clippingStatus{Serial: "A", Clipping: true, LimitW: 290, Clipped: 10 * time.Minute}, r.Inverters[0])
+	assert.False(t, r.Inverters[1].Clipping, "B is rated 325 W")
+	require.NotNil(t, r.System)
+	assert.Equal(t, 615.0, r.System.LimitW, "sum of inverter limits")
+	assert.False(t, r.System.Clipping)
+	assert.Empty(t, r.Days)
+
+	next := day.Add(24 * time.Hour)
+	c.observeSystem(0, next)
+	r = c.report()
+	require.Len(t, r.Days, 3)
+	y, m, d := day.Date()
+	assert.Equal(t, clippingDay{Day: time.Date(y, m, d, 0, 0, 0, 0, denver), Serial: "A", Clipped: 10 * time.Minute}, r.Days[0])
+	assert.Empty(t, r.Days[2].Serial, "system summary")
+	assert.Zero(t, r.Inverters[0].Clipped, "today's totals restart")
+	assert.Empty(t, c.report().Days, "summaries are reported once")
+
+	assert.False(t, newClippingTracker(ClippingConfig{}, nil, nil).enabled())
+}
+
+func TestExtractClippingPoints(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2026, 6, 1, 0, 0, 0, 0, time.Local)
+	r := clippingReport{
+		Inverters: []clippingStatus{{Serial: "A", Clipping: true, LimitW: 290, Clipped: time.Hour, LostWh: 12.5}},
+		System:    &clippingStatus{LimitW: 5000},
+		Days:      []clippingDay{{Day: day, Serial: "A", Clipped: 2 * time.Hour, LostWh: 40}, {Day: day}},
+	}
+	pts := extractClippingPoints(r, &Schema{Source: "home"}, day.Add(36*time.Hour))
+	require.Len(t, pts, 4)
+
+	assert.Equal(t, "clipping", pts[0].Name())
+	assert.Equal(t, "A", tagMap(pts[0])[TagSerial])
+	assert.Equal(t, map[string]any{"clipping": true, "limit_w": 290.0, "clipped_s": 3600.0, "lost_wh": 12.5}, fieldMap(pts[0]))
+
+	assert.Equal(t, "clipping", pts[1].Name())
+	assert.Equal(t, int64(1), fieldMap(pts[1])["inverters_clipping"])
+
+	assert.Equal(t, "clipping-daily", pts[2].Name())
+	assert.Equal(t, day, pts[2].Time())
+	assert.Equal(t, 7200.0, fieldMap(pts[2])["clipped_s"])
+	assert.Equal(t, "clipping-daily", pts[3].Name())
+	assert.NotContains(t, tagMap(pts[3]), TagSerial)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"expvar"
 	"fmt"
 	"log/slog"
@@ -9,8 +10,10 @@ import (
 	"time"
 
 	gateway "github.com/hobeone/enphase-gateway"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -34,6 +37,24 @@ const (
 	TagMeasurementType = "measurement-type"
 	TagLineIdx         = "line-idx"
 	TagSerial          = "serial"
+	TagPhase           = "phase"
+	TagMeter           = "meter"
+	TagDeviceType      = "device-type"
+	TagFirmware        = "firmware"
+	TagPartNumber      = "part-number"
+	TagSoftware        = "software"
+	TagCompatibility   = "compatibility"
+	TagEvent           = "event"
+	TagContact         = "contact" // dry contact ID, e.g. NC1
+
+	// Device metadata tag keys; see DeviceMetadata.
+	TagArray      = "array"
+	TagRoofFace   = "roof-face"
+	TagAzimuth    = "azimuth"
+	TagTilt       = "tilt"
+	TagPanelModel = "panel-model"
+
+	TagInverterModel = "inverter-model"
 
 	// Field keys.
 	FieldP    = "P"
@@ -49,6 +70,11 @@ type EnvoyClient interface {
 	Inverters(ctx context.Context) ([]gateway.InverterReading, error)
 	TypedMeterReadings(ctx context.Context) ([]gateway.TypedCTReading, error)
 	BatteryInventory(ctx context.Context) ([]gateway.BatteryStatus, error)
+	Energy(ctx context.Context) (gateway.EnergyData, error)
+	InverterDetails(ctx context.Context) ([]InverterDetail, error)
+	Inventory(ctx context.Context) ([]InventoryDevice, error)
+	Info(ctx context.Context) (GatewayInfo, error)
+	Ensemble(ctx context.Context) (EnsembleStatus, error)
 	EnableHighFrequencyMode(ctx context.Context) error
 }
 
@@ -57,8 +83,57 @@ type PointWriter interface {
 	WritePoint(ctx context.Context, point ...*influxdb2write.Point) error
 }
 
-// ClientFactory creates an EnvoyClient from a Config.
-type ClientFactory func(cfg *Config) (EnvoyClient, error)
+// multiWriter fans each write out to several PointWriters. Every writer is
+// attempted; the returned error joins any individual failures.
+type multiWriter []PointWriter
+
+func (m multiWriter) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	var errs []error
+	for _, w := range m {
+		if err := w.WritePoint(ctx, point...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// trackedWriter records the outcome and latency of every write to the named
+// sink in components, the Prometheus metrics and a trace span.
+type trackedWriter struct {
+	name string
+	next PointWriter
+}
+
+func (t trackedWriter) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "sink."+t.name)
+	err := t.next.WritePoint(ctx, point...)
+	endSpan(span, err)
+	promSinkDuration.WithLabelValues(t.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		promSinkErrors.WithLabelValues(t.name).Inc()
+	}
+	components.recordSink(t.name, err)
+	return err
+}
+
+// acceptedPoints returns how many of n points a write that returned err
+// accepted: all on success, those enqueued before a write queue filled up,
+// and none on any other failure.
+func acceptedPoints(n int, err error) int {
+	var full *queueFullError
+	switch {
+	case err == nil:
+		return n
+	case errors.As(err, &full):
+		return max(n-full.dropped, 0)
+	}
+	return 0
+}
+
+// ClientFactory creates an EnvoyClient from a Config. Gateway calls it makes
+// use ctx, which carries the connect span.
+type ClientFactory func(ctx context.Context, cfg *Config) (EnvoyClient, error)
 
 // scrapeResult summarises the outcome of a single scrape iteration.
 type scrapeResult struct {
@@ -68,10 +143,10 @@ type scrapeResult struct {
 
 // extractLiveDataPoints converts a LiveData response into a single energy-snapshot
 // InfluxDB point capturing solar/battery/grid/load flows and battery state.
-func extractLiveDataPoints(live gateway.LiveData, sourceTag string, t time.Time) []*influxdb2write.Point {
+// With a production model, the clear-sky expected_w is added too.
+func extractLiveDataPoints(live gateway.LiveData, schema *Schema, expected *performanceTracker, t time.Time) []*influxdb2write.Point {
 	snap := gateway.SnapshotFromLiveData(live)
-	pt := influxdb2.NewPointWithMeasurement("energy-snapshot").
-		AddTag(TagSource, sourceTag).
+	pt := schema.newPoint(pointKey{Family: FamilySnapshot, Legacy: "energy-snapshot"}, t).
 		AddField("solar_w", snap.SolarW).
 		AddField("battery_w", snap.BatteryW).
 		AddField("grid_w", snap.GridW).
@@ -82,62 +157,90 @@ func extractLiveDataPoints(live gateway.LiveData, sourceTag string, t time.Time)
 		AddField("solar_to_grid_w", snap.SolarToGrid).
 		AddField("solar_to_batt_w", snap.SolarToBatt).
 		AddField("grid_to_load_w", snap.GridToLoad).
-		AddField("batt_to_load_w", snap.BattToLoad).
-		SetTime(t)
-	return []*influxdb2write.Point{pt}
+		AddField("batt_to_load_w", snap.BattToLoad)
+	if w, ok := expected.expectedW(t); ok {
+		pt.AddField("expected_w", w)
+	}
+	return []*influxdb2write.Point{schema.renameFields(FamilySnapshot, pt)}
 }
 
 // ctChannelToPoint builds an InfluxDB point for a single CT phase channel.
-func ctChannelToPoint(namePrefix, typeTag string, ch gateway.CTChannel, idx int, sourceTag string, t time.Time) *influxdb2write.Point {
-	return influxdb2.NewPointWithMeasurement(fmt.Sprintf("%s-line%d", namePrefix, idx)).
-		AddTag(TagSource, sourceTag).
+func ctChannelToPoint(namePrefix, typeTag string, ch gateway.CTChannel, idx int, schema *Schema, t time.Time) *influxdb2write.Point {
+	line := strconv.Itoa(idx)
+	k := pointKey{Family: FamilyMeter, Type: typeTag, Line: line, Legacy: fmt.Sprintf("%s-line%d", namePrefix, idx)}
+	pt := schema.newPoint(k, t).
 		AddTag(TagMeasurementType, typeTag).
-		AddTag(TagLineIdx, strconv.Itoa(idx)).
+		AddTag(TagLineIdx, line).
 		AddField(FieldP, ch.ActivePower).
 		AddField(FieldQ, ch.ReactivePower).
 		AddField(FieldS, ch.ApparentPower).
 		AddField(FieldIrms, ch.Current).
-		AddField(FieldVrms, ch.Voltage).
-		SetTime(t)
+		AddField(FieldVrms, ch.Voltage)
+	return schema.renameFields(FamilyMeter, pt)
 }
 
 // extractCTPoints converts typed CT readings into per-phase InfluxDB points.
-// Measurement-name prefixes follow the existing InfluxDB schema:
+// Legacy measurement-name prefixes follow the original InfluxDB schema:
 //
 //	"production"        → production-line<N>
 //	"total-consumption" → consumption-line<N>
 //	"net-consumption"   → net-line<N>
-func extractCTPoints(readings []gateway.TypedCTReading, sourceTag string, t time.Time) []*influxdb2write.Point {
+//
+// The normalized layout writes all of them to the meter measurement.
+func extractCTPoints(readings []gateway.TypedCTReading, schema *Schema, t time.Time) []*influxdb2write.Point {
 	var ps []*influxdb2write.Point
 	for _, r := range readings {
-		var namePrefix string
-		switch r.MeasurementType {
-		case MeasurementProduction:
-			namePrefix = MeasurementProduction
-		case MeasurementTotalConsumption:
-			namePrefix = "consumption"
-		case MeasurementNetConsumption:
-			namePrefix = "net"
-		default:
+		namePrefix, ok := ctNamePrefix(r.MeasurementType)
+		if !ok {
 			continue // unknown type; skip
 		}
 		for i, ch := range r.Channels {
-			ps = append(ps, ctChannelToPoint(namePrefix, r.MeasurementType, ch, i, sourceTag, t))
+			ps = append(ps, ctChannelToPoint(namePrefix, r.MeasurementType, ch, i, schema, t))
 		}
 	}
 	return ps
 }
 
-// extractInverterPoints builds one InfluxDB point per microinverter.
-func extractInverterPoints(inverters []gateway.InverterReading, sourceTag string, t time.Time) []*influxdb2write.Point {
+// ctNamePrefix returns the legacy measurement-name prefix for a CT
+// measurement type, or false for types the exporter does not write.
+func ctNamePrefix(measurementType string) (string, bool) {
+	switch measurementType {
+	case MeasurementProduction:
+		return MeasurementProduction, true
+	case MeasurementTotalConsumption:
+		return "consumption", true
+	case MeasurementNetConsumption:
+		return "net", true
+	default:
+		return "", false
+	}
+}
+
+// extractInverterPoints builds one InfluxDB point per microinverter. When
+// details has telemetry for an inverter, its DC, AC, temperature and lifetime
+// energy fields are added to the point.
+func extractInverterPoints(inverters []gateway.InverterReading, details []InverterDetail, schema *Schema, t time.Time) []*influxdb2write.Point {
+	bySerial := make(map[string]InverterDetail, len(details))
+	for _, d := range details {
+		bySerial[d.Serial] = d
+	}
 	ps := make([]*influxdb2write.Point, len(inverters))
 	for i, inv := range inverters {
-		ps[i] = influxdb2.NewPointWithMeasurement(fmt.Sprintf("inverter-production-%s", inv.SerialNumber)).
-			AddTag(TagSource, sourceTag).
+		k := pointKey{Family: FamilyInverter, Serial: inv.SerialNumber, Legacy: fmt.Sprintf("inverter-production-%s", inv.SerialNumber)}
+		pt := schema.newPoint(k, t).
 			AddTag(TagMeasurementType, MeasurementInverter).
 			AddTag(TagSerial, inv.SerialNumber).
-			AddField(FieldP, float64(inv.LastReportWatts)).
-			SetTime(t)
+			AddField(FieldP, float64(inv.LastReportWatts))
+		if d, ok := bySerial[inv.SerialNumber]; ok {
+			pt.AddField("dc_voltage_v", d.DCVoltage).
+				AddField("dc_current_a", d.DCCurrent).
+				AddField("ac_voltage_v", d.ACVoltage).
+				AddField("ac_frequency_hz", d.ACFrequency).
+				AddField("temperature_c", d.TemperatureC).
+				AddField("lifetime_wh", d.LifetimeWh).
+				AddField("active", d.Active)
+		}
+		ps[i] = schema.renameFields(FamilyInverter, pt)
 	}
 	return ps
 }
@@ -145,25 +248,45 @@ func extractInverterPoints(inverters []gateway.InverterReading, sourceTag string
 // extractBatteryPoints builds one InfluxDB point per Encharge battery unit.
 // Each point carries operational telemetry: state of charge, temperatures,
 // capacity, grid mode, and communication state.
-func extractBatteryPoints(batteries []gateway.BatteryStatus, sourceTag string, t time.Time) []*influxdb2write.Point {
+func extractBatteryPoints(batteries []gateway.BatteryStatus, schema *Schema, t time.Time) []*influxdb2write.Point {
 	ps := make([]*influxdb2write.Point, len(batteries))
 	for i, b := range batteries {
-		ps[i] = influxdb2.NewPointWithMeasurement(fmt.Sprintf("battery-%s", b.SerialNum)).
-			AddTag(TagSource, sourceTag).
+		k := pointKey{Family: FamilyBattery, Serial: b.SerialNum, Legacy: fmt.Sprintf("battery-%s", b.SerialNum)}
+		pt := schema.newPoint(k, t).
 			AddTag(TagMeasurementType, MeasurementBattery).
 			AddTag(TagSerial, b.SerialNum).
-			AddTag("phase", b.Phase).
+			AddTag(TagPhase, b.Phase).
 			AddField("percent_full", b.PercentFull).
 			AddField("temperature_c", b.Temperature).
 			AddField("max_cell_temp_c", b.MaxCellTemp).
 			AddField("capacity_wh", b.CapacityWh).
 			AddField("grid_mode", b.GridMode).
-			AddField("communicating", b.Communicating).
-			SetTime(t)
+			AddField("communicating", b.Communicating)
+		ps[i] = schema.renameFields(FamilyBattery, pt)
 	}
 	return ps
 }
 
+// fieldFloat converts a numeric or boolean field value to float64.
+// It reports false for strings and other non-numeric values.
+func fieldFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
 // logPoint emits a Debug-level log entry for a single InfluxDB point,
 // grouping tags and fields for clean structured output.
 func logPoint(pt *influxdb2write.Point) {
@@ -185,74 +308,226 @@ func logPoint(pt *influxdb2write.Point) {
 	)
 }
 
-// scrape fetches data from all Envoy endpoints and writes points to InfluxDB.
+// scrape fetches data from all Envoy endpoints and writes points to the configured sinks.
 // Errors from individual endpoints are logged but do not abort the scrape.
 // A 404 from the CT meter endpoint is treated as a non-error (no CTs installed).
-func scrape(ctx context.Context, e EnvoyClient, writeAPI PointWriter, sourceTag string) scrapeResult {
+func scrape(ctx context.Context, e EnvoyClient, writeAPI PointWriter, schema *Schema) scrapeResult {
+	return scrapeAt(ctx, e, writeAPI, schema, time.Now())
+}
+
+// scrapeAt is scrape with every point timestamped scrapeTime, e.g. an
+// interval boundary the scrape was aligned to.
+func scrapeAt(ctx context.Context, e EnvoyClient, writeAPI PointWriter, schema *Schema, scrapeTime time.Time) (result scrapeResult) {
 	metricScrapeTotal.Add(1)
+	ctx, scrapeSpan := startSpan(ctx, "scrape")
+	defer func() {
+		scrapeSpan.SetAttributes(attribute.Int("points", result.points), attribute.Bool("has_error", result.hasErr))
+		if result.hasErr {
+			scrapeSpan.SetStatus(codes.Error, "one or more steps failed")
+		}
+		scrapeSpan.End()
+	}()
 	var points []*influxdb2write.Point
 	var hasErr bool
 
-	// Capture a single timestamp so all points in this scrape share the same time.
-	scrapeTime := time.Now()
+	// All points in this scrape share scrapeTime; start times the scrape.
+	start := time.Now()
 
-	t := scrapeTime
-	live, err := e.LiveData(ctx)
+	t := start
+	spanCtx, span := startSpan(ctx, "gateway."+EndpointLiveData)
+	live, err := e.LiveData(spanCtx)
+	endSpan(span, err)
 	dur := time.Since(t)
+	observeEndpoint(EndpointLiveData, dur, err)
 	if err != nil {
 		slog.Error("LiveData fetch failed", "error", err, "duration", dur)
 		hasErr = true
 	} else {
-		pts := extractLiveDataPoints(live, sourceTag, scrapeTime)
+		pts := extractLiveDataPoints(live, schema, expectedProduction, scrapeTime)
+		snap := gateway.SnapshotFromLiveData(live)
+		latest.setSnapshot(snap, scrapeTime)
+		batteryAnalytics.observeSnapshot(snap, scrapeTime)
+		solarClipping.observeSystem(snap.SolarW, scrapeTime)
+		expectedProduction.observe(snap.SolarW, scrapeTime)
+		pts = append(pts, extractPerformancePoints(expectedProduction.takeDays(), schema)...)
 		slog.Debug("LiveData fetch", "duration", dur, "points", len(pts), "sc_stream", live.Connection.SCStream)
 		points = append(points, pts...)
 	}
 
 	t = time.Now()
-	ctReadings, err := e.TypedMeterReadings(ctx)
+	spanCtx, span = startSpan(ctx, "gateway."+EndpointMeters)
+	ctReadings, err := e.TypedMeterReadings(spanCtx)
+	endSpan(span, err)
 	dur = time.Since(t)
+	observeEndpoint(EndpointMeters, dur, err)
 	if err != nil {
 		if gateway.IsNotFound(err) {
 			slog.Debug("No CT meters installed; skipping meter readings")
+			latest.setMeters([]gateway.TypedCTReading{}, scrapeTime)
 		} else {
 			slog.Error("MeterReadings fetch failed", "error", err, "duration", dur)
 			hasErr = true
 		}
 	} else {
-		pts := extractCTPoints(ctReadings, sourceTag, scrapeTime)
+		pts := extractCTPoints(ctReadings, schema, scrapeTime)
+		pts = append(pts, extractMeterEnergyPoints(ctReadings, schema, energyCounters, scrapeTime)...)
+		latest.setMeters(ctReadings, scrapeTime)
 		slog.Debug("MeterReadings fetch", "duration", dur, "points", len(pts))
 		points = append(points, pts...)
 	}
 
 	t = time.Now()
-	inverters, err := e.Inverters(ctx)
+	spanCtx, span = startSpan(ctx, "gateway."+EndpointEnergy)
+	energy, err := e.Energy(spanCtx)
+	endSpan(span, err)
 	dur = time.Since(t)
+	observeEndpoint(EndpointEnergy, dur, err)
 	if err != nil {
-		slog.Error("Inverters fetch failed", "error", err, "duration", dur)
-		hasErr = true
+		if gateway.IsNotFound(err) {
+			slog.Debug("No energy summary endpoint; skipping energy totals")
+		} else {
+			slog.Error("Energy fetch failed", "error", err, "duration", dur)
+			hasErr = true
+		}
 	} else {
-		slog.Debug("Inverters fetch", "duration", dur, "inverters", len(inverters))
-		if len(inverters) > 0 {
-			points = append(points, extractInverterPoints(inverters, sourceTag, scrapeTime)...)
+		pts := extractEnergyPoints(energy, schema, energyCounters, scrapeTime)
+		slog.Debug("Energy fetch", "duration", dur, "points", len(pts))
+		points = append(points, pts...)
+	}
+
+	var inverters []gateway.InverterReading
+	if scrapeSchedule.pollInverters(scrapeTime) {
+		t = time.Now()
+		spanCtx, span = startSpan(ctx, "gateway."+EndpointInverters)
+		inverters, err = e.Inverters(spanCtx)
+		endSpan(span, err)
+		dur = time.Since(t)
+		observeEndpoint(EndpointInverters, dur, err)
+		if err != nil {
+			slog.Error("Inverters fetch failed", "error", err, "duration", dur)
+			hasErr = true
+		} else {
+			slog.Debug("Inverters fetch", "duration", dur, "inverters", len(inverters))
+			latest.setInverters(inverters, scrapeTime)
+			solarClipping.observeInverters(inverters, scrapeTime)
 		}
+	} else {
+		slog.Debug("Night; skipping inverter polling")
+	}
+
+	var details []InverterDetail
+	if len(inverters) > 0 {
+		t = time.Now()
+		spanCtx, span = startSpan(ctx, "gateway."+EndpointInverterDetails)
+		details, err = e.InverterDetails(spanCtx)
+		endSpan(span, err)
+		dur = time.Since(t)
+		observeEndpoint(EndpointInverterDetails, dur, err)
+		if err != nil {
+			if gateway.IsNotFound(err) {
+				slog.Debug("No inverter device data endpoint; writing power only")
+			} else {
+				slog.Error("InverterDetails fetch failed", "error", err, "duration", dur)
+				hasErr = true
+			}
+		} else {
+			slog.Debug("InverterDetails fetch", "duration", dur, "inverters", len(details))
+		}
+		points = append(points, extractInverterPoints(inverters, details, schema, scrapeTime)...)
+	}
+	if solarClipping.enabled() {
+		points = append(points, extractClippingPoints(solarClipping.report(), schema, scrapeTime)...)
 	}
 
 	t = time.Now()
-	batteries, err := e.BatteryInventory(ctx)
+	spanCtx, span = startSpan(ctx, "gateway."+EndpointBatteries)
+	batteries, err := e.BatteryInventory(spanCtx)
+	endSpan(span, err)
 	dur = time.Since(t)
+	observeEndpoint(EndpointBatteries, dur, err)
 	if err != nil {
 		if gateway.IsNotFound(err) {
 			slog.Debug("No battery inventory endpoint; skipping batteries")
+			latest.setBatteries([]gateway.BatteryStatus{}, scrapeTime)
 		} else {
 			slog.Error("BatteryInventory fetch failed", "error", err, "duration", dur)
 			hasErr = true
 		}
 	} else {
 		slog.Debug("BatteryInventory fetch", "duration", dur, "batteries", len(batteries))
+		latest.setBatteries(batteries, scrapeTime)
 		if len(batteries) > 0 {
-			points = append(points, extractBatteryPoints(batteries, sourceTag, scrapeTime)...)
+			points = append(points, extractBatteryPoints(batteries, schema, scrapeTime)...)
+			batteryAnalytics.observeUnits(batteries)
+			points = append(points, extractBatteryAnalyticsPoints(batteries, batteryAnalytics.report(batteries), schema, scrapeTime)...)
+			batteryAnalytics.saveIfDue(scrapeTime)
+		}
+	}
+
+	t = time.Now()
+	spanCtx, span = startSpan(ctx, "gateway."+EndpointEnsemble)
+	ensemble, err := e.Ensemble(spanCtx)
+	endSpan(span, err)
+	dur = time.Since(t)
+	observeEndpoint(EndpointEnsemble, dur, err)
+	if err != nil {
+		if gateway.IsNotFound(err) {
+			slog.Debug("No IQ System Controller installed; skipping ensemble status")
+		} else {
+			slog.Error("Ensemble fetch failed", "error", err, "duration", dur)
+			hasErr = true
+		}
+	} else {
+		pts := extractEnsemblePoints(ensemble, schema, scrapeTime)
+		slog.Debug("Ensemble fetch", "duration", dur, "points", len(pts), "grid_mode", ensemble.GridMode)
+		points = append(points, pts...)
+	}
+
+	t = time.Now()
+	spanCtx, span = startSpan(ctx, "gateway."+EndpointInventory)
+	devices, err := e.Inventory(spanCtx)
+	endSpan(span, err)
+	dur = time.Since(t)
+	observeEndpoint(EndpointInventory, dur, err)
+	if err != nil {
+		if gateway.IsNotFound(err) {
+			slog.Debug("No inventory endpoint; skipping device status")
+		} else {
+			slog.Error("Inventory fetch failed", "error", err, "duration", dur)
+			hasErr = true
+		}
+	} else {
+		slog.Debug("Inventory fetch", "duration", dur, "devices", len(devices))
+		logInventoryChanges(deviceInventory.update(devices))
+		points = append(points, extractInventoryPoints(devices, schema, scrapeTime)...)
+	}
+
+	if gatewayFirmware.due(scrapeTime) {
+		t = time.Now()
+		spanCtx, span = startSpan(ctx, "gateway."+EndpointInfo)
+		info, err := e.Info(spanCtx)
+		dur = time.Since(t)
+		observeEndpoint(EndpointInfo, dur, err)
+		gatewayFirmware.checked(scrapeTime)
+		if err != nil {
+			if gateway.IsNotFound(err) {
+				slog.Debug("No info endpoint; skipping gateway info")
+			} else {
+				slog.Error("Info fetch failed", "error", err, "duration", dur)
+				hasErr = true
+			}
+		} else {
+			slog.Debug("Info fetch", "duration", dur, "software", info.Software)
+			if gatewayFirmware.observe(info, scrapeTime) {
+				span.AddEvent("firmware changed", trace.WithAttributes(attribute.String("software", info.Software)))
+			}
 		}
+		endSpan(span, err)
+	}
+	if info, ok := gatewayFirmware.takeInfo(); ok {
+		points = append(points, extractGatewayInfoPoint(info, schema, scrapeTime))
 	}
+	points = append(points, extractFirmwareEventPoints(gatewayFirmware.takeEvents(), schema)...)
 
 	if len(points) > 0 {
 		writeCtx, writeCancel := context.WithTimeout(ctx, 30*time.Second)
@@ -263,39 +538,59 @@ func scrape(ctx context.Context, e EnvoyClient, writeAPI PointWriter, sourceTag
 			}
 		}
 		t = time.Now()
-		if err := writeAPI.WritePoint(writeCtx, points...); err != nil {
-			slog.Error("InfluxDB write failed",
+		writeCtx, span := startSpan(writeCtx, "write", attribute.Int("points", len(points)))
+		err := writeAPI.WritePoint(writeCtx, points...)
+		endSpan(span, err)
+		if err != nil {
+			slog.Error("Point write failed",
 				"error", err,
 				"points", len(points),
 				"duration", time.Since(t))
 			hasErr = true
-			points = nil // write failed; don't count as written
+			points = points[:acceptedPoints(len(points), err)] // count only what was accepted
 		} else {
-			slog.Debug("InfluxDB write", "duration", time.Since(t), "points", len(points))
+			slog.Debug("Point write", "duration", time.Since(t), "points", len(points))
 		}
 	}
 
-	scrapeDur := time.Since(scrapeTime)
+	scrapeDur := time.Since(start)
 	metricLastScrapeDurationMS.Set(scrapeDur.Milliseconds())
+	promScrapeDuration.Observe(scrapeDur.Seconds())
 	if hasErr {
 		metricScrapeErrors.Add(1)
+		promScrapes.WithLabelValues("error").Inc()
 	} else {
 		metricLastScrapeTime.Set(scrapeTime.Unix())
+		promScrapes.WithLabelValues("ok").Inc()
 	}
 	metricPointsWrittenTotal.Add(int64(len(points)))
+	promPointsWritten.Add(float64(len(points)))
 
-	return scrapeResult{points: len(points), hasErr: hasErr}
+	result = scrapeResult{points: len(points), hasErr: hasErr}
+	latest.setResult(scrapeTime, scrapeDur, result)
+	return result
 }
 
 // connectWithBackoff retries clientFactory with exponential backoff until
 // a client is created successfully or ctx is cancelled.
-func connectWithBackoff(ctx context.Context, cfg *Config, factory ClientFactory, base, maxDelay time.Duration) (EnvoyClient, error) {
+// The whole attempt sequence is one span with an event per failed attempt.
+func connectWithBackoff(ctx context.Context, cfg *Config, factory ClientFactory, base, maxDelay time.Duration) (_ EnvoyClient, err error) {
+	spanCtx, span := startSpan(ctx, "gateway.connect", attribute.String("envoy.address", cfg.Address))
+	defer func() { endSpan(span, err) }()
+
 	backoff := base
-	for {
-		e, err := factory(cfg)
+	for attempt := 1; ; attempt++ {
+		e, err := factory(spanCtx, cfg)
+		components.recordGateway(err)
+		promConnectAttempts.WithLabelValues(resultLabel(err)).Inc()
 		if err == nil {
+			span.SetAttributes(attribute.Int("attempts", attempt))
 			return e, nil
 		}
+		span.AddEvent("connect failed", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error()),
+			attribute.String("retry_in", backoff.String())))
 		slog.Error("Failed to connect to Envoy", "error", err, "retry_in", backoff)
 		select {
 		case <-ctx.Done():
@@ -316,31 +611,70 @@ func scrapeLoop(ctx context.Context, cfg *Config, writeAPI PointWriter, factory
 		baseRetry = 5 * time.Second
 	}
 
+	schema, err := schemaFromConfig(cfg)
+	if err != nil {
+		slog.Error("Invalid schema configuration", "error", err) // rejected by Validate first
+		return
+	}
+
 	e, err := connectWithBackoff(ctx, cfg, factory, baseRetry, 5*time.Minute)
 	if err != nil {
 		return // context cancelled before we connected
 	}
-	if err := e.EnableHighFrequencyMode(ctx); err != nil {
+	hfCtx, span := startSpan(ctx, "gateway.enable_high_frequency_mode")
+	err = e.EnableHighFrequencyMode(hfCtx)
+	endSpan(span, err)
+	if err != nil {
 		slog.Warn("Failed to enable high-frequency mode; LiveData will refresh at default interval (~5s)",
 			"endpoint", "/ivp/livedata/stream", "error", err)
 	} else {
 		slog.Info("High-frequency mode enabled", "endpoint", "/ivp/livedata/stream")
 	}
 
-	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
-	defer ticker.Stop()
-
 	interval := time.Duration(cfg.Interval) * time.Second
 
+	// Unaligned, a ticker fires interval apart from the first, immediate
+	// scrape. Aligned, a timer is set for each boundary's tick in turn.
+	var tick <-chan time.Time
+	var timer *time.Timer
+	var clock scrapeClock
+	var slot time.Time // boundary of the pending aligned tick
+	if cfg.AlignInterval {
+		clock = newScrapeClock(cfg)
+		var fire time.Time
+		slot, fire, _ = clock.next(time.Time{}, time.Now())
+		timer = time.NewTimer(time.Until(fire))
+		defer timer.Stop()
+		tick = timer.C
+		slog.Info("Aligning scrapes to interval boundaries", "interval", interval, "offset", clock.offset, "first", slot)
+	} else {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 	// doScrapeAt runs one scrape and logs how long until the next tick.
 	// tickAt is when the triggering tick fired; it anchors the "next in" calculation
 	// so that scrape duration does not skew the reported wait time.
 	doScrapeAt := func(tickAt time.Time) {
 		start := time.Now()
-		result := scrape(ctx, e, writeAPI, cfg.SourceTag)
+		var result scrapeResult
+		next := tickAt.Add(interval)
+		if cfg.AlignInterval {
+			result = scrapeAt(ctx, e, writeAPI, schema, slot)
+			var missed int
+			slot, next, missed = clock.next(slot, time.Now())
+			if missed > 0 {
+				slog.Warn("Scrape overran the interval; skipping ticks", "missed", missed, "next", slot)
+				promMissedTicks.Add(float64(missed))
+			}
+			timer.Reset(time.Until(next))
+		} else {
+			result = scrape(ctx, e, writeAPI, schema)
+		}
 		dur := time.Since(start)
 
-		nextIn := max(time.Until(tickAt.Add(interval)).Truncate(time.Second), 0)
+		nextIn := max(time.Until(next).Truncate(time.Second), 0)
 		slog.Info("Scrape finished",
 			"duration", dur,
 			"points", result.points,
@@ -348,7 +682,9 @@ func scrapeLoop(ctx context.Context, cfg *Config, writeAPI PointWriter, factory
 			"next_in", nextIn)
 	}
 
-	doScrapeAt(time.Now()) // immediate first scrape
+	if !cfg.AlignInterval {
+		doScrapeAt(time.Now()) // immediate first scrape
+	}
 
 	for {
 		select {
@@ -357,18 +693,25 @@ func scrapeLoop(ctx context.Context, cfg *Config, writeAPI PointWriter, factory
 			return
 		case <-reconnect: // nil channel blocks forever; fires only when JWT is refreshed
 			slog.Info("JWT refreshed; reconnecting to Envoy")
-			newClient, err := connectWithBackoff(ctx, cfg, factory, baseRetry, 5*time.Minute)
+			promReconnects.Inc()
+			reconnectCtx, span := startSpan(ctx, "gateway.reconnect", attribute.String("reason", "jwt_refresh"))
+			newClient, err := connectWithBackoff(reconnectCtx, cfg, factory, baseRetry, 5*time.Minute)
 			if err != nil {
+				endSpan(span, err)
 				return
 			}
 			e = newClient
-			if err := e.EnableHighFrequencyMode(ctx); err != nil {
+			hfCtx, hfSpan := startSpan(reconnectCtx, "gateway.enable_high_frequency_mode")
+			err = e.EnableHighFrequencyMode(hfCtx)
+			endSpan(hfSpan, err)
+			span.End()
+			if err != nil {
 				slog.Warn("Failed to re-enable high-frequency mode after reconnect",
 					"endpoint", "/ivp/livedata/stream", "error", err)
 			} else {
 				slog.Info("High-frequency mode re-enabled after reconnect", "endpoint", "/ivp/livedata/stream")
 			}
-		case t := <-ticker.C:
+		case t := <-tick:
 			doScrapeAt(t)
 		}
 	}
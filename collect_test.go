@@ -7,6 +7,7 @@ import (
 	"time"
 
 	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,6 +19,11 @@ type MockEnvoyClient struct {
 	InvertersFunc               func(ctx context.Context) ([]gateway.InverterReading, error)
 	TypedMeterReadingsFunc      func(ctx context.Context) ([]gateway.TypedCTReading, error)
 	BatteryInventoryFunc        func(ctx context.Context) ([]gateway.BatteryStatus, error)
+	EnergyFunc                  func(ctx context.Context) (gateway.EnergyData, error)
+	InverterDetailsFunc         func(ctx context.Context) ([]InverterDetail, error)
+	InventoryFunc               func(ctx context.Context) ([]InventoryDevice, error)
+	InfoFunc                    func(ctx context.Context) (GatewayInfo, error)
+	EnsembleFunc                func(ctx context.Context) (EnsembleStatus, error)
 	EnableHighFrequencyModeFunc func(ctx context.Context) error
 }
 
@@ -49,6 +55,44 @@ func (m *MockEnvoyClient) BatteryInventory(ctx context.Context) ([]gateway.Batte
 	return []gateway.BatteryStatus{}, nil
 }
 
+func (m *MockEnvoyClient) Energy(ctx context.Context) (gateway.EnergyData, error) {
+	if m.EnergyFunc != nil {
+		return m.EnergyFunc(ctx)
+	}
+	return gateway.EnergyData{}, nil
+}
+
+func (m *MockEnvoyClient) InverterDetails(ctx context.Context) ([]InverterDetail, error) {
+	if m.InverterDetailsFunc != nil {
+		return m.InverterDetailsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *MockEnvoyClient) Inventory(ctx context.Context) ([]InventoryDevice, error) {
+	if m.InventoryFunc != nil {
+		return m.InventoryFunc(ctx)
+	}
+	return nil, nil
+}
+
+// Info reports the endpoint as absent unless InfoFunc is set, so tests do not
+// touch the process-wide firmware tracker by default.
+func (m *MockEnvoyClient) Info(ctx context.Context) (GatewayInfo, error) {
+	if m.InfoFunc != nil {
+		return m.InfoFunc(ctx)
+	}
+	return GatewayInfo{}, &gateway.Error{StatusCode: 404, Endpoint: "/info"}
+}
+
+// Ensemble reports no System Controller unless EnsembleFunc is set.
+func (m *MockEnvoyClient) Ensemble(ctx context.Context) (EnsembleStatus, error) {
+	if m.EnsembleFunc != nil {
+		return m.EnsembleFunc(ctx)
+	}
+	return EnsembleStatus{}, &gateway.Error{StatusCode: 404, Endpoint: "/ivp/ensemble/status"}
+}
+
 func (m *MockEnvoyClient) EnableHighFrequencyMode(ctx context.Context) error {
 	if m.EnableHighFrequencyModeFunc != nil {
 		return m.EnableHighFrequencyModeFunc(ctx)
@@ -97,7 +141,7 @@ func TestExtractLiveDataPoints(t *testing.T) {
 			EncAggEnergy: 10000,
 		},
 	}
-	pts := extractLiveDataPoints(live, "test", time.Now())
+	pts := extractLiveDataPoints(live, &Schema{Source: "test"}, nil, time.Now())
 	require.Len(t, pts, 1)
 	assert.Equal(t, "energy-snapshot", pts[0].Name())
 	assert.Equal(t, "test", tagMap(pts[0])["source"])
@@ -135,7 +179,7 @@ func TestExtractCTPoints(t *testing.T) {
 		},
 	}
 
-	pts := extractCTPoints(readings, "home", time.Now())
+	pts := extractCTPoints(readings, &Schema{Source: "home"}, time.Now())
 	require.Len(t, pts, 4) // 2 production channels + 1 total-consumption + 1 net-consumption
 
 	assert.Equal(t, "production-line0", pts[0].Name())
@@ -163,7 +207,7 @@ func TestExtractCTPoints_SkipsUnknownType(t *testing.T) {
 			MeasurementType: "unknown-type",
 		},
 	}
-	pts := extractCTPoints(readings, "test", time.Now())
+	pts := extractCTPoints(readings, &Schema{Source: "test"}, time.Now())
 	assert.Empty(t, pts)
 }
 
@@ -174,7 +218,7 @@ func TestExtractInverterPoints(t *testing.T) {
 		{SerialNumber: "ABC", LastReportWatts: 250},
 		{SerialNumber: "DEF", LastReportWatts: 300},
 	}
-	pts := extractInverterPoints(inverters, "home", time.Now())
+	pts := extractInverterPoints(inverters, nil, &Schema{Source: "home"}, time.Now())
 	require.Len(t, pts, 2)
 	assert.Equal(t, "inverter-production-ABC", pts[0].Name())
 	assert.Equal(t, "inverter-production-DEF", pts[1].Name())
@@ -208,7 +252,7 @@ func TestExtractBatteryPoints(t *testing.T) {
 			Communicating: true,
 		},
 	}
-	pts := extractBatteryPoints(batteries, "home", time.Now())
+	pts := extractBatteryPoints(batteries, &Schema{Source: "home"}, time.Now())
 	require.Len(t, pts, 2)
 
 	assert.Equal(t, "battery-BAT001", pts[0].Name())
@@ -249,8 +293,10 @@ func TestScrape_AllSources(t *testing.T) {
 	}
 	writer := &MockPointWriter{}
 
-	result := scrape(context.Background(), client, writer, "test")
-	assert.Equal(t, 4, result.points) // 1 energy-snapshot + 1 inverter + 1 CT channel + 1 battery
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	// 1 energy-snapshot + 1 inverter + 1 CT channel + 1 battery
+	// + 2 CT energy (meter total and line) + 2 battery analytics (unit and system)
+	assert.Equal(t, 8, result.points)
 	assert.False(t, result.hasErr)
 }
 
@@ -268,7 +314,7 @@ func TestScrape_BatteryError(t *testing.T) {
 	}
 	writer := &MockPointWriter{}
 
-	result := scrape(context.Background(), client, writer, "test")
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
 	assert.Equal(t, 1, result.points) // energy-snapshot still written
 	assert.True(t, result.hasErr)
 }
@@ -287,7 +333,7 @@ func TestScrape_BatteryNotFound(t *testing.T) {
 	}
 	writer := &MockPointWriter{}
 
-	result := scrape(context.Background(), client, writer, "test")
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
 	assert.Equal(t, 1, result.points)
 	assert.False(t, result.hasErr, "404 from battery endpoint should not be treated as an error")
 }
@@ -300,7 +346,7 @@ func TestScrape_LiveDataError(t *testing.T) {
 			return gateway.LiveData{}, errors.New("network error")
 		},
 	}
-	result := scrape(context.Background(), client, &MockPointWriter{}, "test")
+	result := scrape(context.Background(), client, &MockPointWriter{}, &Schema{Source: "test"})
 	assert.Equal(t, 0, result.points)
 	assert.True(t, result.hasErr)
 }
@@ -319,11 +365,33 @@ func TestScrape_WriteError(t *testing.T) {
 		},
 	}
 
-	result := scrape(context.Background(), client, writer, "test")
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
 	assert.Equal(t, 0, result.points, "write failed so points should not be counted")
 	assert.True(t, result.hasErr)
 }
 
+func TestScrape_WriteQueueFull(t *testing.T) {
+	t.Parallel()
+
+	client := &MockEnvoyClient{
+		LiveDataFunc: func(_ context.Context) (gateway.LiveData, error) {
+			return makeLiveData(1000000, 0, 0, 1000000), nil
+		},
+		EnsembleFunc: func(_ context.Context) (EnsembleStatus, error) {
+			return EnsembleStatus{GridMode: "multimode-ongrid"}, nil
+		},
+	}
+	writer := &MockPointWriter{
+		WritePointFunc: func(_ context.Context, point ...*influxdb2write.Point) error {
+			return &queueFullError{sink: "influxdb", dropped: len(point) - 1}
+		},
+	}
+
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	assert.Equal(t, 1, result.points, "points enqueued before the queue filled are counted")
+	assert.True(t, result.hasErr)
+}
+
 func TestScrape_PartialErrors(t *testing.T) {
 	t.Parallel()
 
@@ -341,7 +409,7 @@ func TestScrape_PartialErrors(t *testing.T) {
 	}
 	writer := &MockPointWriter{}
 
-	result := scrape(context.Background(), client, writer, "test")
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
 	assert.Equal(t, 1, result.points) // only the energy-snapshot point
 	assert.True(t, result.hasErr)
 }
@@ -360,16 +428,31 @@ func TestScrape_CTNotFound(t *testing.T) {
 	}
 	writer := &MockPointWriter{}
 
-	result := scrape(context.Background(), client, writer, "test")
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
 	assert.Equal(t, 1, result.points)
 	assert.False(t, result.hasErr, "404 from CT endpoint should not be treated as an error")
 }
 
+func TestMultiWriter_WritesAllAndJoinsErrors(t *testing.T) {
+	t.Parallel()
+
+	ok := &MockPointWriter{}
+	failing := &MockPointWriter{
+		WritePointFunc: func(_ context.Context, _ ...*influxdb2write.Point) error { return assert.AnError },
+	}
+	w := multiWriter{failing, ok}
+
+	pt := influxdb2.NewPointWithMeasurement("m").AddField("w", 1.0)
+	err := w.WritePoint(context.Background(), pt)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Len(t, ok.Written, 1, "a failing writer must not stop the others")
+}
+
 func TestConnectWithBackoff_ImmediateSuccess(t *testing.T) {
 	t.Parallel()
 
 	client := &MockEnvoyClient{}
-	factory := func(_ *Config) (EnvoyClient, error) { return client, nil }
+	factory := func(context.Context, *Config) (EnvoyClient, error) { return client, nil }
 
 	e, err := connectWithBackoff(context.Background(), &Config{}, factory, 10*time.Millisecond, 1*time.Second)
 	require.NoError(t, err)
@@ -381,7 +464,7 @@ func TestConnectWithBackoff_RetriesThenSucceeds(t *testing.T) {
 
 	attempts := 0
 	client := &MockEnvoyClient{}
-	factory := func(_ *Config) (EnvoyClient, error) {
+	factory := func(context.Context, *Config) (EnvoyClient, error) {
 		attempts++
 		if attempts < 3 {
 			return nil, errors.New("not ready")
@@ -401,7 +484,7 @@ func TestConnectWithBackoff_BackoffCap(t *testing.T) {
 
 	attempts := 0
 	client := &MockEnvoyClient{}
-	factory := func(_ *Config) (EnvoyClient, error) {
+	factory := func(context.Context, *Config) (EnvoyClient, error) {
 		attempts++
 		if attempts < 5 {
 			return nil, errors.New("not ready")
@@ -421,7 +504,7 @@ func TestConnectWithBackoff_BackoffCap(t *testing.T) {
 func TestConnectWithBackoff_CancelledContext(t *testing.T) {
 	t.Parallel()
 
-	factory := func(_ *Config) (EnvoyClient, error) {
+	factory := func(context.Context, *Config) (EnvoyClient, error) {
 		return nil, errors.New("always fails")
 	}
 
@@ -444,7 +527,7 @@ func TestScrapeLoop_RunsAndWritesPoints(t *testing.T) {
 		},
 	}
 	writer := &MockPointWriter{}
-	factory := func(_ *Config) (EnvoyClient, error) { return client, nil }
+	factory := func(context.Context, *Config) (EnvoyClient, error) { return client, nil }
 
 	scrapeLoop(ctx, cfg1(), writer, factory, nil)
 
@@ -459,7 +542,7 @@ func TestScrapeLoop_ConnectionRetry(t *testing.T) {
 
 	attempts := 0
 	client := &MockEnvoyClient{}
-	factory := func(_ *Config) (EnvoyClient, error) {
+	factory := func(context.Context, *Config) (EnvoyClient, error) {
 		attempts++
 		if attempts < 2 {
 			return nil, errors.New("not ready")
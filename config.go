@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	yaml "gopkg.in/yaml.v3"
 )
@@ -21,21 +22,110 @@ type Config struct {
 	Password string `yaml:"password"`
 	JWT      string `yaml:"jwt"`
 
-	// InfluxDB v2
-	InfluxDB       string `yaml:"influxdb"`
+	// InfluxDB; influxdb_version selects the write API.
+	InfluxDB        string `yaml:"influxdb"`
+	InfluxDBVersion int    `yaml:"influxdb_version"` // 1, 2 or 3; default 2
+	InfluxDBGzip    bool   `yaml:"influxdb_gzip"`    // gzip request bodies; default true
+
+	// InfluxDB writes are queued and sent in batches by a background writer.
+	InfluxDBBatchSize     int `yaml:"influxdb_batch_size"`     // points per write; default 1000
+	InfluxDBFlushInterval int `yaml:"influxdb_flush_interval"` // seconds a partial batch may wait; default 5
+	InfluxDBQueueSize     int `yaml:"influxdb_queue_size"`     // points buffered before dropping; default 50000
+	InfluxDBMaxRetries    int `yaml:"influxdb_max_retries"`    // retries of a failed batch; default 5
+	InfluxDBTimeout       int `yaml:"influxdb_timeout"`        // seconds per write request; default 30
+
+	// InfluxDB v2 (v3 uses the token too)
 	InfluxDBToken  string `yaml:"influxdb_token"`
 	InfluxDBOrg    string `yaml:"influxdb_org"`
 	InfluxDBBucket string `yaml:"influxdb_bucket"`
 
+	// InfluxDB v1 and v3
+	InfluxDBDatabase        string `yaml:"influxdb_database"`
+	InfluxDBRetentionPolicy string `yaml:"influxdb_retention_policy"` // v1 only; default: database default
+	InfluxDBUsername        string `yaml:"influxdb_username"`         // v1 only
+	InfluxDBPassword        string `yaml:"influxdb_password"`         // v1 only
+
+	// Embedded SQLite storage; enabled when sqlite_path is set.
+	SQLitePath                  string `yaml:"sqlite_path"`
+	SQLiteRetentionDays         int    `yaml:"sqlite_retention_days"`           // raw samples; default 7, 0 keeps forever
+	SQLiteRollup5mRetentionDays int    `yaml:"sqlite_rollup_5m_retention_days"` // default 365, 0 keeps forever
+	SQLiteRollup1hRetentionDays int    `yaml:"sqlite_rollup_1h_retention_days"` // default 0 (forever)
+
+	// OpenTelemetry metrics export; enabled when otlp_endpoint is set.
+	OTLPEndpoint  string            `yaml:"otlp_endpoint"`   // host:port or URL of the collector
+	OTLPProtocol  string            `yaml:"otlp_protocol"`   // grpc (default) or http (protobuf)
+	OTLPInsecure  bool              `yaml:"otlp_insecure"`   // plaintext instead of TLS
+	OTLPHeaders   map[string]string `yaml:"otlp_headers"`    // extra headers, e.g. authentication
+	OTLPTimeout   int               `yaml:"otlp_timeout"`    // seconds per export request; default 10
+	OTLPBatchSize int               `yaml:"otlp_batch_size"` // maximum points per export request; default 500
+
+	// OpenTelemetry tracing of scrape cycles; off unless tracing is set.
+	Tracing         string `yaml:"tracing"`          // otlp or stdout
+	TracingEndpoint string `yaml:"tracing_endpoint"` // OTLP endpoint for traces; default: otlp_* settings
+	TracingProtocol string `yaml:"tracing_protocol"` // grpc (default) or http; used with tracing_endpoint
+	TracingInsecure bool   `yaml:"tracing_insecure"` // plaintext; used with tracing_endpoint
+
+	// Point layout; see Schema.
+	Schema              string `yaml:"schema"`               // legacy (default) or normalized
+	MeasurementTemplate string `yaml:"measurement_template"` // optional text/template for measurement names
+	FieldTemplate       string `yaml:"field_template"`       // optional text/template for field names
+
+	// Tag enrichment: static tags on every point, and per-device metadata
+	// keyed by inverter or battery serial.
+	Tags    map[string]string         `yaml:"tags"`
+	Devices map[string]DeviceMetadata `yaml:"devices"`
+
+	// Solar clipping detection; off unless inverter or system limits are set.
+	Clipping ClippingConfig `yaml:"clipping"`
+
+	// Site location, for sun position calculations.
+	Location *Location `yaml:"location"`
+
+	// Clear-sky expected production; off unless arrays are set.
+	Expected ExpectedConfig `yaml:"expected"`
+
+	// Poll inverters less at night; needs the location. Other endpoints,
+	// energy totals included, are read on every scrape.
+	Daylight DaylightConfig `yaml:"daylight"`
+
+	// Rollup points computed by the exporter; off unless windows are set.
+	Rollups RollupConfig `yaml:"rollups"`
+
+	// Change-only writes of selected fields; off unless fields are set.
+	Deadband DeadbandConfig `yaml:"deadband"`
+
+	// Bounds and rate limits checked before points are written.
+	Validation ValidationConfig `yaml:"validation"`
+
+	// Battery analytics state; persisted across restarts when set.
+	BatteryStatePath string `yaml:"battery_state_path"`
+
 	// Optional
 	SourceTag          string `yaml:"source"`
 	Interval           int    `yaml:"interval"`
 	RetryInterval      int    `yaml:"retry_interval"`
-	JWTRefreshLeadTime int    `yaml:"jwt_refresh_lead_time"` // minutes before expiry to refresh; default 60
-	PersistJWT         bool   `yaml:"persist_jwt"`           // write refreshed JWT back to the config file
-	LogLevel           string `yaml:"log_level"`             // debug, info, warn, error; default info
-	ExpvarPort         int    `yaml:"expvar_port"`           // port for expvar HTTP server; default 6666
+	JWTRefreshLeadTime int    `yaml:"jwt_refresh_lead_time"`    // minutes before expiry to refresh; default 60
+	PersistJWT         bool   `yaml:"persist_jwt"`              // write refreshed JWT back to the config file
+	LogLevel           string `yaml:"log_level"`                // debug, info, warn, error; default info
+	ExpvarPort         int    `yaml:"expvar_port"`              // port for expvar HTTP server; default 6666
 	InsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify"` // skip gateway TLS verification; default false
+	StalenessThreshold int    `yaml:"staleness_threshold"`      // seconds without fresh data before not ready; default 3 × interval
+
+	// Scrape on wall-clock multiples of interval and timestamp points with them.
+	AlignInterval bool `yaml:"align_interval"`
+	AlignJitter   int  `yaml:"align_jitter"` // maximum seconds to delay each aligned tick; default 0
+}
+
+// DeviceMetadata describes where a microinverter or battery is installed.
+// Set fields are written as tags on the device's points.
+type DeviceMetadata struct {
+	Array      string   `yaml:"array"`       // string or array name
+	RoofFace   string   `yaml:"roof_face"`   // e.g. south
+	Azimuth    *float64 `yaml:"azimuth"`     // degrees clockwise from north
+	Tilt       *float64 `yaml:"tilt"`        // degrees from horizontal
+	PanelModel string   `yaml:"panel_model"` // module make and model
+
+	InverterModel string `yaml:"inverter_model"` // key into clipping models, e.g. IQ8PLUS
 }
 
 // GetJWT returns the JWT in a thread-safe manner.
@@ -58,6 +148,15 @@ func (c *Config) SetJWT(jwt string) {
 	c.JWT = jwt
 }
 
+// StaleAfter returns how old the last successful live data read may be before
+// the exporter reports itself not ready and API readings are marked stale.
+func (c *Config) StaleAfter() time.Duration {
+	if c.StalenessThreshold > 0 {
+		return time.Duration(c.StalenessThreshold) * time.Second
+	}
+	return 3 * time.Duration(c.Interval) * time.Second
+}
+
 // Validate returns an error if the configuration is missing required fields.
 func (c *Config) Validate() error {
 	if c.Address == "" {
@@ -69,17 +168,93 @@ func (c *Config) Validate() error {
 	if c.Username == "" && c.Password == "" && c.JWT == "" {
 		return fmt.Errorf("missing Envoy authentication: provide username+password or jwt")
 	}
-	if c.InfluxDB == "" {
-		return fmt.Errorf("missing required configuration: influxdb")
+	if c.InfluxDB == "" && c.SQLitePath == "" && c.OTLPEndpoint == "" {
+		return fmt.Errorf("missing output: configure influxdb, sqlite_path or otlp_endpoint")
+	}
+	if c.InfluxDB != "" {
+		if err := c.validateInfluxDB(); err != nil {
+			return err
+		}
+	}
+	if c.OTLPEndpoint != "" {
+		switch c.OTLPProtocol {
+		case "", OTLPProtocolGRPC, OTLPProtocolHTTP:
+		default:
+			return fmt.Errorf("invalid otlp_protocol %q: use grpc or http", c.OTLPProtocol)
+		}
+	}
+	if _, err := schemaFromConfig(c); err != nil {
+		return err
+	}
+	if err := c.Clipping.validate(c.Devices); err != nil {
+		return err
+	}
+	if c.Location != nil {
+		if err := c.Location.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.Expected.validate(c.Location, c.Devices); err != nil {
+		return err
+	}
+	if err := c.Daylight.validate(c.Location); err != nil {
+		return err
 	}
-	if c.InfluxDBBucket == "" {
-		return fmt.Errorf("missing required configuration: influxdb_bucket")
+	if err := c.validateAlignment(); err != nil {
+		return err
 	}
-	if c.InfluxDBToken == "" {
-		return fmt.Errorf("missing required configuration: influxdb_token")
+	if err := c.Rollups.validate(c.InfluxDB); err != nil {
+		return err
 	}
-	if c.InfluxDBOrg == "" {
-		return fmt.Errorf("missing required configuration: influxdb_org")
+	if err := c.Deadband.validate(); err != nil {
+		return err
+	}
+	if err := c.Validation.validate(); err != nil {
+		return err
+	}
+	switch c.Tracing {
+	case "", TracingStdout:
+	case TracingOTLP:
+		if c.TracingEndpoint == "" && c.OTLPEndpoint == "" {
+			return fmt.Errorf("tracing: otlp requires tracing_endpoint or otlp_endpoint")
+		}
+		switch c.TracingProtocol {
+		case "", OTLPProtocolGRPC, OTLPProtocolHTTP:
+		default:
+			return fmt.Errorf("invalid tracing_protocol %q: use grpc or http", c.TracingProtocol)
+		}
+	default:
+		return fmt.Errorf("invalid tracing %q: use otlp or stdout", c.Tracing)
+	}
+	return nil
+}
+
+// validateInfluxDB checks the settings required by the selected InfluxDB version.
+func (c *Config) validateInfluxDB() error {
+	switch c.InfluxDBVersion {
+	case 1:
+		if c.InfluxDBDatabase == "" {
+			return fmt.Errorf("missing required configuration for influxdb_version 1: influxdb_database")
+		}
+		if (c.InfluxDBUsername == "") != (c.InfluxDBPassword == "") {
+			return fmt.Errorf("influxdb_username and influxdb_password must be set together")
+		}
+	case 0, 2:
+		if c.InfluxDBBucket == "" {
+			return fmt.Errorf("missing required configuration: influxdb_bucket")
+		}
+		if c.InfluxDBToken == "" {
+			return fmt.Errorf("missing required configuration: influxdb_token")
+		}
+		if c.InfluxDBOrg == "" {
+			return fmt.Errorf("missing required configuration: influxdb_org")
+		}
+	case 3:
+		if c.InfluxDBDatabase == "" {
+			return fmt.Errorf("missing required configuration for influxdb_version 3: influxdb_database")
+		}
+	default:
+		return fmt.Errorf("invalid influxdb_version %d: use 1, 2 or 3", c.InfluxDBVersion)
 	}
 	return nil
 }
@@ -99,6 +274,21 @@ func LoadConfig(path string) (*Config, error) {
 		RetryInterval: 5,
 		LogLevel:      "info",
 		ExpvarPort:    6666,
+
+		InfluxDBVersion:       2,
+		InfluxDBGzip:          true,
+		InfluxDBBatchSize:     1000,
+		InfluxDBFlushInterval: 5,
+		InfluxDBQueueSize:     50000,
+		InfluxDBMaxRetries:    5,
+		InfluxDBTimeout:       30,
+
+		SQLiteRetentionDays:         7,
+		SQLiteRollup5mRetentionDays: 365,
+
+		OTLPProtocol:  OTLPProtocolGRPC,
+		OTLPTimeout:   10,
+		OTLPBatchSize: 500,
 	}
 
 	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -61,6 +62,55 @@ influxdb_bucket: bucket
 	assert.Equal(t, 30, cfg.Interval, "default interval")
 	assert.Equal(t, 5, cfg.RetryInterval, "default retry interval")
 	assert.Equal(t, "info", cfg.LogLevel, "default log level")
+	assert.Equal(t, 7, cfg.SQLiteRetentionDays, "default sqlite raw retention")
+	assert.Equal(t, 365, cfg.SQLiteRollup5mRetentionDays, "default sqlite 5m rollup retention")
+	assert.Equal(t, 2, cfg.InfluxDBVersion, "default influxdb version")
+	assert.True(t, cfg.InfluxDBGzip, "gzip on by default")
+	assert.Equal(t, 1000, cfg.InfluxDBBatchSize, "default influxdb batch size")
+	assert.Equal(t, 50000, cfg.InfluxDBQueueSize, "default influxdb queue size")
+	assert.Equal(t, 30, cfg.InfluxDBTimeout, "default influxdb timeout")
+	assert.Equal(t, OTLPProtocolGRPC, cfg.OTLPProtocol, "default otlp protocol")
+	assert.Equal(t, 500, cfg.OTLPBatchSize, "default otlp batch size")
+}
+
+func TestLoadConfig_Tags(t *testing.T) {
+	t.Parallel()
+	content := []byte(`
+address: https://192.168.1.100
+serial: 123456
+jwt: sometoken
+influxdb: http://localhost:8086
+influxdb_token: tok
+influxdb_org: org
+influxdb_bucket: bucket
+tags:
+  site: home
+  region: us-west
+devices:
+  122012345678:
+    array: east
+    roof_face: east
+    azimuth: 90
+    tilt: 0
+    panel_model: REC Alpha 405
+`)
+	f, err := os.CreateTemp("", "config-*.yaml")
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	cfg, err := LoadConfig(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"site": "home", "region": "us-west"}, cfg.Tags)
+	d, ok := cfg.Devices["122012345678"]
+	require.True(t, ok, "numeric serial keys load as strings")
+	assert.Equal(t, "east", d.Array)
+	require.NotNil(t, d.Tilt)
+	assert.Zero(t, *d.Tilt, "a flat mount is distinct from unset")
+	assert.Nil(t, cfg.Devices["other"].Azimuth)
 }
 
 func TestLoadConfig_MissingFile(t *testing.T) {
@@ -138,6 +188,132 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid with sqlite only",
+			mutate: func(c *Config) {
+				c.InfluxDB = ""
+				c.InfluxDBBucket = ""
+				c.InfluxDBToken = ""
+				c.InfluxDBOrg = ""
+				c.SQLitePath = "/var/lib/envoy-exporter/envoy.db"
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid with otlp only",
+			mutate: func(c *Config) {
+				c.InfluxDB = ""
+				c.OTLPEndpoint = "otel-collector:4317"
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid influxdb v1",
+			mutate: func(c *Config) {
+				c.InfluxDBVersion = 1
+				c.InfluxDBToken, c.InfluxDBOrg, c.InfluxDBBucket = "", "", ""
+				c.InfluxDBDatabase = "solar"
+			},
+			wantErr: false,
+		},
+		{
+			name: "influxdb v1 missing database",
+			mutate: func(c *Config) {
+				c.InfluxDBVersion = 1
+			},
+			wantErr: true,
+		},
+		{
+			name: "influxdb v1 username without password",
+			mutate: func(c *Config) {
+				c.InfluxDBVersion = 1
+				c.InfluxDBDatabase = "solar"
+				c.InfluxDBUsername = "grafana"
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid influxdb v3",
+			mutate: func(c *Config) {
+				c.InfluxDBVersion = 3
+				c.InfluxDBOrg, c.InfluxDBBucket = "", ""
+				c.InfluxDBDatabase = "solar"
+			},
+			wantErr: false,
+		},
+		{
+			name: "influxdb v3 missing database",
+			mutate: func(c *Config) {
+				c.InfluxDBVersion = 3
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown influxdb version",
+			mutate: func(c *Config) {
+				c.InfluxDBVersion = 4
+			},
+			wantErr: true,
+		},
+		{
+			name: "reserved static tag",
+			mutate: func(c *Config) {
+				c.Tags = map[string]string{TagSerial: "x"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty static tag value",
+			mutate: func(c *Config) {
+				c.Tags = map[string]string{"site": ""}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown schema",
+			mutate: func(c *Config) {
+				c.Schema = "flat"
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad measurement template",
+			mutate: func(c *Config) {
+				c.Schema = SchemaNormalized
+				c.MeasurementTemplate = "{{.Family"
+			},
+			wantErr: true,
+		},
+		{
+			name: "tracing otlp without endpoint",
+			mutate: func(c *Config) {
+				c.Tracing = TracingOTLP
+			},
+			wantErr: true,
+		},
+		{
+			name: "tracing otlp reuses otlp_endpoint",
+			mutate: func(c *Config) {
+				c.Tracing = TracingOTLP
+				c.OTLPEndpoint = "otel-collector:4317"
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown tracing exporter",
+			mutate: func(c *Config) {
+				c.Tracing = "jaeger"
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid otlp protocol",
+			mutate: func(c *Config) {
+				c.OTLPEndpoint = "otel-collector:4317"
+				c.OTLPProtocol = "thrift"
+			},
+			wantErr: true,
+		},
 		{
 			name: "missing influxdb bucket",
 			mutate: func(c *Config) {
@@ -177,3 +353,11 @@ func TestConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigStaleAfter(t *testing.T) {
+	t.Parallel()
+	cfg := Config{Interval: 30}
+	assert.Equal(t, 90*time.Second, cfg.StaleAfter(), "defaults to 3 × interval")
+	cfg.StalenessThreshold = 45
+	assert.Equal(t, 45*time.Second, cfg.StaleAfter())
+}
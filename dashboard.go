@@ -0,0 +1,68 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardData is the JSON document polled by the embedded dashboard.
+// It combines every /api/v1 family with the exporter's own status.
+type dashboardData struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	IntervalS   int             `json:"interval_s"`
+	SnapshotAt  time.Time       `json:"snapshot_at"`
+	Snapshot    *apiSnapshot    `json:"snapshot,omitempty"`
+	Inverters   []apiInverter   `json:"inverters"`
+	Batteries   []apiBattery    `json:"batteries"`
+	Meters      []apiMeter      `json:"meters"`
+	Status      dashboardStatus `json:"status"`
+}
+
+type dashboardStatus struct {
+	LastScrape   time.Time `json:"last_scrape"`
+	DurationMS   int64     `json:"duration_ms"`
+	Points       int       `json:"points"`
+	Errors       bool      `json:"errors"`
+	ScrapeTotal  int64     `json:"scrape_total"`
+	ScrapeErrors int64     `json:"scrape_errors"`
+}
+
+// buildDashboardData converts a store view into the dashboard document.
+func buildDashboardData(v storeView, interval time.Duration, now time.Time) dashboardData {
+	d := dashboardData{
+		GeneratedAt: now,
+		IntervalS:   int(interval / time.Second),
+		SnapshotAt:  v.SnapshotAt,
+		Inverters:   toAPIInverters(v.Inverters),
+		Batteries:   toAPIBatteries(v.Batteries),
+		Meters:      toAPIMeters(v.Meters),
+		Status: dashboardStatus{
+			LastScrape:   v.LastScrape,
+			DurationMS:   v.LastScrapeDur.Milliseconds(),
+			Points:       v.LastScrapePoints,
+			Errors:       v.LastScrapeErr,
+			ScrapeTotal:  metricScrapeTotal.Value(),
+			ScrapeErrors: metricScrapeErrors.Value(),
+		},
+	}
+	if !v.SnapshotAt.IsZero() {
+		snap := toAPISnapshot(v.Snapshot)
+		d.Snapshot = &snap
+	}
+	return d
+}
+
+// registerDashboard adds the dashboard page and its data endpoint, backed by store, to mux.
+func registerDashboard(mux *http.ServeMux, store *scrapeStore, interval time.Duration) {
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("GET /dashboard/data", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, buildDashboardData(store.view(), interval, time.Now()))
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildDashboardData(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	v := storeView{
+		Snapshot:   gateway.SnapshotFromLiveData(makeLiveData(4000000, 0, -1000000, 3000000)),
+		SnapshotAt: now,
+		Inverters:  []gateway.InverterReading{{SerialNumber: "INV1", LastReportWatts: 250, MaxReportWatts: 300}},
+		Batteries:  []gateway.BatteryStatus{{SerialNum: "BAT1", PercentFull: 60, Temperature: 25, Communicating: true}},
+		Meters: []gateway.TypedCTReading{{
+			CTReading: gateway.CTReading{Channels: []gateway.CTChannel{
+				{ActivePower: 100, Voltage: 240},
+				{ActivePower: 200, Voltage: 241},
+			}},
+			MeasurementType: MeasurementProduction,
+		}},
+		LastScrape:       now,
+		LastScrapeDur:    1500 * time.Millisecond,
+		LastScrapePoints: 5,
+	}
+
+	d := buildDashboardData(v, 30*time.Second, now)
+	require.NotNil(t, d.Snapshot)
+	assert.Equal(t, 4000.0, d.Snapshot.SolarW)
+	assert.Equal(t, 1000.0, d.Snapshot.SolarToGridW)
+	assert.Equal(t, 30, d.IntervalS)
+	require.Len(t, d.Inverters, 1)
+	assert.Equal(t, "INV1", d.Inverters[0].Serial)
+	assert.Equal(t, 300, d.Inverters[0].MaxReportWatts)
+	require.Len(t, d.Batteries, 1)
+	assert.Equal(t, 60, d.Batteries[0].PercentFull)
+	require.Len(t, d.Meters, 2, "one row per CT channel")
+	assert.Equal(t, 1, d.Meters[1].Line)
+	assert.Equal(t, 241.0, d.Meters[1].VoltageV)
+	assert.Equal(t, int64(1500), d.Status.DurationMS)
+}
+
+func TestBuildDashboardData_NoData(t *testing.T) {
+	t.Parallel()
+
+	d := buildDashboardData(storeView{}, 5*time.Second, time.Now())
+	assert.Nil(t, d.Snapshot, "snapshot is omitted until live data has been read")
+
+	// Empty families must encode as [] so the page can iterate them.
+	raw, err := json.Marshal(d)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `"inverters":[]`)
+	assert.Contains(t, string(raw), `"meters":[]`)
+}
+
+func TestDashboardHandlers(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	registerDashboard(mux, &scrapeStore{}, 5*time.Second)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "dashboard/data")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard/data", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var d dashboardData
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&d))
+	assert.Equal(t, 5, d.IntervalS)
+
+	// Only the root path serves the page; unknown paths stay 404.
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
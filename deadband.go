@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultHeartbeat is the longest a deadbanded field goes unwritten.
+const defaultHeartbeat = 10 * time.Minute
+
+var promDeadbandSuppressed = promFactory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "envoy_exporter",
+	Name:      "deadband_suppressed_fields_total",
+	Help:      "Field values not written because they were within their deadband.",
+}, []string{"field"})
+
+// DeadbandConfig configures change-only writes of selected fields. It is
+// enabled when fields are set.
+type DeadbandConfig struct {
+	Heartbeat int                     `yaml:"heartbeat"` // seconds a field may go unwritten; default 600
+	Fields    map[string]DeadbandRule `yaml:"fields"`    // by field name, in any measurement
+}
+
+// DeadbandRule is how far a field must move from its last written value to
+// be written again: more than the absolute amount and the percentage of the
+// last value, whichever is larger. Non-numeric fields, and numeric ones
+// without a band, are written whenever they change.
+type DeadbandRule struct {
+	Absolute float64 `yaml:"absolute"`
+	Percent  float64 `yaml:"percent"`
+}
+
+// validate checks that bands and the heartbeat are not negative.
+func (c DeadbandConfig) validate() error {
+	if c.Heartbeat < 0 {
+		return fmt.Errorf("deadband: heartbeat must not be negative")
+	}
+	for field, r := range c.Fields {
+		if r.Absolute < 0 || r.Percent < 0 {
+			return fmt.Errorf("deadband: field %q: absolute and percent must not be negative", field)
+		}
+	}
+	return nil
+}
+
+// heartbeat returns the configured heartbeat or the default.
+func (c DeadbandConfig) heartbeat() time.Duration {
+	if c.Heartbeat > 0 {
+		return time.Duration(c.Heartbeat) * time.Second
+	}
+	return defaultHeartbeat
+}
+
+// within reports whether v is close enough to last to be suppressed.
+func (r DeadbandRule) within(last, v any) bool {
+	lf, lok := fieldFloat(last)
+	vf, vok := fieldFloat(v)
+	_, isBool := v.(bool)
+	if !lok || !vok || isBool {
+		return last == v
+	}
+	band := max(r.Absolute, r.Percent/100*math.Abs(lf))
+	return math.Abs(vf-lf) <= band
+}
+
+// deadbandValue is the last written value of one field of one series.
+type deadbandValue struct {
+	v  any
+	at time.Time
+}
+
+// DeadbandWriter drops configured fields from points while they stay within
+// their deadband of the last written value, unless the heartbeat is due.
+// Points left without fields are not written. Other fields pass unchanged.
+type DeadbandWriter struct {
+	next      PointWriter
+	rules     map[string]DeadbandRule
+	heartbeat time.Duration
+
+	mu   sync.Mutex
+	last map[string]deadbandValue // by series and field
+}
+
+// NewDeadbandWriter returns a DeadbandWriter writing to next.
+func NewDeadbandWriter(next PointWriter, cfg DeadbandConfig) *DeadbandWriter {
+	return &DeadbandWriter{
+		next:      next,
+		rules:     cfg.Fields,
+		heartbeat: cfg.heartbeat(),
+		last:      make(map[string]deadbandValue),
+	}
+}
+
+// WritePoint writes the points with suppressed fields removed. The values
+// written are only remembered once next accepts them, so a failed write
+// does not suppress the retry.
+func (d *DeadbandWriter) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	points, written := d.filter(point)
+	if len(points) == 0 {
+		return nil
+	}
+	if err := d.next.WritePoint(ctx, points...); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, v := range written {
+		d.last[k] = v
+	}
+	return nil
+}
+
+// filter returns the points to write and the deadbanded values in them.
+func (d *DeadbandWriter) filter(points []*influxdb2write.Point) ([]*influxdb2write.Point, map[string]deadbandValue) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]*influxdb2write.Point, 0, len(points))
+	written := make(map[string]deadbandValue)
+	for _, pt := range points {
+		series := pt.Name() + "\x00" + canonicalTags(pt)
+		ts := pt.Time()
+		var keep []*lp.Field
+		suppressed := false
+		for _, f := range pt.FieldList() {
+			rule, ok := d.rules[f.Key]
+			if !ok {
+				keep = append(keep, f)
+				continue
+			}
+			k := series + "\x00" + f.Key
+			last, seen := d.last[k]
+			if seen && ts.Sub(last.at) < d.heartbeat && rule.within(last.v, f.Value) {
+				promDeadbandSuppressed.WithLabelValues(f.Key).Inc()
+				suppressed = true
+				continue
+			}
+			keep = append(keep, f)
+			written[k] = deadbandValue{v: f.Value, at: ts}
+		}
+		switch {
+		case !suppressed:
+			out = append(out, pt)
+		case len(keep) > 0:
+			out = append(out, rebuildPoint(pt, keep))
+		}
+	}
+	return out, written
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadbandConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, DeadbandConfig{Heartbeat: 300, Fields: map[string]DeadbandRule{"temperature": {Absolute: 1}}}.validate())
+	assert.Error(t, DeadbandConfig{Heartbeat: -1}.validate())
+	assert.Error(t, DeadbandConfig{Fields: map[string]DeadbandRule{"capacity_wh": {Percent: -1}}}.validate())
+
+	assert.Equal(t, defaultHeartbeat, DeadbandConfig{}.heartbeat())
+	assert.Equal(t, time.Minute, DeadbandConfig{Heartbeat: 60}.heartbeat())
+}
+
+func TestDeadbandRule_Within(t *testing.T) {
+	t.Parallel()
+
+	abs := DeadbandRule{Absolute: 1}
+	assert.True(t, abs.within(int64(28), int64(29)))
+	assert.False(t, abs.within(int64(28), int64(30)))
+	pct := DeadbandRule{Absolute: 10, Percent: 1}
+	assert.True(t, pct.within(3500.0, 3530.0), "1% of 3500 is wider than 10")
+	assert.False(t, pct.within(3500.0, 3540.0))
+	assert.True(t, pct.within(100.0, 109.0), "10 is wider than 1% of 100")
+
+	exact := DeadbandRule{}
+	assert.True(t, exact.within("multimode-ongrid", "multimode-ongrid"))
+	assert.False(t, exact.within("multimode-ongrid", "multimode-offgrid"))
+	assert.False(t, abs.within(true, false), "booleans are compared exactly")
+	assert.False(t, exact.within(1.0, 1.5))
+}
+
+func deadbandTestPoint(temp int64, mode string, soc int64, t time.Time) *influxdb2write.Point {
+	return influxdb2.NewPointWithMeasurement("battery-BAT1").
+		AddTag("serial", "BAT1").
+		AddField("percent_full", soc).
+		AddField("temperature", temp).
+		AddField("grid_mode", mode).
+		SetTime(t)
+}
+
+func TestDeadbandWriter(t *testing.T) {
+	t.Parallel()
+
+	next := &MockPointWriter{}
+	d := NewDeadbandWriter(next, DeadbandConfig{Heartbeat: 600, Fields: map[string]DeadbandRule{
+		"temperature": {Absolute: 1},
+		"grid_mode":   {},
+	}})
+	ctx := context.Background()
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, d.WritePoint(ctx, deadbandTestPoint(28, "on-grid", 80, base)))
+	require.NoError(t, d.WritePoint(ctx, deadbandTestPoint(29, "on-grid", 79, base.Add(time.Minute))))
+	require.Len(t, next.Written, 2)
+	assert.Equal(t, map[string]any{"percent_full": int64(80), "temperature": int64(28), "grid_mode": "on-grid"}, fieldMap(next.Written[0]))
+	assert.Equal(t, map[string]any{"percent_full": int64(79)}, fieldMap(next.Written[1]), "unchanged fields dropped")
+	assert.Equal(t, "BAT1", tagMap(next.Written[1])["serial"])
+	assert.Equal(t, base.Add(time.Minute), next.Written[1].Time())
+
+	require.NoError(t, d.WritePoint(ctx, deadbandTestPoint(30, "off-grid", 78, base.Add(2*time.Minute))))
+	assert.Equal(t, map[string]any{"percent_full": int64(78), "temperature": int64(30), "grid_mode": "off-grid"}, fieldMap(next.Written[2]))
+
+	// The heartbeat writes unchanged values.
+	require.NoError(t, d.WritePoint(ctx, deadbandTestPoint(30, "off-grid", 78, base.Add(12*time.Minute))))
+	assert.Len(t, fieldMap(next.Written[3]), 3)
+}
+
+func TestDeadbandWriter_DropsEmptyPoints(t *testing.T) {
+	t.Parallel()
+
+	next := &MockPointWriter{}
+	d := NewDeadbandWriter(next, DeadbandConfig{Fields: map[string]DeadbandRule{"capacity_wh": {Percent: 1}}})
+	pt := func(wh float64) *influxdb2write.Point {
+		return influxdb2.NewPointWithMeasurement("battery-BAT1").AddField("capacity_wh", wh).SetTime(time.Now())
+	}
+	require.NoError(t, d.WritePoint(context.Background(), pt(3500)))
+	require.NoError(t, d.WritePoint(context.Background(), pt(3510)))
+	assert.Len(t, next.Written, 1)
+}
+
+func TestDeadbandWriter_FailedWriteNotRemembered(t *testing.T) {
+	t.Parallel()
+
+	fail := true
+	var written []*influxdb2write.Point
+	next := &MockPointWriter{WritePointFunc: func(_ context.Context, pts ...*influxdb2write.Point) error {
+		if fail {
+			return errors.New("down")
+		}
+		written = append(written, pts...)
+		return nil
+	}}
+	d := NewDeadbandWriter(next, DeadbandConfig{Fields: map[string]DeadbandRule{"temperature": {Absolute: 5}}})
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Error(t, d.WritePoint(context.Background(), deadbandTestPoint(28, "on-grid", 80, base)))
+	fail = false
+	require.NoError(t, d.WritePoint(context.Background(), deadbandTestPoint(28, "on-grid", 80, base.Add(time.Minute))))
+	require.Len(t, written, 1)
+	assert.Contains(t, fieldMap(written[0]), "temperature")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Meter tag values for energy points: the CT readings, and the sources the
+// gateway's energy summary reports.
+const (
+	MeterCT  = "ct"  // per-meter, per-phase CT registers
+	MeterPCU = "pcu" // microinverter aggregate
+	MeterRGM = "rgm" // revenue grade meter
+	MeterEIM = "eim" // gateway integrated meter
+)
+
+// FieldCounterReset is set on a point whose lifetime counter went backwards.
+const FieldCounterReset = "counter_reset"
+
+var promCounterResets = promFactory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "envoy_exporter",
+	Name:      "energy_counter_resets_total",
+	Help:      "Lifetime energy counters seen going backwards, e.g. after a gateway reset or replacement.",
+}, []string{"meter"})
+
+// energyCounters remembers the last lifetime value of every energy counter
+// written by scrape.
+var energyCounters = newCounterTracker()
+
+// counterTracker detects resets of cumulative counters. The gateway's
+// lifetime registers only decrease when it is reset or replaced; downstream
+// rate calculations need to know so they do not see a huge negative delta.
+type counterTracker struct {
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{last: make(map[string]float64)}
+}
+
+// observe records v for key and reports whether it is lower than the previous
+// value, which it also returns. A nil tracker never reports a reset.
+func (c *counterTracker) observe(key string, v float64) (prev float64, reset bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, seen := c.last[key]
+	c.last[key] = v
+	return prev, seen && v < prev
+}
+
+// checkReset marks pt when any of the lifetime values went backwards.
+func (c *counterTracker) checkReset(pt *influxdb2write.Point, meter, key string, lifetime map[string]float64) {
+	for field, v := range lifetime {
+		if prev, reset := c.observe(key+"/"+field, v); reset {
+			slog.Warn("Energy counter went backwards; gateway reset or replaced?",
+				"counter", key, "field", field, "previous", prev, "value", v)
+			promCounterResets.WithLabelValues(meter).Inc()
+			pt.AddField(FieldCounterReset, true)
+		}
+	}
+}
+
+// extractMeterEnergyPoints converts the cumulative registers of typed CT
+// readings into energy points: one for each meter's total and one per phase.
+// Legacy measurement names are energy-<prefix> and energy-<prefix>-line<N>,
+// with the same prefixes as extractCTPoints.
+func extractMeterEnergyPoints(readings []gateway.TypedCTReading, schema *Schema, counters *counterTracker, t time.Time) []*influxdb2write.Point {
+	var ps []*influxdb2write.Point
+	for _, r := range readings {
+		namePrefix, ok := ctNamePrefix(r.MeasurementType)
+		if !ok {
+			continue
+		}
+		k := pointKey{Family: FamilyEnergy, Type: r.MeasurementType, Legacy: "energy-" + namePrefix}
+		ps = append(ps, meterEnergyPoint(k, "", r.CTChannel, schema, counters, t))
+		for i, ch := range r.Channels {
+			line := strconv.Itoa(i)
+			k := pointKey{Family: FamilyEnergy, Type: r.MeasurementType, Line: line, Legacy: fmt.Sprintf("energy-%s-line%d", namePrefix, i)}
+			ps = append(ps, meterEnergyPoint(k, line, ch, schema, counters, t))
+		}
+	}
+	return ps
+}
+
+func meterEnergyPoint(k pointKey, line string, ch gateway.CTChannel, schema *Schema, counters *counterTracker, t time.Time) *influxdb2write.Point {
+	pt := schema.newPoint(k, t).
+		AddTag(TagMeasurementType, k.Type).
+		AddTag(TagMeter, MeterCT)
+	if line != "" {
+		pt.AddTag(TagLineIdx, line)
+	}
+	pt.AddField("lifetime_delivered_wh", ch.ActEnergyDelivered).
+		AddField("lifetime_received_wh", ch.ActEnergyReceived)
+	counters.checkReset(pt, MeterCT, schema.Source+"/"+MeterCT+"/"+k.Type+"/"+line, map[string]float64{
+		"delivered": ch.ActEnergyDelivered,
+		"received":  ch.ActEnergyReceived,
+	})
+	return schema.renameFields(FamilyEnergy, pt)
+}
+
+// extractEnergyPoints converts the gateway's energy summary into one point
+// per reporting source. Sources the gateway does not have report all zeros
+// and are skipped.
+func extractEnergyPoints(data gateway.EnergyData, schema *Schema, counters *counterTracker, t time.Time) []*influxdb2write.Point {
+	buckets := []struct {
+		typ, prefix, meter string
+		b                  gateway.EnergyBucket
+	}{
+		{MeasurementProduction, MeasurementProduction, MeterPCU, data.Production.PCU},
+		{MeasurementProduction, MeasurementProduction, MeterRGM, data.Production.RGM},
+		{MeasurementProduction, MeasurementProduction, MeterEIM, data.Production.EIM},
+		{MeasurementTotalConsumption, "consumption", MeterEIM, data.Consumption.EIM},
+	}
+	var ps []*influxdb2write.Point
+	for _, b := range buckets {
+		if b.b == (gateway.EnergyBucket{}) {
+			continue
+		}
+		k := pointKey{Family: FamilyEnergy, Type: b.typ, Legacy: fmt.Sprintf("energy-%s-%s", b.prefix, b.meter)}
+		pt := schema.newPoint(k, t).
+			AddTag(TagMeasurementType, b.typ).
+			AddTag(TagMeter, b.meter).
+			AddField("lifetime_wh", b.b.WattHoursLifetime).
+			AddField("today_wh", b.b.WattHoursToday).
+			AddField("seven_days_wh", b.b.WattHoursSevenDays)
+		counters.checkReset(pt, b.meter, schema.Source+"/"+b.meter+"/"+b.typ, map[string]float64{
+			"lifetime": float64(b.b.WattHoursLifetime),
+		})
+		ps = append(ps, schema.renameFields(FamilyEnergy, pt))
+	}
+	return ps
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractMeterEnergyPoints(t *testing.T) {
+	t.Parallel()
+
+	readings := []gateway.TypedCTReading{
+		{
+			CTReading: gateway.CTReading{
+				CTChannel: gateway.CTChannel{ActEnergyDelivered: 3000, ActEnergyReceived: 10},
+				Channels: []gateway.CTChannel{
+					{ActEnergyDelivered: 1000, ActEnergyReceived: 5},
+					{ActEnergyDelivered: 2000, ActEnergyReceived: 5},
+				},
+			},
+			MeasurementType: MeasurementProduction,
+		},
+		{
+			CTReading:       gateway.CTReading{Channels: []gateway.CTChannel{{ActEnergyDelivered: 1}}},
+			MeasurementType: "storage", // not written
+		},
+	}
+
+	pts := extractMeterEnergyPoints(readings, &Schema{Source: "home"}, nil, time.Now())
+	require.Len(t, pts, 3)
+
+	assert.Equal(t, "energy-production", pts[0].Name())
+	tags := tagMap(pts[0])
+	assert.Equal(t, MeterCT, tags[TagMeter])
+	assert.Equal(t, MeasurementProduction, tags[TagMeasurementType])
+	assert.NotContains(t, tags, TagLineIdx, "meter total has no line")
+	assert.Equal(t, 3000.0, fieldMap(pts[0])["lifetime_delivered_wh"])
+	assert.Equal(t, 10.0, fieldMap(pts[0])["lifetime_received_wh"])
+
+	assert.Equal(t, "energy-production-line1", pts[2].Name())
+	assert.Equal(t, "1", tagMap(pts[2])[TagLineIdx])
+	assert.Equal(t, 2000.0, fieldMap(pts[2])["lifetime_delivered_wh"])
+}
+
+func TestExtractEnergyPoints(t *testing.T) {
+	t.Parallel()
+
+	var data gateway.EnergyData
+	data.Production.PCU = gateway.EnergyBucket{WattHoursToday: 12000, WattHoursSevenDays: 80000, WattHoursLifetime: 9000000}
+	data.Consumption.EIM = gateway.EnergyBucket{WattHoursToday: 15000, WattHoursLifetime: 7000000}
+
+	s, err := NewSchema(SchemaNormalized, "home", "", "")
+	require.NoError(t, err)
+	pts := extractEnergyPoints(data, s, nil, time.Now())
+	require.Len(t, pts, 2, "absent rgm and production eim are skipped")
+
+	assert.Equal(t, "energy", pts[0].Name())
+	assert.Equal(t, MeterPCU, tagMap(pts[0])[TagMeter])
+	assert.Equal(t, MeasurementProduction, tagMap(pts[0])[TagMeasurementType])
+	fields := fieldMap(pts[0])
+	assert.Equal(t, int64(9000000), fields["lifetime_wh"])
+	assert.Equal(t, int64(12000), fields["today_wh"])
+	assert.Equal(t, int64(80000), fields["seven_days_wh"])
+
+	assert.Equal(t, MeterEIM, tagMap(pts[1])[TagMeter])
+	assert.Equal(t, MeasurementTotalConsumption, tagMap(pts[1])[TagMeasurementType])
+
+	pts = extractEnergyPoints(data, &Schema{Source: "home"}, nil, time.Now())
+	assert.Equal(t, "energy-production-pcu", pts[0].Name())
+	assert.Equal(t, "energy-consumption-eim", pts[1].Name())
+}
+
+func TestCounterTracker_DetectsReset(t *testing.T) {
+	t.Parallel()
+
+	counters := newCounterTracker()
+	s := &Schema{Source: "counter-reset-test"}
+	before := testutil.ToFloat64(promCounterResets.WithLabelValues(MeterRGM))
+
+	bucket := func(lifetime int) gateway.EnergyData {
+		var d gateway.EnergyData
+		d.Production.RGM = gateway.EnergyBucket{WattHoursLifetime: lifetime, WattHoursToday: 1}
+		return d
+	}
+
+	pts := extractEnergyPoints(bucket(5000), s, counters, time.Now())
+	assert.NotContains(t, fieldMap(pts[0]), FieldCounterReset, "first reading is not a reset")
+	pts = extractEnergyPoints(bucket(5100), s, counters, time.Now())
+	assert.NotContains(t, fieldMap(pts[0]), FieldCounterReset)
+
+	// Gateway replaced: lifetime starts over.
+	pts = extractEnergyPoints(bucket(20), s, counters, time.Now())
+	assert.Equal(t, true, fieldMap(pts[0])[FieldCounterReset])
+	assert.Equal(t, before+1, testutil.ToFloat64(promCounterResets.WithLabelValues(MeterRGM)))
+
+	pts = extractEnergyPoints(bucket(30), s, counters, time.Now())
+	assert.NotContains(t, fieldMap(pts[0]), FieldCounterReset, "counting resumes from the new value")
+
+	// Per-phase CT registers are tracked independently.
+	ct := func(l0, l1 float64) []gateway.TypedCTReading {
+		return []gateway.TypedCTReading{{
+			CTReading:       gateway.CTReading{Channels: []gateway.CTChannel{{ActEnergyDelivered: l0}, {ActEnergyDelivered: l1}}},
+			MeasurementType: MeasurementNetConsumption,
+		}}
+	}
+	extractMeterEnergyPoints(ct(100, 100), s, counters, time.Now())
+	pts = extractMeterEnergyPoints(ct(110, 50), s, counters, time.Now())
+	require.Len(t, pts, 3)
+	assert.NotContains(t, fieldMap(pts[1]), FieldCounterReset)
+	assert.Equal(t, true, fieldMap(pts[2])[FieldCounterReset])
+}
+
+func TestScrape_EnergyNotFound(t *testing.T) {
+	t.Parallel()
+
+	client := &MockEnvoyClient{
+		EnergyFunc: func(_ context.Context) (gateway.EnergyData, error) {
+			return gateway.EnergyData{}, &gateway.Error{StatusCode: 404, Endpoint: "/ivp/pdm/energy"}
+		},
+	}
+	result := scrape(context.Background(), client, &MockPointWriter{}, &Schema{Source: "test"})
+	assert.False(t, result.hasErr, "404 from energy endpoint should not be treated as an error")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// EnsembleStatus is the state of an IQ System Controller (Enpower) and the
+// battery system it manages. Optional parts are nil when the gateway does
+// not report them.
+type EnsembleStatus struct {
+	RelayAdminState string // mains relay commanded state: closed, open
+	RelayOperState  string // mains relay actual state, empty when not reported
+	GridMode        string // microgrid state, e.g. multimode-ongrid; empty when not reported
+	AggSOC          int    // aggregate SOC of all storage, %
+	EnchargeSOC     int    // aggregate SOC of IQ Batteries, %
+	MaxEnergyWh     int
+	BackupEnergyWh  int
+	AvailEnergyWh   int
+
+	Generator     *GeneratorStatus
+	DryContacts   []DryContact
+	BackupReserve *float64 // reserved SOC for backup, %
+	StorageMode   string   // self-consumption, savings-mode, backup
+}
+
+// GeneratorStatus is the state of a generator connected to the controller.
+type GeneratorStatus struct {
+	AdminState string `json:"admin_state"` // on, off
+	OperState  string `json:"oper_state"`  // on, off, running
+	AdminMode  string `json:"admin_mode"`  // auto, manual
+}
+
+// Running reports whether the generator is supplying power.
+func (g GeneratorStatus) Running() bool {
+	return g.OperState == "on" || g.OperState == "running"
+}
+
+// DryContact is one of the controller's load-control relays.
+type DryContact struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // open, closed
+}
+
+// ensembleStatusDoc is the subset of /ivp/ensemble/status used here.
+type ensembleStatusDoc struct {
+	Relay struct {
+		MainsAdminState string `json:"mains_admin_state"`
+		MainsOperState  string `json:"mains_oper_state"`
+		GridMode        string `json:"Enchg_grid_mode"`
+	} `json:"relay"`
+	SecCtrl struct {
+		AggSOC       int `json:"agg_soc"`
+		EnchargeSOC  int `json:"ENC_agg_soc"`
+		MaxEnergy    int `json:"Max_energy"`
+		BackupEnergy int `json:"ENC_agg_backup_energy"`
+		AvailEnergy  int `json:"ENC_agg_avail_energy"`
+	} `json:"secctrl"`
+}
+
+// Ensemble returns the System Controller state (GET /ivp/ensemble/status,
+// plus the generator, dry contact and storage settings endpoints). A 404
+// from the status endpoint means no System Controller is installed; the
+// other endpoints are optional and skipped when absent.
+func (c *gatewayClient) Ensemble(ctx context.Context) (EnsembleStatus, error) {
+	var doc ensembleStatusDoc
+	if err := c.getJSON(ctx, "/ivp/ensemble/status", &doc); err != nil {
+		return EnsembleStatus{}, err
+	}
+	s := EnsembleStatus{
+		RelayAdminState: doc.Relay.MainsAdminState,
+		RelayOperState:  doc.Relay.MainsOperState,
+		GridMode:        doc.Relay.GridMode,
+		AggSOC:          doc.SecCtrl.AggSOC,
+		EnchargeSOC:     doc.SecCtrl.EnchargeSOC,
+		MaxEnergyWh:     doc.SecCtrl.MaxEnergy,
+		BackupEnergyWh:  doc.SecCtrl.BackupEnergy,
+		AvailEnergyWh:   doc.SecCtrl.AvailEnergy,
+	}
+
+	var gen GeneratorStatus
+	if err := c.optionalJSON(ctx, "/ivp/ensemble/generator", &gen); err != nil {
+		return EnsembleStatus{}, err
+	}
+	if gen != (GeneratorStatus{}) {
+		s.Generator = &gen
+	}
+
+	var contacts struct {
+		DryContacts []DryContact `json:"dry_contacts"`
+	}
+	if err := c.optionalJSON(ctx, "/ivp/ensemble/dry_contacts", &contacts); err != nil {
+		return EnsembleStatus{}, err
+	}
+	s.DryContacts = contacts.DryContacts
+
+	var tariff struct {
+		Tariff struct {
+			StorageSettings struct {
+				Mode        string   `json:"mode"`
+				ReservedSOC *float64 `json:"reserved_soc"`
+			} `json:"storage_settings"`
+		} `json:"tariff"`
+	}
+	if err := c.optionalJSON(ctx, "/admin/lib/tariff", &tariff); err != nil {
+		return EnsembleStatus{}, err
+	}
+	s.BackupReserve = tariff.Tariff.StorageSettings.ReservedSOC
+	s.StorageMode = tariff.Tariff.StorageSettings.Mode
+	return s, nil
+}
+
+// optionalJSON is getJSON for endpoints that only some installations have:
+// a 404 leaves out untouched and is not an error.
+func (c *gatewayClient) optionalJSON(ctx context.Context, path string, out any) error {
+	if err := c.getJSON(ctx, path, out); err != nil && !gateway.IsNotFound(err) {
+		return fmt.Errorf("ensemble: %w", err)
+	}
+	return nil
+}
+
+// extractEnsemblePoints builds the ensemble point (relay, microgrid,
+// generator and storage state) and one point per dry contact.
+func extractEnsemblePoints(s EnsembleStatus, schema *Schema, t time.Time) []*influxdb2write.Point {
+	pt := schema.newPoint(pointKey{Family: FamilyEnsemble, Legacy: "ensemble"}, t).
+		AddField("agg_soc", s.AggSOC).
+		AddField("encharge_soc", s.EnchargeSOC).
+		AddField("max_energy_wh", s.MaxEnergyWh).
+		AddField("backup_energy_wh", s.BackupEnergyWh).
+		AddField("available_energy_wh", s.AvailEnergyWh)
+	if s.RelayOperState != "" {
+		pt.AddField("relay_closed", s.RelayOperState == "closed").
+			AddField("relay_admin_state", s.RelayAdminState).
+			AddField("relay_oper_state", s.RelayOperState)
+	}
+	if s.GridMode != "" {
+		pt.AddField("grid_mode", s.GridMode).
+			AddField("on_grid", strings.HasSuffix(s.GridMode, "ongrid"))
+	}
+	if s.BackupReserve != nil {
+		pt.AddField("backup_reserve_soc", *s.BackupReserve)
+	}
+	if s.StorageMode != "" {
+		pt.AddField("storage_mode", s.StorageMode)
+	}
+	if g := s.Generator; g != nil {
+		pt.AddField("generator_running", g.Running()).
+			AddField("generator_admin_state", g.AdminState).
+			AddField("generator_oper_state", g.OperState).
+			AddField("generator_mode", g.AdminMode)
+	}
+	ps := []*influxdb2write.Point{schema.renameFields(FamilyEnsemble, pt)}
+
+	for _, dc := range s.DryContacts {
+		k := pointKey{Family: FamilyDryContact, Legacy: "dry-contact"}
+		pt := schema.newPoint(k, t).
+			AddTag(TagContact, dc.ID).
+			AddField("closed", dc.Status == "closed").
+			AddField("status", dc.Status)
+		ps = append(ps, schema.renameFields(FamilyDryContact, pt))
+	}
+	return ps
+}
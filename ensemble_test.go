@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ensembleServer(t *testing.T, routes map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGatewayClient_Ensemble(t *testing.T) {
+	t.Parallel()
+
+	srv := ensembleServer(t, map[string]string{
+		"/ivp/ensemble/status": `{
+			"relay": {"mains_admin_state": "closed", "mains_oper_state": "closed", "Enchg_grid_mode": "multimode-ongrid"},
+			"secctrl": {"agg_soc": 84, "ENC_agg_soc": 85, "Max_energy": 10080, "ENC_agg_backup_energy": 3024, "ENC_agg_avail_energy": 8568}
+		}`,
+		"/ivp/ensemble/generator":    `{"admin_state": "on", "oper_state": "running", "admin_mode": "auto"}`,
+		"/ivp/ensemble/dry_contacts": `{"dry_contacts": [{"id": "NC1", "status": "closed"}, {"id": "NO1", "status": "open"}]}`,
+		"/admin/lib/tariff":          `{"tariff": {"storage_settings": {"mode": "self-consumption", "reserved_soc": 30.0}}}`,
+	})
+
+	s, err := newGatewayClient(&Config{Address: srv.URL}).Ensemble(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "closed", s.RelayOperState)
+	assert.Equal(t, "multimode-ongrid", s.GridMode)
+	assert.Equal(t, 84, s.AggSOC)
+	assert.Equal(t, 3024, s.BackupEnergyWh)
+	require.NotNil(t, s.Generator)
+	assert.True(t, s.Generator.Running())
+	assert.Equal(t, []DryContact{{ID: "NC1", Status: "closed"}, {ID: "NO1", Status: "open"}}, s.DryContacts)
+	require.NotNil(t, s.BackupReserve)
+	assert.Equal(t, 30.0, *s.BackupReserve)
+	assert.Equal(t, "self-consumption", s.StorageMode)
+}
+
+func TestGatewayClient_EnsembleOptionalParts(t *testing.T) {
+	t.Parallel()
+
+	srv := ensembleServer(t, map[string]string{
+		"/ivp/ensemble/status": `{"relay": {"mains_oper_state": "open", "Enchg_grid_mode": "multimode-offgrid"}}`,
+	})
+	s, err := newGatewayClient(&Config{Address: srv.URL}).Ensemble(context.Background())
+	require.NoError(t, err, "absent generator, dry contacts and tariff are not errors")
+	assert.Nil(t, s.Generator)
+	assert.Empty(t, s.DryContacts)
+	assert.Nil(t, s.BackupReserve)
+
+	_, err = newGatewayClient(&Config{Address: ensembleServer(t, nil).URL}).Ensemble(context.Background())
+	assert.Equal(t, ErrClassNotFound, errorClass(err), "no System Controller")
+}
+
+func TestExtractEnsemblePoints(t *testing.T) {
+	t.Parallel()
+
+	reserve := 20.0
+	s := EnsembleStatus{
+		RelayAdminState: "closed", RelayOperState: "open", GridMode: "multimode-offgrid",
+		AggSOC: 60, EnchargeSOC: 61, MaxEnergyWh: 10000, BackupEnergyWh: 2000, AvailEnergyWh: 6000,
+		Generator:     &GeneratorStatus{AdminState: "on", OperState: "off", AdminMode: "manual"},
+		DryContacts:   []DryContact{{ID: "NC1", Status: "closed"}},
+		BackupReserve: &reserve,
+	}
+	pts := extractEnsemblePoints(s, &Schema{Source: "home"}, time.Now())
+	require.Len(t, pts, 2)
+
+	assert.Equal(t, "ensemble", pts[0].Name())
+	fields := fieldMap(pts[0])
+	assert.Equal(t, false, fields["relay_closed"], "islanded")
+	assert.Equal(t, false, fields["on_grid"])
+	assert.Equal(t, "multimode-offgrid", fields["grid_mode"])
+	assert.Equal(t, int64(60), fields["agg_soc"])
+	assert.Equal(t, 20.0, fields["backup_reserve_soc"])
+	assert.Equal(t, false, fields["generator_running"])
+	assert.NotContains(t, fields, "storage_mode")
+
+	assert.Equal(t, "dry-contact", pts[1].Name())
+	assert.Equal(t, "NC1", tagMap(pts[1])[TagContact])
+	assert.Equal(t, true, fieldMap(pts[1])["closed"])
+}
+
+func TestExtractEnsemblePoints_NoRelay(t *testing.T) {
+	t.Parallel()
+
+	srv := ensembleServer(t, map[string]string{
+		"/ivp/ensemble/status": `{"secctrl": {"agg_soc": 84, "ENC_agg_soc": 85}}`,
+	})
+	s, err := newGatewayClient(&Config{Address: srv.URL}).Ensemble(context.Background())
+	require.NoError(t, err)
+
+	pts := extractEnsemblePoints(s, &Schema{Source: "home"}, time.Now())
+	require.Len(t, pts, 1)
+	fields := fieldMap(pts[0])
+	assert.Equal(t, int64(84), fields["agg_soc"])
+	for _, f := range []string{"relay_closed", "relay_admin_state", "relay_oper_state", "grid_mode", "on_grid"} {
+		assert.NotContains(t, fields, f, "no relay section")
+	}
+}
+
+func TestScrape_Ensemble(t *testing.T) {
+	t.Parallel()
+
+	writer := &MockPointWriter{}
+	client := &MockEnvoyClient{
+		EnsembleFunc: func(_ context.Context) (EnsembleStatus, error) {
+			return EnsembleStatus{RelayOperState: "closed", GridMode: "multimode-ongrid"}, nil
+		},
+	}
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	assert.False(t, result.hasErr)
+	assert.Equal(t, 2, result.points, "snapshot + ensemble")
+
+	// The default mock answers 404: no System Controller, no error.
+	result = scrape(context.Background(), &MockEnvoyClient{}, &MockPointWriter{}, &Schema{Source: "test"})
+	assert.False(t, result.hasErr)
+	assert.Equal(t, 1, result.points)
+}
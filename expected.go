@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// defaultDerate is the fraction of the clear-sky DC output that reaches the
+// grid: inverter, wiring, soiling and temperature losses.
+const defaultDerate = 0.85
+
+// groundAlbedo is the fraction of global irradiance reflected by the ground
+// onto tilted panels.
+const groundAlbedo = 0.2
+
+// ExpectedConfig configures the clear-sky production model. It is enabled
+// when arrays are set, and needs the location.
+type ExpectedConfig struct {
+	Arrays map[string]ArrayConfig `yaml:"arrays"` // by array name, as in devices
+	Derate float64                `yaml:"derate"` // fraction of DC nameplate output delivered; default 0.85
+}
+
+// ArrayConfig describes one group of panels sharing an orientation. Unset
+// azimuth and tilt are taken from the devices in the array.
+type ArrayConfig struct {
+	CapacityW float64  `yaml:"capacity_w"` // DC nameplate in W
+	Azimuth   *float64 `yaml:"azimuth"`    // degrees clockwise from north
+	Tilt      *float64 `yaml:"tilt"`       // degrees from horizontal
+}
+
+// validate checks the model settings and that every array's orientation is
+// known.
+func (c ExpectedConfig) validate(loc *Location, devices map[string]DeviceMetadata) error {
+	if c.Derate < 0 || c.Derate > 1 {
+		return fmt.Errorf("expected: derate %v must be between 0 and 1", c.Derate)
+	}
+	if len(c.Arrays) == 0 {
+		return nil
+	}
+	if loc == nil {
+		return fmt.Errorf("expected: arrays require location")
+	}
+	_, err := c.resolveArrays(devices)
+	return err
+}
+
+// arrayModel is an array with its orientation resolved.
+type arrayModel struct {
+	Name      string
+	CapacityW float64
+	Azimuth   float64
+	Tilt      float64
+}
+
+// resolveArrays fills in each array's orientation from the first device,
+// by serial, that is in the array and has it set.
+func (c ExpectedConfig) resolveArrays(devices map[string]DeviceMetadata) ([]arrayModel, error) {
+	var arrays []arrayModel
+	for _, name := range slices.Sorted(maps.Keys(c.Arrays)) {
+		a := c.Arrays[name]
+		if a.CapacityW <= 0 {
+			return nil, fmt.Errorf("expected: array %q: capacity_w must be positive", name)
+		}
+		azimuth, tilt := a.Azimuth, a.Tilt
+		for _, serial := range slices.Sorted(maps.Keys(devices)) {
+			d := devices[serial]
+			if d.Array != name {
+				continue
+			}
+			if azimuth == nil {
+				azimuth = d.Azimuth
+			}
+			if tilt == nil {
+				tilt = d.Tilt
+			}
+		}
+		if azimuth == nil || tilt == nil {
+			return nil, fmt.Errorf("expected: array %q: set azimuth and tilt on the array or its devices", name)
+		}
+		if *tilt < 0 || *tilt > 90 {
+			return nil, fmt.Errorf("expected: array %q: tilt %v must be between 0 and 90", name, *tilt)
+		}
+		arrays = append(arrays, arrayModel{Name: name, CapacityW: a.CapacityW, Azimuth: math.Mod(*azimuth, 360), Tilt: *tilt})
+	}
+	return arrays, nil
+}
+
+// productionModel predicts the output of the arrays under a clear sky.
+type productionModel struct {
+	loc    Location
+	arrays []arrayModel
+	derate float64
+}
+
+// newProductionModel returns the model described by cfg, or nil when no
+// arrays are configured.
+func newProductionModel(cfg ExpectedConfig, loc *Location, devices map[string]DeviceMetadata) (*productionModel, error) {
+	if len(cfg.Arrays) == 0 || loc == nil {
+		return nil, nil
+	}
+	arrays, err := cfg.resolveArrays(devices)
+	if err != nil {
+		return nil, err
+	}
+	derate := cfg.Derate
+	if derate == 0 {
+		derate = defaultDerate
+	}
+	return &productionModel{loc: *loc, arrays: arrays, derate: derate}, nil
+}
+
+// expectedW returns the clear-sky AC output at t.
+func (m *productionModel) expectedW(t time.Time) float64 {
+	sun := sunAt(m.loc, t)
+	dni, dhi := clearSkyIrradiance(sun.Zenith)
+	if dni == 0 && dhi == 0 {
+		return 0
+	}
+	cosZenith := math.Cos(radians(sun.Zenith))
+	ghi := dni*cosZenith + dhi
+	w := 0.0
+	for _, a := range m.arrays {
+		tilt := radians(a.Tilt)
+		cosIncidence := cosZenith*math.Cos(tilt) +
+			math.Sin(radians(sun.Zenith))*math.Sin(tilt)*math.Cos(radians(sun.Azimuth-a.Azimuth))
+		poa := dni*max(0, cosIncidence) +
+			dhi*(1+math.Cos(tilt))/2 +
+			ghi*groundAlbedo*(1-math.Cos(tilt))/2
+		w += a.CapacityW * poa / 1000
+	}
+	return w * m.derate
+}
+
+// clearSkyIrradiance returns the direct normal and diffuse horizontal
+// irradiance in W/m² with the sun at zenith degrees: the Meinel model for
+// direct light through the Kasten-Young air mass, with diffuse light a tenth
+// of it.
+func clearSkyIrradiance(zenith float64) (dni, dhi float64) {
+	if zenith >= 90 {
+		return 0, 0
+	}
+	airMass := 1 / (math.Cos(radians(zenith)) + 0.50572*math.Pow(96.07995-zenith, -1.6364))
+	dni = 1353 * math.Pow(0.7, math.Pow(airMass, 0.678))
+	return dni, 0.1 * dni
+}
+
+// expectedProduction compares solar output with the clear-sky model. Like
+// latest it is process-wide; run configures it.
+var expectedProduction = &performanceTracker{}
+
+// performanceDay is one day's actual and expected production.
+type performanceDay struct {
+	Day        time.Time // midnight in the site's zone
+	ActualWh   float64
+	ExpectedWh float64
+}
+
+// ratio returns actual over expected production, if any was expected.
+func (d performanceDay) ratio() (float64, bool) {
+	if d.ExpectedWh <= 0 {
+		return 0, false
+	}
+	return d.ActualWh / d.ExpectedWh, true
+}
+
+// performanceTracker integrates actual and expected solar output and
+// summarises each day when it ends.
+type performanceTracker struct {
+	mu      sync.Mutex
+	model   *productionModel
+	zone    *time.Location // of the site, for day boundaries
+	day     time.Time
+	lastAt  time.Time
+	lastW   float64 // actual
+	lastExp float64
+	today   performanceDay
+	pending []performanceDay
+}
+
+// configure replaces the model and clears all state. A nil model disables
+// the tracker.
+func (p *performanceTracker) configure(m *productionModel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.model, p.day, p.lastAt, p.lastW, p.lastExp = m, time.Time{}, time.Time{}, 0, 0
+	if m != nil {
+		p.zone = m.loc.zone()
+	}
+	p.today, p.pending = performanceDay{}, nil
+}
+
+// expectedW returns the clear-sky output at t, if a model is configured.
+// A nil tracker has none.
+func (p *performanceTracker) expectedW(t time.Time) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.model == nil {
+		return 0, false
+	}
+	return p.model.expectedW(t), true
+}
+
+// observe integrates solar output w and the expected output at t into
+// today's totals, queueing a summary of the previous day after midnight at
+// the site.
+func (p *performanceTracker) observe(w float64, t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.model == nil {
+		return
+	}
+	day := startOfDay(t, p.zone)
+	if !p.day.IsZero() && day.After(p.day) {
+		p.pending = append(p.pending, p.today)
+		p.today = performanceDay{}
+	}
+	if p.day.IsZero() || day.After(p.day) {
+		p.day, p.today.Day = day, day
+	}
+	exp := p.model.expectedW(t)
+	if dt := t.Sub(p.lastAt); !p.lastAt.IsZero() && dt > 0 && dt <= maxIntegrationGap {
+		p.today.ActualWh += (p.lastW + w) / 2 * dt.Hours()
+		p.today.ExpectedWh += (p.lastExp + exp) / 2 * dt.Hours()
+	}
+	p.lastAt, p.lastW, p.lastExp = t, w, exp
+}
+
+// takeDays returns the days completed since the last call.
+func (p *performanceTracker) takeDays() []performanceDay {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	days := p.pending
+	p.pending = nil
+	return days
+}
+
+// extractPerformancePoints builds one performance-daily point per completed
+// day, timestamped at the start of that day.
+func extractPerformancePoints(days []performanceDay, schema *Schema) []*influxdb2write.Point {
+	var ps []*influxdb2write.Point
+	for _, d := range days {
+		pt := schema.newPoint(pointKey{Family: FamilyPerformanceDaily, Legacy: "performance-daily"}, d.Day).
+			AddField("actual_wh", d.ActualWh).
+			AddField("expected_wh", d.ExpectedWh)
+		if r, ok := d.ratio(); ok {
+			pt.AddField("performance_ratio", r)
+		}
+		ps = append(ps, schema.renameFields(FamilyPerformanceDaily, pt))
+	}
+	return ps
+}
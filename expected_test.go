@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpectedConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	loc := &Location{Latitude: 40}
+	south := ArrayConfig{CapacityW: 4000, Azimuth: ptr(180.0), Tilt: ptr(30.0)}
+	assert.NoError(t, ExpectedConfig{}.validate(nil, nil))
+	assert.NoError(t, ExpectedConfig{Arrays: map[string]ArrayConfig{"south": south}}.validate(loc, nil))
+	// Orientation from the array's devices.
+	assert.NoError(t, ExpectedConfig{Arrays: map[string]ArrayConfig{"east": {CapacityW: 2000}}}.validate(loc,
+		map[string]DeviceMetadata{"1": {Array: "east", Azimuth: ptr(90.0)}, "2": {Array: "east", Tilt: ptr(20.0)}}))
+
+	for name, c := range map[string]struct {
+		cfg     ExpectedConfig
+		loc     *Location
+		devices map[string]DeviceMetadata
+	}{
+		"derate":      {cfg: ExpectedConfig{Derate: 1.5}},
+		"no location": {cfg: ExpectedConfig{Arrays: map[string]ArrayConfig{"south": south}}},
+		"capacity":    {cfg: ExpectedConfig{Arrays: map[string]ArrayConfig{"south": {Azimuth: ptr(180.0), Tilt: ptr(30.0)}}}, loc: loc},
+		"orientation": {cfg: ExpectedConfig{Arrays: map[string]ArrayConfig{"east": {CapacityW: 2000}}}, loc: loc,
+			devices: map[string]DeviceMetadata{"1": {Array: "west", Azimuth: ptr(270.0), Tilt: ptr(20.0)}}},
+		"tilt": {cfg: ExpectedConfig{Arrays: map[string]ArrayConfig{"south": {CapacityW: 4000, Azimuth: ptr(180.0), Tilt: ptr(95.0)}}}, loc: loc},
+	} {
+		assert.Error(t, c.cfg.validate(c.loc, c.devices), name)
+	}
+}
+
+func TestProductionModel(t *testing.T) {
+	t.Parallel()
+
+	m, err := newProductionModel(ExpectedConfig{Arrays: map[string]ArrayConfig{
+		"south": {CapacityW: 4000, Azimuth: ptr(180.0), Tilt: ptr(30.0)},
+	}}, &Location{Latitude: 40}, nil)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, defaultDerate, m.derate)
+
+	noon := time.Date(2026, 6, 21, 12, 2, 0, 0, time.UTC)
+	peak := m.expectedW(noon)
+	assert.InDelta(t, 3200, peak, 400, "about the derated nameplate at noon")
+	assert.Less(t, m.expectedW(noon.Add(-4*time.Hour)), peak)
+	assert.Zero(t, m.expectedW(noon.Add(12*time.Hour)), "night")
+
+	none, err := newProductionModel(ExpectedConfig{}, &Location{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestClearSkyIrradiance(t *testing.T) {
+	t.Parallel()
+
+	dni, dhi := clearSkyIrradiance(0)
+	assert.InDelta(t, 947, dni, 1, "0.7 of the solar constant at air mass 1")
+	assert.InDelta(t, dni/10, dhi, 1e-9)
+	low, _ := clearSkyIrradiance(80)
+	assert.Less(t, low, dni)
+	dni, dhi = clearSkyIrradiance(95)
+	assert.Zero(t, dni+dhi)
+}
+
+func TestPerformanceTracker(t *testing.T) {
+	t.Parallel()
+
+	var nilTracker *performanceTracker
+	_, ok := nilTracker.expectedW(time.Now())
+	assert.False(t, ok)
+
+	m, err := newProductionModel(ExpectedConfig{Arrays: map[string]ArrayConfig{
+		"south": {CapacityW: 4000, Azimuth: ptr(180.0), Tilt: ptr(30.0)},
+	}}, &Location{Latitude: 40, Longitude: -105, Timezone: "America/Denver"}, nil)
+	require.NoError(t, err)
+	p := &performanceTracker{}
+	p.observe(1000, time.Now())
+	assert.Empty(t, p.takeDays(), "disabled without a model")
+
+	p.configure(m)
+	denver, err := time.LoadLocation("America/Denver")
+	require.NoError(t, err)
+	day := time.Date(2026, 6, 21, 0, 0, 0, 0, denver) // days split at the site's midnight
+	// Produce half the expected output every minute of the day.
+	for at := day; at.Before(day.Add(24 * time.Hour)); at = at.Add(time.Minute) {
+		exp, ok := p.expectedW(at)
+		require.True(t, ok)
+		p.observe(exp/2, at)
+	}
+	assert.Empty(t, p.takeDays())
+	p.observe(0, day.Add(24*time.Hour))
+	days := p.takeDays()
+	require.Len(t, days, 1)
+	assert.Equal(t, day, days[0].Day)
+	assert.Greater(t, days[0].ExpectedWh, 10000.0)
+	r, ok := days[0].ratio()
+	require.True(t, ok)
+	assert.InDelta(t, 0.5, r, 0.001)
+	assert.Empty(t, p.takeDays(), "summaries are reported once")
+}
+
+func TestExtractPerformancePoints(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2026, 6, 21, 0, 0, 0, 0, time.Local)
+	pts := extractPerformancePoints([]performanceDay{
+		{Day: day, ActualWh: 18000, ExpectedWh: 24000},
+		{Day: day.AddDate(0, 0, 1)},
+	}, &Schema{Source: "home"})
+	require.Len(t, pts, 2)
+	assert.Equal(t, "performance-daily", pts[0].Name())
+	assert.Equal(t, day, pts[0].Time())
+	assert.Equal(t, map[string]any{"actual_wh": 18000.0, "expected_wh": 24000.0, "performance_ratio": 0.75}, fieldMap(pts[0]))
+	assert.NotContains(t, fieldMap(pts[1]), "performance_ratio", "nothing expected")
+}
+
+func TestExtractLiveDataPoints_Expected(t *testing.T) {
+	t.Parallel()
+
+	m, err := newProductionModel(ExpectedConfig{Arrays: map[string]ArrayConfig{
+		"south": {CapacityW: 4000, Azimuth: ptr(180.0), Tilt: ptr(30.0)},
+	}}, &Location{Latitude: 40}, nil)
+	require.NoError(t, err)
+	p := &performanceTracker{}
+	p.configure(m)
+	noon := time.Date(2026, 6, 21, 12, 2, 0, 0, time.UTC)
+	pts := extractLiveDataPoints(makeLiveData(3000000, 0, 0, 0), &Schema{Source: "home"}, p, noon)
+	require.Len(t, pts, 1)
+	assert.InDelta(t, m.expectedW(noon), fieldMap(pts[0])["expected_w"], 1e-9)
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Firmware compatibility levels; see firmwareMatrix.
+const (
+	FirmwareTested      = "tested"
+	FirmwareUntested    = "untested"
+	FirmwareUnsupported = "unsupported"
+)
+
+// EventFirmwareChange is the event tag of a firmware change point.
+const EventFirmwareChange = "firmware_change"
+
+// infoCheckInterval is how often scrape re-reads /info to notice firmware
+// updates, which the gateway installs on its own schedule.
+const infoCheckInterval = 15 * time.Minute
+
+// firmwareMatrix lists the gateway firmware series the local APIs used by
+// the exporter have been tested with, most specific prefix first. Untested
+// firmware is still scraped, with a warning: Enphase updates have broken
+// local endpoints before.
+var firmwareMatrix = []struct {
+	Prefix string
+	Status string
+	Note   string
+}{
+	{"D8.", FirmwareTested, "token-authenticated local API"},
+	{"D7.", FirmwareTested, "token-authenticated local API (D7.4.22 is the version documented by Enphase)"},
+	{"D5.", FirmwareUnsupported, "pre-token firmware with a different local API"},
+	{"R", FirmwareUnsupported, "legacy Envoy-R firmware"},
+}
+
+// firmwareCompatibility looks up software in firmwareMatrix.
+func firmwareCompatibility(software string) (status, note string) {
+	for _, m := range firmwareMatrix {
+		if strings.HasPrefix(software, m.Prefix) {
+			return m.Status, m.Note
+		}
+	}
+	return FirmwareUntested, "not in the compatibility matrix"
+}
+
+var (
+	promGatewayInfo = promFactory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "envoy_exporter",
+		Name:      "gateway_info",
+		Help:      "Gateway identification and firmware; always 1.",
+	}, []string{"serial", "part_number", "software", "build_id", "compatibility"})
+	promGatewayBuildTime = promFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "envoy_exporter",
+		Name:      "gateway_build_time_seconds",
+		Help:      "Build time of the gateway firmware as a Unix timestamp.",
+	})
+	promFirmwareChanges = promFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "gateway_firmware_changes_total",
+		Help:      "Gateway firmware changes seen while running.",
+	})
+)
+
+// GatewayInfo identifies the gateway hardware and firmware.
+type GatewayInfo struct {
+	Serial        string    `json:"serial"`
+	PartNumber    string    `json:"part_number"`
+	Software      string    `json:"software"`
+	BuildID       string    `json:"build_id,omitempty"`
+	BuildTime     time.Time `json:"build_time,omitzero"`
+	Metered       bool      `json:"metered"`
+	Compatibility string    `json:"compatibility"`
+}
+
+// envoyInfo is the /info XML document.
+type envoyInfo struct {
+	Device struct {
+		SerialNumber string `xml:"sn"`
+		PartNumber   string `xml:"pn"`
+		Software     string `xml:"software"`
+		IsMeter      bool   `xml:"imeter"`
+	} `xml:"device"`
+	BuildInfo struct {
+		BuildID      string `xml:"build_id"`
+		BuildTimeGMT int64  `xml:"build_time_gmt"`
+	} `xml:"build_info"`
+}
+
+// Info returns the gateway's identification (GET /info). Unlike the library's
+// SystemInfo it includes the firmware build. The endpoint needs no JWT and
+// answers in XML.
+func (c *gatewayClient) Info(ctx context.Context) (GatewayInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/info", nil)
+	if err != nil {
+		return GatewayInfo{}, fmt.Errorf("build request /info: %w", err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return GatewayInfo{}, fmt.Errorf("request /info: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return GatewayInfo{}, &gateway.Error{StatusCode: resp.StatusCode, Endpoint: "/info"}
+	}
+
+	var doc envoyInfo
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxGatewayResponse)).Decode(&doc); err != nil {
+		return GatewayInfo{}, fmt.Errorf("decode /info: %w", err)
+	}
+	info := GatewayInfo{
+		Serial:     doc.Device.SerialNumber,
+		PartNumber: doc.Device.PartNumber,
+		Software:   doc.Device.Software,
+		BuildID:    doc.BuildInfo.BuildID,
+		Metered:    doc.Device.IsMeter,
+	}
+	if doc.BuildInfo.BuildTimeGMT > 0 {
+		info.BuildTime = time.Unix(doc.BuildInfo.BuildTimeGMT, 0).UTC()
+	}
+	info.Compatibility, _ = firmwareCompatibility(info.Software)
+	return info, nil
+}
+
+// gatewayFirmware tracks the gateway's firmware. Like components it is
+// process-wide: both the client factory and scrape report to it.
+var gatewayFirmware = newFirmwareTracker()
+
+// firmwareChange is a firmware update seen between two /info readings.
+type firmwareChange struct {
+	At       time.Time
+	Serial   string
+	From, To string
+}
+
+// firmwareTracker remembers the last /info reading, notices firmware changes
+// and queues them until scrape writes them as events.
+type firmwareTracker struct {
+	mu        sync.Mutex
+	info      GatewayInfo
+	checkedAt time.Time // of the last /info request, whether it worked or not
+	unwritten bool      // info has not been written as a point yet
+	pending   []firmwareChange
+}
+
+func newFirmwareTracker() *firmwareTracker {
+	return &firmwareTracker{}
+}
+
+// due reports whether /info should be read again.
+func (f *firmwareTracker) due(now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.checkedAt.IsZero() || now.Sub(f.checkedAt) >= infoCheckInterval
+}
+
+// checked records an /info request at now. It is recorded whatever the
+// outcome, so a gateway without the endpoint, or one failing to answer, is
+// asked again at the check interval rather than on every scrape.
+func (f *firmwareTracker) checked(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checkedAt = now
+}
+
+// observe records an /info reading: it updates the gateway_info metric and
+// /status, warns about firmware outside the compatibility matrix, and warns
+// and queues an event when the firmware differs from the previous reading.
+// It reports whether the firmware changed.
+func (f *firmwareTracker) observe(info GatewayInfo, now time.Time) bool {
+	f.mu.Lock()
+	prev := f.info
+	f.info = info
+	f.checkedAt = now
+	f.unwritten = true
+	changed := prev.Software != "" && prev.Software != info.Software
+	if changed {
+		f.pending = append(f.pending, firmwareChange{At: now, Serial: info.Serial, From: prev.Software, To: info.Software})
+	}
+	f.mu.Unlock()
+
+	promGatewayInfo.Reset()
+	promGatewayInfo.WithLabelValues(info.Serial, info.PartNumber, info.Software, info.BuildID, info.Compatibility).Set(1)
+	if !info.BuildTime.IsZero() {
+		promGatewayBuildTime.Set(float64(info.BuildTime.Unix()))
+	}
+	components.setGatewayInfo(info)
+
+	if changed {
+		promFirmwareChanges.Inc()
+		slog.Warn("Gateway firmware changed; local API behaviour may differ",
+			"serial", info.Serial, "from", prev.Software, "to", info.Software, "compatibility", info.Compatibility)
+	}
+	if prev.Software != info.Software {
+		status, note := firmwareCompatibility(info.Software)
+		if status == FirmwareTested {
+			slog.Info("Gateway firmware", "software", info.Software, "part_number", info.PartNumber, "compatibility", status)
+		} else {
+			slog.Warn("Gateway firmware is not known to work with this exporter",
+				"software", info.Software, "compatibility", status, "note", note)
+		}
+	}
+	return changed
+}
+
+// takeInfo returns the last reading if it has not been written as a point
+// yet, so a reading taken when connecting is written by the next scrape.
+func (f *firmwareTracker) takeInfo() (GatewayInfo, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.unwritten {
+		return GatewayInfo{}, false
+	}
+	f.unwritten = false
+	return f.info, true
+}
+
+// takeEvents returns and clears the queued firmware changes.
+func (f *firmwareTracker) takeEvents() []firmwareChange {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ev := f.pending
+	f.pending = nil
+	return ev
+}
+
+// extractGatewayInfoPoint builds the gateway info point: identification and
+// firmware as tags, build time and metering as fields.
+func extractGatewayInfoPoint(info GatewayInfo, schema *Schema, t time.Time) *influxdb2write.Point {
+	pt := schema.newPoint(pointKey{Family: FamilyGateway, Legacy: "gateway-info"}, t).
+		AddTag(TagSerial, info.Serial).
+		AddTag(TagPartNumber, info.PartNumber).
+		AddTag(TagSoftware, info.Software).
+		AddTag(TagCompatibility, info.Compatibility).
+		AddField("metered", info.Metered)
+	if !info.BuildTime.IsZero() {
+		pt.AddField("build_time", info.BuildTime.Unix())
+	}
+	return schema.renameFields(FamilyGateway, pt)
+}
+
+// extractFirmwareEventPoints builds one event point per firmware change, at
+// the time the change was seen, for use as dashboard annotations.
+func extractFirmwareEventPoints(changes []firmwareChange, schema *Schema) []*influxdb2write.Point {
+	ps := make([]*influxdb2write.Point, len(changes))
+	for i, c := range changes {
+		pt := schema.newPoint(pointKey{Family: FamilyEvent, Legacy: "gateway-event"}, c.At).
+			AddTag(TagEvent, EventFirmwareChange).
+			AddTag(TagSerial, c.Serial).
+			AddField("from", c.From).
+			AddField("to", c.To).
+			AddField("message", "gateway firmware changed from "+c.From+" to "+c.To)
+		ps[i] = schema.renameFields(FamilyEvent, pt)
+	}
+	return ps
+}
+
+// checkGatewayInfo reads /info at connect time and records it. Failures are
+// logged only: /info is informational.
+func checkGatewayInfo(ctx context.Context, c EnvoyClient) {
+	spanCtx, span := startSpan(ctx, "gateway."+EndpointInfo)
+	info, err := c.Info(spanCtx)
+	endSpan(span, err)
+	gatewayFirmware.checked(time.Now())
+	if err != nil {
+		slog.Warn("Gateway info check failed", "endpoint", "/info", "error", err)
+		return
+	}
+	gatewayFirmware.observe(info, time.Now())
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const infoXML = `<?xml version='1.0' encoding='UTF-8'?>
+<envoy_info>
+  <time>1658403712</time>
+  <device>
+    <sn>122125067699</sn>
+    <pn>800-00654-r06</pn>
+    <software>D7.4.22</software>
+    <imeter>true</imeter>
+  </device>
+  <web-tokens>true</web-tokens>
+  <build_info>
+    <build_id>ec2-user-envoy_uber-pkg_master:pkg-Jul-18-22-08:54:12</build_id>
+    <build_time_gmt>1658134579</build_time_gmt>
+  </build_info>
+</envoy_info>`
+
+func TestGatewayClient_Info(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/info", r.URL.Path)
+		assert.Empty(t, r.Header.Get("Authorization"), "/info needs no JWT")
+		_, _ = w.Write([]byte(infoXML))
+	}))
+	defer srv.Close()
+
+	info, err := newGatewayClient(&Config{Address: srv.URL, JWT: "tok"}).Info(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, GatewayInfo{
+		Serial:        "122125067699",
+		PartNumber:    "800-00654-r06",
+		Software:      "D7.4.22",
+		BuildID:       "ec2-user-envoy_uber-pkg_master:pkg-Jul-18-22-08:54:12",
+		BuildTime:     time.Unix(1658134579, 0).UTC(),
+		Metered:       true,
+		Compatibility: FirmwareTested,
+	}, info)
+}
+
+func TestFirmwareCompatibility(t *testing.T) {
+	t.Parallel()
+
+	for software, want := range map[string]string{
+		"D7.4.22":   FirmwareTested,
+		"D8.2.4345": FirmwareTested,
+		"D5.0.55":   FirmwareUnsupported,
+		"R4.10.35":  FirmwareUnsupported,
+		"D9.0.1":    FirmwareUntested,
+		"":          FirmwareUntested,
+	} {
+		status, note := firmwareCompatibility(software)
+		assert.Equal(t, want, status, software)
+		assert.NotEmpty(t, note, software)
+	}
+}
+
+func TestFirmwareTracker(t *testing.T) {
+	t.Parallel()
+
+	f := newFirmwareTracker()
+	now := time.Now()
+	assert.True(t, f.due(now), "never checked")
+
+	assert.False(t, f.observe(GatewayInfo{Serial: "GW", Software: "D7.6.175"}, now), "first reading is not a change")
+	assert.False(t, f.due(now.Add(time.Minute)))
+	assert.True(t, f.due(now.Add(infoCheckInterval)))
+	assert.False(t, f.observe(GatewayInfo{Serial: "GW", Software: "D7.6.175"}, now.Add(time.Minute)))
+	assert.Empty(t, f.takeEvents())
+
+	later := now.Add(time.Hour)
+	assert.True(t, f.observe(GatewayInfo{Serial: "GW", Software: "D8.2.4345"}, later))
+	assert.Equal(t, []firmwareChange{{At: later, Serial: "GW", From: "D7.6.175", To: "D8.2.4345"}}, f.takeEvents())
+	assert.Empty(t, f.takeEvents(), "events are taken once")
+}
+
+func TestExtractGatewayInfoPoints(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	info := GatewayInfo{Serial: "GW", PartNumber: "800-00654-r06", Software: "D7.4.22", BuildTime: time.Unix(1658134579, 0), Metered: true, Compatibility: FirmwareTested}
+	pt := extractGatewayInfoPoint(info, &Schema{Source: "home"}, now)
+	assert.Equal(t, "gateway-info", pt.Name())
+	tags := tagMap(pt)
+	assert.Equal(t, "GW", tags[TagSerial])
+	assert.Equal(t, "800-00654-r06", tags[TagPartNumber])
+	assert.Equal(t, "D7.4.22", tags[TagSoftware])
+	assert.Equal(t, FirmwareTested, tags[TagCompatibility])
+	assert.Equal(t, int64(1658134579), fieldMap(pt)["build_time"])
+	assert.Equal(t, true, fieldMap(pt)["metered"])
+
+	pts := extractFirmwareEventPoints([]firmwareChange{{At: now, Serial: "GW", From: "D7.4.22", To: "D8.2.4345"}}, &Schema{Source: "home"})
+	require.Len(t, pts, 1)
+	assert.Equal(t, "gateway-event", pts[0].Name())
+	assert.Equal(t, now, pts[0].Time())
+	assert.Equal(t, EventFirmwareChange, tagMap(pts[0])[TagEvent])
+	assert.Equal(t, "D7.4.22", fieldMap(pts[0])["from"])
+	assert.Equal(t, "D8.2.4345", fieldMap(pts[0])["to"])
+}
+
+// TestScrape_FirmwareChange swaps the process-wide firmware tracker, so it
+// must not run in parallel.
+func TestScrape_FirmwareChange(t *testing.T) {
+	saved := gatewayFirmware
+	gatewayFirmware = newFirmwareTracker()
+	defer func() { gatewayFirmware = saved }()
+
+	software := "D7.6.175"
+	client := &MockEnvoyClient{
+		InfoFunc: func(_ context.Context) (GatewayInfo, error) {
+			return GatewayInfo{Serial: "GW", Software: software}, nil
+		},
+	}
+	checkGatewayInfo(context.Background(), client) // connect-time check
+
+	writer := &MockPointWriter{}
+	scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	require.Len(t, writer.Written, 2, "the connect-time reading is written by the first scrape")
+	assert.Equal(t, "gateway-info", writer.Written[1].Name())
+
+	writer = &MockPointWriter{}
+	scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	assert.Len(t, writer.Written, 1, "info not due again; snapshot only")
+
+	// The gateway updates itself; the next due check notices.
+	software = "D8.2.4345"
+	gatewayFirmware.checkedAt = time.Time{}
+	writer = &MockPointWriter{}
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	assert.False(t, result.hasErr)
+	require.Len(t, writer.Written, 3)
+	assert.Equal(t, "gateway-info", writer.Written[1].Name())
+	assert.Equal(t, "gateway-event", writer.Written[2].Name())
+	assert.Equal(t, "D8.2.4345", fieldMap(writer.Written[2])["to"])
+}
+
+// TestScrape_InfoFailureNotRetriedEveryScrape swaps the process-wide
+// firmware tracker, so it must not run in parallel.
+func TestScrape_InfoFailureNotRetriedEveryScrape(t *testing.T) {
+	saved := gatewayFirmware
+	gatewayFirmware = newFirmwareTracker()
+	defer func() { gatewayFirmware = saved }()
+
+	calls := 0
+	client := &MockEnvoyClient{
+		InfoFunc: func(_ context.Context) (GatewayInfo, error) {
+			calls++
+			return GatewayInfo{}, &gateway.Error{StatusCode: http.StatusNotFound, Endpoint: "/info"}
+		},
+	}
+	checkGatewayInfo(context.Background(), client)
+	scrape(context.Background(), client, &MockPointWriter{}, &Schema{Source: "test"})
+	scrape(context.Background(), client, &MockPointWriter{}, &Schema{Source: "test"})
+	assert.Equal(t, 1, calls, "a failed check waits for the check interval")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+)
+
+// InfluxOptions configures an InfluxWriter. Which fields apply depends on
+// Version:
+//
+//	1: Database, optional RetentionPolicy, optional Username/Password
+//	2: Org, Bucket, Token
+//	3: Database, optional Token
+type InfluxOptions struct {
+	URL     string
+	Version int
+
+	Token  string
+	Org    string
+	Bucket string
+
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+
+	Gzip    bool
+	Timeout time.Duration // per request; 0 relies on the context deadline
+}
+
+// InfluxWriter writes points as line protocol over the InfluxDB 1.x, 2.x or
+// 3.x HTTP write API.
+type InfluxWriter struct {
+	client   *http.Client
+	writeURL string
+	opts     InfluxOptions
+}
+
+// InfluxError is a write rejected by the server.
+type InfluxError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *InfluxError) Error() string {
+	return fmt.Sprintf("influxdb write: HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// errNoEncodablePoints is returned when none of the points in a write can
+// be encoded as line protocol. Retrying will not help.
+var errNoEncodablePoints = errors.New("no points could be encoded")
+
+// NewInfluxWriter builds the write URL for opts.Version.
+func NewInfluxWriter(opts InfluxOptions) (*InfluxWriter, error) {
+	base, err := url.Parse(strings.TrimRight(opts.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse influxdb url: %w", err)
+	}
+	q := url.Values{}
+	switch opts.Version {
+	case 1:
+		base.Path += "/write"
+		q.Set("db", opts.Database)
+		if opts.RetentionPolicy != "" {
+			q.Set("rp", opts.RetentionPolicy)
+		}
+		q.Set("precision", "ns")
+	case 2:
+		base.Path += "/api/v2/write"
+		q.Set("org", opts.Org)
+		q.Set("bucket", opts.Bucket)
+		q.Set("precision", "ns")
+	case 3:
+		base.Path += "/api/v3/write_lp"
+		q.Set("db", opts.Database)
+		q.Set("precision", "nanosecond")
+	default:
+		return nil, fmt.Errorf("unsupported influxdb version %d", opts.Version)
+	}
+	base.RawQuery = q.Encode()
+	return &InfluxWriter{
+		client:   &http.Client{Timeout: opts.Timeout},
+		writeURL: base.String(),
+		opts:     opts,
+	}, nil
+}
+
+// WritePoint sends all points in one request.
+func (w *InfluxWriter) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	if len(point) == 0 {
+		return nil
+	}
+	body, err := encodeLineProtocol(point, w.opts.Gzip)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.writeURL, body)
+	if err != nil {
+		return fmt.Errorf("build influxdb request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.opts.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	switch {
+	case w.opts.Version == 1 && w.opts.Username != "":
+		req.SetBasicAuth(w.opts.Username, w.opts.Password)
+	case w.opts.Version == 2:
+		req.Header.Set("Authorization", "Token "+w.opts.Token)
+	case w.opts.Version == 3 && w.opts.Token != "":
+		req.Header.Set("Authorization", "Bearer "+w.opts.Token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("influxdb write: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &InfluxError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+// encodeLineProtocol encodes points with nanosecond timestamps, optionally
+// gzip-compressed. Empty tag values are omitted, as the official client does.
+// Points that cannot be encoded, such as those with NaN fields, are logged
+// and left out; if none can be, errNoEncodablePoints is returned.
+func encodeLineProtocol(points []*influxdb2write.Point, gz bool) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(&buf)
+		out = zw
+	}
+	// Each point is encoded on its own first, as a failing field can leave
+	// a partial line behind.
+	var line bytes.Buffer
+	enc := lp.NewEncoder(&line)
+	enc.SetFieldTypeSupport(lp.UintSupport)
+	enc.FailOnFieldErr(true)
+	enc.SetPrecision(time.Nanosecond)
+	encoded := 0
+	var lastErr error
+	for _, p := range points {
+		line.Reset()
+		if _, err := enc.Encode(p); err != nil {
+			lastErr = fmt.Errorf("encode point %s: %w", p.Name(), err)
+			slog.Warn("Dropping point that cannot be written", "measurement", p.Name(), "error", err)
+			continue
+		}
+		if _, err := out.Write(line.Bytes()); err != nil {
+			return nil, fmt.Errorf("write line protocol: %w", err)
+		}
+		encoded++
+	}
+	if encoded == 0 {
+		return nil, fmt.Errorf("%w: %w", errNoEncodablePoints, lastErr)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("gzip line protocol: %w", err)
+		}
+	}
+	return &buf, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// influxRequest is what a fake InfluxDB server received.
+type influxRequest struct {
+	path, query, auth, encoding string
+	user, pass                  string
+	body                        string
+}
+
+// fakeInflux records one request and answers with status and message.
+func fakeInflux(t *testing.T, status int, message string) (*httptest.Server, <-chan influxRequest) {
+	t.Helper()
+	got := make(chan influxRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = gz
+		}
+		raw, err := io.ReadAll(body)
+		require.NoError(t, err)
+		user, pass, _ := r.BasicAuth()
+		got <- influxRequest{
+			path: r.URL.Path, query: r.URL.RawQuery, auth: r.Header.Get("Authorization"),
+			encoding: r.Header.Get("Content-Encoding"), user: user, pass: pass, body: string(raw),
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(message))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func testInfluxPoint() *influxdb2write.Point {
+	return influxdb2.NewPointWithMeasurement("energy-snapshot").
+		AddTag(TagSource, "roof").
+		AddField("solar_w", 4200.5).
+		SetTime(time.Unix(1700000000, 0))
+}
+
+func TestInfluxWriter_Versions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		opts      InfluxOptions
+		wantPath  string
+		wantQuery string
+		wantAuth  string
+		wantUser  string
+	}{
+		{
+			name:      "v1 with credentials",
+			opts:      InfluxOptions{Version: 1, Database: "solar", RetentionPolicy: "autogen", Username: "u", Password: "p"},
+			wantPath:  "/write",
+			wantQuery: "db=solar&precision=ns&rp=autogen",
+			wantAuth:  "Basic dTpw",
+			wantUser:  "u",
+		},
+		{
+			name:      "v2",
+			opts:      InfluxOptions{Version: 2, Org: "home", Bucket: "envoy", Token: "tok"},
+			wantPath:  "/api/v2/write",
+			wantQuery: "bucket=envoy&org=home&precision=ns",
+			wantAuth:  "Token tok",
+		},
+		{
+			name:      "v3",
+			opts:      InfluxOptions{Version: 3, Database: "solar", Token: "tok", Gzip: true},
+			wantPath:  "/api/v3/write_lp",
+			wantQuery: "db=solar&precision=nanosecond",
+			wantAuth:  "Bearer tok",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv, got := fakeInflux(t, http.StatusNoContent, "")
+			tt.opts.URL = srv.URL + "/"
+			w, err := NewInfluxWriter(tt.opts)
+			require.NoError(t, err)
+
+			require.NoError(t, w.WritePoint(context.Background(), testInfluxPoint()))
+			req := <-got
+			assert.Equal(t, tt.wantPath, req.path)
+			assert.Equal(t, tt.wantQuery, req.query)
+			assert.Equal(t, tt.wantAuth, req.auth)
+			assert.Equal(t, tt.wantUser, req.user)
+			assert.Equal(t, "energy-snapshot,source=roof solar_w=4200.5 1700000000000000000\n", req.body)
+			if tt.opts.Gzip {
+				assert.Equal(t, "gzip", req.encoding)
+			} else {
+				assert.Empty(t, req.encoding)
+			}
+		})
+	}
+}
+
+func TestInfluxWriter_Error(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := fakeInflux(t, http.StatusBadRequest, `{"error":"partial write: field type conflict"}`)
+	w, err := NewInfluxWriter(InfluxOptions{URL: srv.URL, Version: 2, Org: "o", Bucket: "b", Token: "t"})
+	require.NoError(t, err)
+
+	err = w.WritePoint(context.Background(), testInfluxPoint())
+	var ie *InfluxError
+	require.ErrorAs(t, err, &ie)
+	assert.Equal(t, http.StatusBadRequest, ie.StatusCode)
+	assert.Contains(t, ie.Message, "field type conflict")
+}
+
+func TestInfluxWriter_SkipsUnencodablePoints(t *testing.T) {
+	t.Parallel()
+
+	srv, got := fakeInflux(t, http.StatusNoContent, "")
+	w, err := NewInfluxWriter(InfluxOptions{URL: srv.URL, Version: 3, Database: "solar"})
+	require.NoError(t, err)
+
+	bad := influxdb2.NewPointWithMeasurement("m").AddField("a", 1.0).AddField("b", math.NaN()).SetTime(time.Unix(1, 0))
+	good := influxdb2.NewPointWithMeasurement("m").AddField("a", 2.0).SetTime(time.Unix(2, 0))
+	require.NoError(t, w.WritePoint(context.Background(), bad, good))
+	assert.Equal(t, "m a=2 2000000000\n", (<-got).body, "no partial line for the bad point")
+
+	err = w.WritePoint(context.Background(), bad)
+	require.ErrorIs(t, err, errNoEncodablePoints)
+	assert.False(t, retryableWriteError(err))
+}
+
+func TestInfluxWriter_Timeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	w, err := NewInfluxWriter(InfluxOptions{URL: srv.URL, Version: 3, Database: "solar", Timeout: 20 * time.Millisecond})
+	require.NoError(t, err)
+
+	err = w.WritePoint(context.Background(), testInfluxPoint())
+	require.Error(t, err, "a stalled server does not hang the write")
+	assert.True(t, retryableWriteError(err))
+}
+
+func TestInfluxWriter_EmptyTagOmitted(t *testing.T) {
+	t.Parallel()
+
+	srv, got := fakeInflux(t, http.StatusNoContent, "")
+	w, err := NewInfluxWriter(InfluxOptions{URL: srv.URL, Version: 3, Database: "solar"})
+	require.NoError(t, err)
+
+	pt := influxdb2.NewPointWithMeasurement("m").AddTag(TagSource, "").AddField("v", 1.0).SetTime(time.Unix(1, 0))
+	require.NoError(t, w.WritePoint(context.Background(), pt))
+	req := <-got
+	assert.Equal(t, "m v=1 1000000000\n", req.body)
+	assert.Empty(t, req.auth, "no token configured")
+}
+
+func TestNewInfluxWriter_BadVersion(t *testing.T) {
+	t.Parallel()
+	_, err := NewInfluxWriter(InfluxOptions{URL: "http://localhost:8086", Version: 4})
+	assert.Error(t, err)
+}
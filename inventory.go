@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// statusOK is the device status flag of a healthy device.
+const statusOK = "envoy.global.ok"
+
+// InventoryDevice is the provisioning and health state of one device from
+// the gateway's inventory.
+type InventoryDevice struct {
+	Type          string   `json:"-"` // device class, lower case: pcu, acb, nsrb, ...
+	Serial        string   `json:"serial_num"`
+	Firmware      string   `json:"img_pnum_running"`
+	Producing     bool     `json:"producing"`
+	Communicating bool     `json:"communicating"`
+	Provisioned   bool     `json:"provisioned"`
+	Operating     bool     `json:"operating"`
+	Status        []string `json:"device_status"`
+}
+
+// healthy reports whether every status flag is the global OK flag.
+func (d InventoryDevice) healthy() bool {
+	for _, s := range d.Status {
+		if s != statusOK {
+			return false
+		}
+	}
+	return true
+}
+
+// Inventory returns every device in the gateway's inventory
+// (GET /inventory.json), grouped by class in the response.
+func (c *gatewayClient) Inventory(ctx context.Context) ([]InventoryDevice, error) {
+	var groups []struct {
+		Type    string            `json:"type"`
+		Devices []InventoryDevice `json:"devices"`
+	}
+	if err := c.getJSON(ctx, "/inventory.json", &groups); err != nil {
+		return nil, err
+	}
+	var out []InventoryDevice
+	for _, g := range groups {
+		for _, d := range g.Devices {
+			d.Type = strings.ToLower(g.Type)
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// extractInventoryPoints builds one status point per inventoried device,
+// tagged with its class and running firmware. status lists the device's
+// status flags; status_ok is false if any flag other than the global OK flag
+// is raised. The inventory has no firmware-update-pending flag: it only
+// reports the image running, so updates show up as firmware changes.
+func extractInventoryPoints(devices []InventoryDevice, schema *Schema, t time.Time) []*influxdb2write.Point {
+	ps := make([]*influxdb2write.Point, len(devices))
+	for i, d := range devices {
+		k := pointKey{Family: FamilyDevice, Type: d.Type, Serial: d.Serial, Legacy: "device"}
+		pt := schema.newPoint(k, t).
+			AddTag(TagDeviceType, d.Type).
+			AddTag(TagSerial, d.Serial).
+			AddTag(TagFirmware, d.Firmware).
+			AddField("producing", d.Producing).
+			AddField("communicating", d.Communicating).
+			AddField("provisioned", d.Provisioned).
+			AddField("operating", d.Operating).
+			AddField("status_ok", d.healthy()).
+			AddField("status", strings.Join(d.Status, ","))
+		ps[i] = schema.renameFields(FamilyDevice, pt)
+	}
+	return ps
+}
+
+// deviceInventory remembers the inventory of the previous scrape so changes
+// can be logged.
+var deviceInventory = newInventoryTracker()
+
+// inventoryChange is one difference between two inventory readings.
+type inventoryChange struct {
+	Serial string
+	Field  string // "device" when it appeared or disappeared
+	From   string
+	To     string
+}
+
+// inventoryTracker diffs successive inventory readings.
+type inventoryTracker struct {
+	mu   sync.Mutex
+	last map[string]InventoryDevice // nil until the first reading
+}
+
+func newInventoryTracker() *inventoryTracker {
+	return &inventoryTracker{}
+}
+
+// update records devices and returns what changed since the previous call,
+// ordered by serial. The first call establishes a baseline and reports
+// nothing.
+func (it *inventoryTracker) update(devices []InventoryDevice) []inventoryChange {
+	next := make(map[string]InventoryDevice, len(devices))
+	for _, d := range devices {
+		next[d.Serial] = d
+	}
+	it.mu.Lock()
+	prev := it.last
+	it.last = next
+	it.mu.Unlock()
+	if prev == nil {
+		return nil
+	}
+
+	var changes []inventoryChange
+	for serial, d := range next {
+		p, ok := prev[serial]
+		if !ok {
+			changes = append(changes, inventoryChange{Serial: serial, Field: "device", From: "absent", To: d.Type})
+			continue
+		}
+		for _, f := range []struct {
+			name     string
+			from, to string
+		}{
+			{"producing", strconv.FormatBool(p.Producing), strconv.FormatBool(d.Producing)},
+			{"communicating", strconv.FormatBool(p.Communicating), strconv.FormatBool(d.Communicating)},
+			{"provisioned", strconv.FormatBool(p.Provisioned), strconv.FormatBool(d.Provisioned)},
+			{"operating", strconv.FormatBool(p.Operating), strconv.FormatBool(d.Operating)},
+			{"firmware", p.Firmware, d.Firmware},
+			{"status", strings.Join(p.Status, ","), strings.Join(d.Status, ",")},
+		} {
+			if f.from != f.to {
+				changes = append(changes, inventoryChange{Serial: serial, Field: f.name, From: f.from, To: f.to})
+			}
+		}
+	}
+	for serial, p := range prev {
+		if _, ok := next[serial]; !ok {
+			changes = append(changes, inventoryChange{Serial: serial, Field: "device", From: p.Type, To: "absent"})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b inventoryChange) int { return strings.Compare(a.Serial, b.Serial) })
+	return changes
+}
+
+// logInventoryChanges logs each change; losing production or communication
+// or raising a status flag is a warning.
+func logInventoryChanges(changes []inventoryChange) {
+	for _, c := range changes {
+		level := slog.LevelInfo
+		switch {
+		case c.Field == "device" && c.To == "absent",
+			(c.Field == "producing" || c.Field == "communicating" || c.Field == "operating") && c.To == "false",
+			c.Field == "status" && c.To != statusOK && c.To != "":
+			level = slog.LevelWarn
+		}
+		slog.Log(context.Background(), level, "Device status changed",
+			"serial", c.Serial, "field", c.Field, "from", c.From, "to", c.To)
+	}
+}
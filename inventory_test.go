@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const inventoryJSON = `[
+  {"type": "PCU", "devices": [
+    {"serial_num": "122012345678", "part_num": "800-01391-r03", "img_pnum_running": "520-00082-r01-v04.27.04",
+     "device_status": ["envoy.global.ok"], "producing": true, "communicating": true, "provisioned": true, "operating": true},
+    {"serial_num": "122012345679", "img_pnum_running": "520-00082-r01-v04.27.04",
+     "device_status": ["envoy.cond_flags.pcu_ctrl.dc-pwr-low", "envoy.global.ok"], "producing": false, "communicating": true, "provisioned": true, "operating": true}
+  ]},
+  {"type": "ACB", "devices": []},
+  {"type": "NSRB", "devices": [
+    {"serial_num": "122099999999", "img_pnum_running": "500-00001", "device_status": ["envoy.global.ok"], "communicating": true, "provisioned": true, "operating": true}
+  ]}
+]`
+
+func TestGatewayClient_Inventory(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/inventory.json", r.URL.Path)
+		_, _ = w.Write([]byte(inventoryJSON))
+	}))
+	defer srv.Close()
+
+	devices, err := newGatewayClient(&Config{Address: srv.URL}).Inventory(context.Background())
+	require.NoError(t, err)
+	require.Len(t, devices, 3)
+	assert.Equal(t, "pcu", devices[0].Type)
+	assert.Equal(t, "520-00082-r01-v04.27.04", devices[0].Firmware)
+	assert.True(t, devices[0].Producing)
+	assert.True(t, devices[0].healthy())
+	assert.False(t, devices[1].healthy())
+	assert.Equal(t, "nsrb", devices[2].Type)
+}
+
+func TestExtractInventoryPoints(t *testing.T) {
+	t.Parallel()
+
+	devices := []InventoryDevice{{
+		Type: "pcu", Serial: "INV1", Firmware: "v4.27", Communicating: true, Provisioned: true, Operating: true,
+		Status: []string{"envoy.cond_flags.pcu_ctrl.dc-pwr-low", statusOK},
+	}}
+	pts := extractInventoryPoints(devices, &Schema{Source: "home"}, time.Now())
+	require.Len(t, pts, 1)
+
+	assert.Equal(t, "device", pts[0].Name())
+	tags := tagMap(pts[0])
+	assert.Equal(t, "pcu", tags[TagDeviceType])
+	assert.Equal(t, "INV1", tags[TagSerial])
+	assert.Equal(t, "v4.27", tags[TagFirmware])
+
+	fields := fieldMap(pts[0])
+	assert.Equal(t, false, fields["producing"])
+	assert.Equal(t, true, fields["communicating"])
+	assert.Equal(t, true, fields["provisioned"])
+	assert.Equal(t, true, fields["operating"])
+	assert.Equal(t, false, fields["status_ok"])
+	assert.Equal(t, "envoy.cond_flags.pcu_ctrl.dc-pwr-low,envoy.global.ok", fields["status"])
+}
+
+func TestInventoryTracker_Update(t *testing.T) {
+	t.Parallel()
+
+	tracker := newInventoryTracker()
+	inv1 := InventoryDevice{Type: "pcu", Serial: "INV1", Firmware: "v1", Producing: true, Communicating: true, Status: []string{statusOK}}
+	inv2 := InventoryDevice{Type: "pcu", Serial: "INV2", Firmware: "v1", Producing: true, Communicating: true}
+
+	assert.Empty(t, tracker.update([]InventoryDevice{inv1, inv2}), "first reading is the baseline")
+	assert.Empty(t, tracker.update([]InventoryDevice{inv1, inv2}))
+
+	inv1.Communicating = false
+	inv1.Firmware = "v2"
+	inv3 := InventoryDevice{Type: "nsrb", Serial: "RELAY"}
+	changes := tracker.update([]InventoryDevice{inv1, inv3})
+	assert.Equal(t, []inventoryChange{
+		{Serial: "INV1", Field: "communicating", From: "true", To: "false"},
+		{Serial: "INV1", Field: "firmware", From: "v1", To: "v2"},
+		{Serial: "INV2", Field: "device", From: "pcu", To: "absent"},
+		{Serial: "RELAY", Field: "device", From: "absent", To: "nsrb"},
+	}, changes)
+}
+
+func TestScrape_Inventory(t *testing.T) {
+	t.Parallel()
+
+	writer := &MockPointWriter{}
+	client := &MockEnvoyClient{
+		InventoryFunc: func(_ context.Context) ([]InventoryDevice, error) {
+			return []InventoryDevice{{Type: "pcu", Serial: "INV1", Producing: true}}, nil
+		},
+	}
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	assert.False(t, result.hasErr)
+	assert.Equal(t, 2, result.points, "snapshot + device")
+	assert.Equal(t, "device", writer.Written[1].Name())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// InverterDetail is the detailed telemetry of one microinverter from the
+// gateway's device data, converted to SI units.
+type InverterDetail struct {
+	Serial       string
+	Active       bool
+	DCVoltage    float64 // V
+	DCCurrent    float64 // A
+	ACVoltage    float64 // V
+	ACFrequency  float64 // Hz
+	TemperatureC float64
+	LifetimeWh   float64
+}
+
+// deviceData is one device in /ivp/pdm/device_data. The response is an
+// object keyed by device ID, with a few non-device counters mixed in.
+type deviceData struct {
+	DevName  string `json:"devName"`
+	SN       string `json:"sn"`
+	Active   bool   `json:"active"`
+	Channels []struct {
+		LastReading struct {
+			ACVoltageMV   float64 `json:"acVoltageINmV"`
+			ACFrequencyMH float64 `json:"acFrequencyINmHz"`
+			DCVoltageMV   float64 `json:"dcVoltageINmV"`
+			DCCurrentMA   float64 `json:"dcCurrentINmA"`
+			ChannelTemp   float64 `json:"channelTemp"`
+		} `json:"lastReading"`
+		Lifetime struct {
+			JoulesProduced float64 `json:"joulesProduced"`
+		} `json:"lifetime"`
+	} `json:"channels"`
+}
+
+// InverterDetails returns detailed telemetry for every microinverter
+// (GET /ivp/pdm/device_data). Older firmware returns 404.
+func (c *gatewayClient) InverterDetails(ctx context.Context) ([]InverterDetail, error) {
+	var raw map[string]json.RawMessage
+	if err := c.getJSON(ctx, "/ivp/pdm/device_data", &raw); err != nil {
+		return nil, err
+	}
+	return parseDeviceData(raw)
+}
+
+// parseDeviceData picks the microinverters ("pcu" devices) out of a
+// device_data response.
+func parseDeviceData(raw map[string]json.RawMessage) ([]InverterDetail, error) {
+	var out []InverterDetail
+	for id, msg := range raw {
+		if len(msg) == 0 || msg[0] != '{' {
+			continue // deviceCount, deviceDataLimit, ...
+		}
+		var d deviceData
+		if err := json.Unmarshal(msg, &d); err != nil {
+			return nil, fmt.Errorf("decode device %s: %w", id, err)
+		}
+		if d.DevName != "pcu" || d.SN == "" || len(d.Channels) == 0 {
+			continue
+		}
+		ch := d.Channels[0]
+		out = append(out, InverterDetail{
+			Serial:       d.SN,
+			Active:       d.Active,
+			DCVoltage:    ch.LastReading.DCVoltageMV / 1000,
+			DCCurrent:    ch.LastReading.DCCurrentMA / 1000,
+			ACVoltage:    ch.LastReading.ACVoltageMV / 1000,
+			ACFrequency:  ch.LastReading.ACFrequencyMH / 1000,
+			TemperatureC: ch.LastReading.ChannelTemp,
+			LifetimeWh:   ch.Lifetime.JoulesProduced / 3600,
+		})
+	}
+	slices.SortFunc(out, func(a, b InverterDetail) int { return strings.Compare(a.Serial, b.Serial) })
+	return out, nil
+}
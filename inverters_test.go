@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const deviceDataJSON = `{
+  "deviceCount": 3,
+  "deviceDataLimit": 50,
+  "553648384": {
+    "devName": "pcu", "sn": "122012345679", "active": true,
+    "channels": [{
+      "lastReading": {"endDate": 1700000000, "acVoltageINmV": 241500, "acFrequencyINmHz": 60010,
+        "dcVoltageINmV": 37250, "dcCurrentINmA": 8120, "channelTemp": 41},
+      "lifetime": {"joulesProduced": 3600000000}
+    }]
+  },
+  "553648385": {
+    "devName": "pcu", "sn": "122012345678", "active": false,
+    "channels": [{"lastReading": {"channelTemp": 12}, "lifetime": {"joulesProduced": 7200}}]
+  },
+  "704643328": {"devName": "eim", "sn": "CT1", "channels": [{}]}
+}`
+
+func TestGatewayClient_InverterDetails(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/ivp/pdm/device_data", r.URL.Path)
+		_, _ = w.Write([]byte(deviceDataJSON))
+	}))
+	defer srv.Close()
+
+	details, err := newGatewayClient(&Config{Address: srv.URL}).InverterDetails(context.Background())
+	require.NoError(t, err)
+	require.Len(t, details, 2, "only microinverters")
+
+	assert.Equal(t, "122012345678", details[0].Serial, "sorted by serial")
+	assert.False(t, details[0].Active)
+	assert.InDelta(t, 2.0, details[0].LifetimeWh, 1e-9)
+
+	d := details[1]
+	assert.True(t, d.Active)
+	assert.InDelta(t, 37.25, d.DCVoltage, 1e-9)
+	assert.InDelta(t, 8.12, d.DCCurrent, 1e-9)
+	assert.InDelta(t, 241.5, d.ACVoltage, 1e-9)
+	assert.InDelta(t, 60.01, d.ACFrequency, 1e-9)
+	assert.Equal(t, 41.0, d.TemperatureC)
+	assert.InDelta(t, 1000000.0, d.LifetimeWh, 1e-6)
+}
+
+func TestExtractInverterPoints_Details(t *testing.T) {
+	t.Parallel()
+
+	inverters := []gateway.InverterReading{
+		{SerialNumber: "INV1", LastReportWatts: 250},
+		{SerialNumber: "INV2", LastReportWatts: 240},
+	}
+	details := []InverterDetail{{Serial: "INV1", Active: true, DCVoltage: 37, DCCurrent: 7, ACVoltage: 240, ACFrequency: 60, TemperatureC: 45, LifetimeWh: 1200}}
+
+	pts := extractInverterPoints(inverters, details, &Schema{Source: "home"}, time.Now())
+	require.Len(t, pts, 2)
+
+	fields := fieldMap(pts[0])
+	assert.Equal(t, 250.0, fields[FieldP])
+	assert.Equal(t, 37.0, fields["dc_voltage_v"])
+	assert.Equal(t, 7.0, fields["dc_current_a"])
+	assert.Equal(t, 240.0, fields["ac_voltage_v"])
+	assert.Equal(t, 60.0, fields["ac_frequency_hz"])
+	assert.Equal(t, 45.0, fields["temperature_c"])
+	assert.Equal(t, 1200.0, fields["lifetime_wh"])
+	assert.Equal(t, true, fields["active"])
+
+	assert.Len(t, fieldMap(pts[1]), 1, "no detail: power only")
+}
+
+func TestScrape_InverterDetails(t *testing.T) {
+	t.Parallel()
+
+	inverters := func(_ context.Context) ([]gateway.InverterReading, error) {
+		return []gateway.InverterReading{{SerialNumber: "INV1", LastReportWatts: 250}}, nil
+	}
+
+	writer := &MockPointWriter{}
+	client := &MockEnvoyClient{
+		InvertersFunc: inverters,
+		InverterDetailsFunc: func(_ context.Context) ([]InverterDetail, error) {
+			return []InverterDetail{{Serial: "INV1", TemperatureC: 50}}, nil
+		},
+	}
+	result := scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	assert.False(t, result.hasErr)
+	var found bool
+	for _, pt := range writer.Written {
+		if tagMap(pt)[TagSerial] == "INV1" {
+			found = true
+			assert.Equal(t, 50.0, fieldMap(pt)["temperature_c"])
+		}
+	}
+	assert.True(t, found)
+
+	// Older firmware without device data: power is still written.
+	writer = &MockPointWriter{}
+	client.InverterDetailsFunc = func(_ context.Context) ([]InverterDetail, error) {
+		return nil, &gateway.Error{StatusCode: 404, Endpoint: "/ivp/pdm/device_data"}
+	}
+	result = scrape(context.Background(), client, writer, &Schema{Source: "test"})
+	assert.False(t, result.hasErr, "404 from device data should not be treated as an error")
+	assert.Equal(t, 2, result.points, "snapshot + inverter")
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,22 +17,35 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // for location timezones where the system has no zoneinfo
 
 	gateway "github.com/hobeone/enphase-gateway"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	yaml "gopkg.in/yaml.v3"
 )
 
 
-func defaultClientFactory(cfg *Config) (EnvoyClient, error) {
+func defaultClientFactory(ctx context.Context, cfg *Config) (EnvoyClient, error) {
 	// Create client with skip TLS verification matching config
-	client := gateway.NewClient(cfg.Address, cfg.GetJWT(), gateway.WithInsecureSkipVerify(cfg.InsecureSkipVerify))
-	// Execute a lightweight validation call to verify gateway reachability at startup
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	return verifyGateway(ctx, newGatewayClient(cfg))
+}
+
+// verifyGateway checks that client reaches the gateway with a lightweight
+// live data read, then records the firmware before the first scrape. Both
+// calls are traced as children of the span in ctx.
+func verifyGateway(ctx context.Context, client EnvoyClient) (EnvoyClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	if _, err := client.LiveData(ctx); err != nil {
+	spanCtx, span := startSpan(ctx, "gateway."+EndpointLiveData)
+	_, err := client.LiveData(spanCtx)
+	endSpan(span, err)
+	if err != nil {
 		return nil, fmt.Errorf("failed to verify gateway connectivity: %w", err)
 	}
+	// Record the firmware before the first scrape, warning if it is untested.
+	checkGatewayInfo(ctx, client)
 	return client, nil
 }
 
@@ -129,12 +144,21 @@ type tokenFetcher func(username, password, serial string, opts ...gateway.AuthOp
 
 // fetchWithRetry calls fetch repeatedly until it succeeds or ctx is cancelled.
 // It waits retryWait between attempts.
-func fetchWithRetry(ctx context.Context, cfg *Config, retryWait time.Duration, fetch tokenFetcher) (string, error) {
-	for {
+func fetchWithRetry(ctx context.Context, cfg *Config, retryWait time.Duration, fetch tokenFetcher) (_ string, err error) {
+	_, span := startSpan(ctx, "jwt.refresh")
+	defer func() { endSpan(span, err) }()
+
+	for attempt := 1; ; attempt++ {
 		token, err := fetch(cfg.Username, cfg.Password, cfg.SerialNumber)
+		components.recordJWTRefresh(err)
+		promJWTRefreshes.WithLabelValues(resultLabel(err)).Inc()
 		if err == nil {
+			span.SetAttributes(attribute.Int("attempts", attempt))
 			return token, nil
 		}
+		span.AddEvent("fetch failed", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error())))
 		slog.Error("JWT refresh failed; retrying", "error", err)
 		select {
 		case <-ctx.Done():
@@ -192,6 +216,7 @@ func jwtRefresher(ctx context.Context, cfg *Config, expiry time.Time, reconnectC
 			return
 		}
 		expiry = newExpiry
+		components.setJWT(expiry, true)
 	}
 }
 
@@ -303,6 +328,7 @@ func run(args []string) error {
 			slog.Warn("Could not parse JWT expiry", "error", err)
 		} else {
 			slog.Info("JWT expires", "at", expiry.Format(time.RFC3339))
+			components.setJWT(expiry, cfg.Username != "" && cfg.Password != "")
 			if cfg.Username == "" || cfg.Password == "" {
 				slog.Warn("No credentials configured; JWT expiry will not be handled automatically")
 			} else {
@@ -319,25 +345,226 @@ func run(args []string) error {
 		"interval_s", cfg.Interval,
 		"source", cfg.SourceTag,
 		"influxdb", cfg.InfluxDB,
+		"influxdb_version", cfg.InfluxDBVersion,
+		"sqlite", cfg.SQLitePath,
+		"otlp", cfg.OTLPEndpoint,
 		"influxdb_org", cfg.InfluxDBOrg,
 		"influxdb_bucket", cfg.InfluxDBBucket,
 		"log_level", logLevelFlag,
 		"persist_jwt", persistJWTFlag || cfg.PersistJWT)
 
+	if cfg.Tracing != "" {
+		tp, err := newTracerProvider(ctx, cfg, os.Stdout)
+		if err != nil {
+			return err
+		}
+		otel.SetTracerProvider(tp)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = tp.Shutdown(shutdownCtx)
+		}()
+		slog.Info("Tracing enabled", "exporter", cfg.Tracing)
+	}
+
 	// Start the metrics and health HTTP server
-	startMetricsAndHealthServer(ctx, cfg.ExpvarPort, time.Duration(cfg.Interval)*time.Second)
+	startMetricsAndHealthServer(ctx, cfg.ExpvarPort, time.Duration(cfg.Interval)*time.Second, cfg.StaleAfter())
+
+	solarClipping.configure(cfg.Clipping, cfg.Devices, cfg.Location)
+	if solarClipping.enabled() {
+		slog.Info("Clipping detection enabled", "models", len(cfg.Clipping.Models), "system_limit_w", cfg.Clipping.SystemLimitW)
+	}
+	model, err := newProductionModel(cfg.Expected, cfg.Location, cfg.Devices)
+	if err != nil {
+		return err
+	}
+	expectedProduction.configure(model)
+	if model != nil {
+		slog.Info("Expected production model enabled", "arrays", len(model.arrays), "derate", model.derate)
+	}
+	scrapeSchedule.configure(cfg.Daylight, cfg.Location)
+	if st := scrapeSchedule.status(time.Now()); st != nil {
+		slog.Info("Daylight schedule enabled", "sunrise", st.Sunrise, "sunset", st.Sunset, "night_interval", cfg.Daylight.NightInterval)
+	}
+	if cfg.BatteryStatePath != "" {
+		if err := batteryAnalytics.load(cfg.BatteryStatePath); err != nil {
+			return err
+		}
+		defer func() {
+			if err := batteryAnalytics.save(time.Now()); err != nil {
+				slog.Error("Failed to save battery analytics state", "error", err)
+			}
+		}()
+		slog.Info("Battery analytics state", "path", cfg.BatteryStatePath)
+	}
+
+	var writers multiWriter
+	if cfg.InfluxDB != "" {
+		influx, err := NewInfluxWriter(influxOptions(cfg))
+		if err != nil {
+			return err
+		}
+		batch := NewBatchWriter("influxdb", trackedWriter{"influxdb", influx}, influxBatchOptions(cfg))
+		defer func() {
+			// Runs after scrapeLoop has returned, so nothing else is enqueued.
+			drainCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := batch.Close(drainCtx); err != nil {
+				slog.Error("InfluxDB write queue not fully drained", "error", err)
+			}
+		}()
+		writers = append(writers, batch)
+	}
+	if cfg.SQLitePath != "" {
+		sink, err := OpenSQLiteSink(cfg.SQLitePath, sqliteRetention(cfg))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = sink.Close() }()
+		slog.Info("SQLite storage enabled", "path", cfg.SQLitePath,
+			"retention_days", cfg.SQLiteRetentionDays,
+			"rollup_5m_retention_days", cfg.SQLiteRollup5mRetentionDays,
+			"rollup_1h_retention_days", cfg.SQLiteRollup1hRetentionDays)
+		go sink.RunRetention(ctx, time.Hour)
+		writers = append(writers, trackedWriter{"sqlite", sink})
+	}
+	if cfg.OTLPEndpoint != "" {
+		sink, err := NewOTLPSink(ctx, otlpOptions(cfg))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = sink.Close(shutdownCtx)
+		}()
+		slog.Info("OTLP metrics export enabled", "endpoint", cfg.OTLPEndpoint, "protocol", cfg.OTLPProtocol)
+		writers = append(writers, trackedWriter{"otlp", sink})
+	}
 
-	influxClient := influxdb2.NewClient(cfg.InfluxDB, cfg.InfluxDBToken)
-	defer influxClient.Close()
-	writeAPI := influxClient.WriteAPIBlocking(cfg.InfluxDBOrg, cfg.InfluxDBBucket)
+	var writeAPI PointWriter = writers
+	if len(writers) == 1 {
+		writeAPI = writers[0]
+	}
+	// Deadbanding thins the raw points only; rollups are computed from all
+	// of them and written in full.
+	raw := writeAPI
+	if len(cfg.Deadband.Fields) > 0 {
+		raw = NewDeadbandWriter(writeAPI, cfg.Deadband)
+		slog.Info("Deadband enabled", "fields", len(cfg.Deadband.Fields), "heartbeat", cfg.Deadband.heartbeat())
+	}
+	if len(cfg.Rollups.Windows) > 0 {
+		out := writeAPI
+		if cfg.Rollups.InfluxDBBucket != "" || cfg.Rollups.InfluxDBDatabase != "" {
+			opts := influxOptions(cfg)
+			opts.Bucket = cmp.Or(cfg.Rollups.InfluxDBBucket, opts.Bucket)
+			opts.Database = cmp.Or(cfg.Rollups.InfluxDBDatabase, opts.Database)
+			influx, err := NewInfluxWriter(opts)
+			if err != nil {
+				return err
+			}
+			batch := NewBatchWriter("influxdb-rollups", trackedWriter{"influxdb-rollups", influx}, influxBatchOptions(cfg))
+			defer func() {
+				drainCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := batch.Close(drainCtx); err != nil {
+					slog.Error("InfluxDB rollup write queue not fully drained", "error", err)
+				}
+			}()
+			out = batch
+		}
+		rollups := NewRollupWriter(raw, out, cfg.Rollups.durations())
+		defer func() {
+			// Runs before the sinks above are closed.
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := rollups.Flush(flushCtx); err != nil {
+				slog.Error("Failed to write partial rollups", "error", err)
+			}
+		}()
+		slog.Info("Rollups enabled", "windows", cfg.Rollups.Windows,
+			"bucket", cfg.Rollups.InfluxDBBucket, "database", cfg.Rollups.InfluxDBDatabase)
+		writeAPI = rollups
+	} else {
+		writeAPI = raw
+	}
+	// Validation comes first, so rollups and deadbands only see checked values.
+	writeAPI = NewValidatingWriter(writeAPI, cfg.Validation)
+	if len(cfg.Validation.Fields) > 0 {
+		slog.Info("Validation rules enabled", "fields", len(cfg.Validation.Fields), "action", cmp.Or(cfg.Validation.Action, ValidationDrop))
+	}
 
 	scrapeLoop(ctx, cfg, writeAPI, defaultClientFactory, reconnectCh)
 	return nil
 }
 
-func startMetricsAndHealthServer(ctx context.Context, port int, scrapeInterval time.Duration) {
+// influxOptions converts the InfluxDB settings in cfg.
+func influxOptions(cfg *Config) InfluxOptions {
+	version := cfg.InfluxDBVersion
+	if version == 0 {
+		version = 2
+	}
+	return InfluxOptions{
+		URL:             cfg.InfluxDB,
+		Version:         version,
+		Token:           cfg.InfluxDBToken,
+		Org:             cfg.InfluxDBOrg,
+		Bucket:          cfg.InfluxDBBucket,
+		Database:        cfg.InfluxDBDatabase,
+		RetentionPolicy: cfg.InfluxDBRetentionPolicy,
+		Username:        cfg.InfluxDBUsername,
+		Password:        cfg.InfluxDBPassword,
+		Gzip:            cfg.InfluxDBGzip,
+		Timeout:         time.Duration(cfg.InfluxDBTimeout) * time.Second,
+	}
+}
+
+// influxBatchOptions converts the InfluxDB batching settings in cfg.
+func influxBatchOptions(cfg *Config) BatchOptions {
+	return BatchOptions{
+		BatchSize:     cfg.InfluxDBBatchSize,
+		FlushInterval: time.Duration(cfg.InfluxDBFlushInterval) * time.Second,
+		QueueSize:     cfg.InfluxDBQueueSize,
+		MaxRetries:    cfg.InfluxDBMaxRetries,
+	}
+}
+
+// sqliteRetention converts the day-based retention settings in cfg.
+func sqliteRetention(cfg *Config) SQLiteRetention {
+	day := 24 * time.Hour
+	return SQLiteRetention{
+		Raw:      time.Duration(cfg.SQLiteRetentionDays) * day,
+		Rollup5m: time.Duration(cfg.SQLiteRollup5mRetentionDays) * day,
+		Rollup1h: time.Duration(cfg.SQLiteRollup1hRetentionDays) * day,
+	}
+}
+
+// otlpOptions converts the OTLP settings in cfg.
+func otlpOptions(cfg *Config) OTLPOptions {
+	return OTLPOptions{
+		Endpoint:  cfg.OTLPEndpoint,
+		Protocol:  cfg.OTLPProtocol,
+		Insecure:  cfg.OTLPInsecure,
+		Headers:   cfg.OTLPHeaders,
+		Timeout:   time.Duration(cfg.OTLPTimeout) * time.Second,
+		BatchSize: cfg.OTLPBatchSize,
+		Serial:    cfg.SerialNumber,
+		Source:    cfg.SourceTag,
+	}
+}
+
+// startMetricsAndHealthServer serves expvar and Prometheus metrics, the health
+// and status endpoints, the JSON API and the dashboard. staleAfter is how old
+// the last successful scrape may be before /health, /readyz and the API report
+// degraded data.
+func startMetricsAndHealthServer(ctx context.Context, port int, scrapeInterval, staleAfter time.Duration) {
 	mux := http.NewServeMux()
 	mux.Handle("/debug/vars", expvar.Handler())
+	registerPrometheus(mux)
+	registerDashboard(mux, latest, scrapeInterval)
+	registerAPI(mux, latest, staleAfter)
+	registerStatus(mux, components, latest, staleAfter)
+	// /health predates /livez and /readyz and is kept for compatibility.
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		lastScrape := metricLastScrapeTime.Value()
 		if lastScrape == 0 {
@@ -345,7 +572,7 @@ func startMetricsAndHealthServer(ctx context.Context, port int, scrapeInterval t
 			_, _ = w.Write([]byte("degraded: no successful scrape yet\n"))
 			return
 		}
-		if time.Since(time.Unix(lastScrape, 0)) > 3*scrapeInterval {
+		if time.Since(time.Unix(lastScrape, 0)) > staleAfter {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			_, _ = w.Write([]byte("degraded: last successful scrape was too long ago\n"))
 			return
@@ -357,6 +584,8 @@ func startMetricsAndHealthServer(ctx context.Context, port int, scrapeInterval t
 	server := &http.Server{
 		Addr:    fmt.Sprintf("0.0.0.0:%d", port),
 		Handler: mux,
+		// Derive request contexts from ctx so long-lived streams end on shutdown.
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
 	go func() {
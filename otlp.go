@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// OTLP transport protocols.
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+// otlpRetryMaxElapsed bounds the exporter's own retries so a write gives up
+// before the 30s write timeout in scrape.
+const otlpRetryMaxElapsed = 25 * time.Second
+
+// OTLPOptions configures the OTLP metrics sink.
+type OTLPOptions struct {
+	Endpoint  string // host:port, or a URL to set the scheme and path
+	Protocol  string // OTLPProtocolGRPC or OTLPProtocolHTTP
+	Insecure  bool   // plaintext instead of TLS
+	Headers   map[string]string
+	Timeout   time.Duration // per export request
+	BatchSize int           // maximum points per export request; 0 sends everything at once
+
+	// Resource attributes.
+	Serial string
+	Source string
+}
+
+// otlpField describes how an InfluxDB field maps to an OTel metric.
+type otlpField struct {
+	name string
+	unit string
+}
+
+// otlpFields renames the terse CT field keys; other fields keep their name.
+var otlpFields = map[string]otlpField{
+	FieldP:    {"active_power", "W"},
+	FieldQ:    {"reactive_power", "VAr"},
+	FieldS:    {"apparent_power", "VA"},
+	FieldIrms: {"current", "A"},
+	FieldVrms: {"voltage", "V"},
+}
+
+// OTLPSink exports points as OTel metrics. Each WritePoint is one export of
+// up to BatchSize points; the exporter retries transient failures.
+type OTLPSink struct {
+	exporter  sdkmetric.Exporter
+	resource  *resource.Resource
+	batchSize int
+	start     time.Time // start time of cumulative sums
+}
+
+// NewOTLPSink creates an OTLP exporter for the configured protocol. The
+// connection is established lazily, so an unreachable collector is reported
+// by WritePoint rather than here.
+func NewOTLPSink(ctx context.Context, opts OTLPOptions) (*OTLPSink, error) {
+	var exp sdkmetric.Exporter
+	var err error
+	switch opts.Protocol {
+	case OTLPProtocolGRPC, "":
+		o := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithTimeout(opts.Timeout),
+			otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig{
+				Enabled:         true,
+				InitialInterval: time.Second,
+				MaxInterval:     10 * time.Second,
+				MaxElapsedTime:  otlpRetryMaxElapsed,
+			}),
+		}
+		if strings.Contains(opts.Endpoint, "://") {
+			o = append(o, otlpmetricgrpc.WithEndpointURL(opts.Endpoint))
+		} else {
+			o = append(o, otlpmetricgrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			o = append(o, otlpmetricgrpc.WithInsecure())
+		}
+		if len(opts.Headers) > 0 {
+			o = append(o, otlpmetricgrpc.WithHeaders(opts.Headers))
+		}
+		exp, err = otlpmetricgrpc.New(ctx, o...)
+	case OTLPProtocolHTTP:
+		o := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithTimeout(opts.Timeout),
+			otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression),
+			otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig{
+				Enabled:         true,
+				InitialInterval: time.Second,
+				MaxInterval:     10 * time.Second,
+				MaxElapsedTime:  otlpRetryMaxElapsed,
+			}),
+		}
+		if strings.Contains(opts.Endpoint, "://") {
+			o = append(o, otlpmetrichttp.WithEndpointURL(opts.Endpoint))
+		} else {
+			o = append(o, otlpmetrichttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			o = append(o, otlpmetrichttp.WithInsecure())
+		}
+		if len(opts.Headers) > 0 {
+			o = append(o, otlpmetrichttp.WithHeaders(opts.Headers))
+		}
+		exp, err = otlpmetrichttp.New(ctx, o...)
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol %q", opts.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create otlp %s exporter: %w", opts.Protocol, err)
+	}
+
+	return &OTLPSink{
+		exporter:  exp,
+		resource:  otelResource(opts.Serial, opts.Source),
+		batchSize: opts.BatchSize,
+		start:     time.Now(),
+	}, nil
+}
+
+// otelResource describes this exporter instance to OpenTelemetry backends.
+func otelResource(serial, source string) *resource.Resource {
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName("envoy-exporter"),
+		attribute.String("envoy.gateway.serial", serial),
+	}
+	if source != "" {
+		attrs = append(attrs, attribute.String("envoy.source", source))
+	}
+	return resource.NewWithAttributes(semconv.SchemaURL, attrs...)
+}
+
+// Close flushes and shuts down the exporter.
+func (s *OTLPSink) Close(ctx context.Context) error {
+	return s.exporter.Shutdown(ctx)
+}
+
+// WritePoint exports the points in batches of at most batchSize.
+func (s *OTLPSink) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	for len(point) > 0 {
+		n := len(point)
+		if s.batchSize > 0 {
+			n = min(n, s.batchSize)
+		}
+		rm := s.resourceMetrics(point[:n])
+		if len(rm.ScopeMetrics[0].Metrics) > 0 {
+			if err := s.exporter.Export(ctx, rm); err != nil {
+				return fmt.Errorf("otlp export: %w", err)
+			}
+		}
+		point = point[n:]
+	}
+	return nil
+}
+
+// resourceMetrics converts points to OTel metrics. The metric name is
+// envoy.<family>.<field>, where family is the measurement-type tag (or the
+// measurement name when the point has none), so per-line and per-device
+// measurements share one metric distinguished by attributes. Remaining tags
+// other than source, which is a resource attribute, become attributes.
+// Lifetime and *_total fields are cumulative sums; everything else is a gauge.
+// String fields have no numeric value and are skipped.
+func (s *OTLPSink) resourceMetrics(points []*influxdb2write.Point) *metricdata.ResourceMetrics {
+	var metrics []metricdata.Metrics
+	index := make(map[string]int) // metric name -> position in metrics
+
+	for _, pt := range points {
+		family := pt.Name()
+		var attrs []attribute.KeyValue
+		for _, tag := range pt.TagList() {
+			switch tag.Key {
+			case TagSource:
+			case TagMeasurementType:
+				family = tag.Value
+			default:
+				attrs = append(attrs, attribute.String(otlpName(tag.Key), tag.Value))
+			}
+		}
+		set := attribute.NewSet(attrs...)
+
+		for _, f := range pt.FieldList() {
+			v, ok := fieldFloat(f.Value)
+			if !ok {
+				continue
+			}
+			field, known := otlpFields[f.Key]
+			if !known {
+				field = otlpField{name: otlpName(f.Key), unit: otlpUnit(f.Key)}
+			}
+			name := "envoy." + otlpName(family) + "." + field.name
+			sum := strings.HasPrefix(field.name, "lifetime_") || strings.HasSuffix(field.name, "_total")
+
+			i, seen := index[name]
+			if !seen {
+				i = len(metrics)
+				index[name] = i
+				m := metricdata.Metrics{Name: name, Unit: field.unit}
+				if sum {
+					m.Data = metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+				} else {
+					m.Data = metricdata.Gauge[float64]{}
+				}
+				metrics = append(metrics, m)
+			}
+			dp := metricdata.DataPoint[float64]{Attributes: set, Time: pt.Time(), Value: v}
+			switch data := metrics[i].Data.(type) {
+			case metricdata.Sum[float64]:
+				dp.StartTime = s.start
+				data.DataPoints = append(data.DataPoints, dp)
+				metrics[i].Data = data
+			case metricdata.Gauge[float64]:
+				data.DataPoints = append(data.DataPoints, dp)
+				metrics[i].Data = data
+			}
+		}
+	}
+
+	return &metricdata.ResourceMetrics{
+		Resource: s.resource,
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   instrumentation.Scope{Name: "github.com/hobeone/envoy-exporter"},
+			Metrics: metrics,
+		}},
+	}
+}
+
+// otlpName converts an InfluxDB key to OTel naming style.
+func otlpName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+}
+
+// otlpUnit infers a UCUM unit from a field-name suffix.
+func otlpUnit(key string) string {
+	switch {
+	case strings.HasSuffix(key, "_wh"):
+		return "Wh"
+	case strings.HasSuffix(key, "_w"):
+		return "W"
+	case strings.HasSuffix(key, "_c"):
+		return "Cel"
+	case strings.HasSuffix(key, "_soc"), strings.HasPrefix(key, "percent_"):
+		return "%"
+	default:
+		return ""
+	}
+}
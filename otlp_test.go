@@ -0,0 +1,210 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpReceiver is an in-process OTLP metrics receiver that records requests.
+type otlpReceiver struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	mu       sync.Mutex
+	requests []*collectormetrics.ExportMetricsServiceRequest
+}
+
+func (r *otlpReceiver) Export(_ context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *otlpReceiver) received() []*collectormetrics.ExportMetricsServiceRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*collectormetrics.ExportMetricsServiceRequest(nil), r.requests...)
+}
+
+// startGRPCReceiver serves r over gRPC and returns its address.
+func startGRPCReceiver(t *testing.T, r *otlpReceiver) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(srv, r)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// httpReceiverHandler decodes OTLP/HTTP protobuf requests into r. The first
+// failFirst requests are answered with 503 to exercise retries.
+func httpReceiverHandler(t *testing.T, r *otlpReceiver, failFirst int32) http.Handler {
+	var calls atomic.Int32
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) <= failFirst {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body io.Reader = req.Body
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(req.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			body = gz
+		}
+		raw, err := io.ReadAll(body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var msg collectormetrics.ExportMetricsServiceRequest
+		if !assert.NoError(t, proto.Unmarshal(raw, &msg)) {
+			return
+		}
+		_, _ = r.Export(req.Context(), &msg)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ := proto.Marshal(&collectormetrics.ExportMetricsServiceResponse{})
+		_, _ = w.Write(out)
+	})
+}
+
+// otlpTestPoints returns one snapshot, CT and inverter point each.
+func otlpTestPoints() []*influxdb2write.Point {
+	now := time.Now()
+	pts := extractLiveDataPoints(makeLiveData(4000000, 0, -1000000, 3000000), &Schema{Source: "roof"}, nil, now)
+	pts = append(pts, extractCTPoints([]gateway.TypedCTReading{{
+		CTReading:       gateway.CTReading{Channels: []gateway.CTChannel{{ActivePower: 100, Voltage: 240}, {ActivePower: 200, Voltage: 241}}},
+		MeasurementType: MeasurementProduction,
+	}}, &Schema{Source: "roof"}, now)...)
+	pts = append(pts, extractInverterPoints([]gateway.InverterReading{{SerialNumber: "INV1", LastReportWatts: 250}}, nil, &Schema{Source: "roof"}, now)...)
+	return pts
+}
+
+// findMetric returns the named metric from the received requests.
+func findMetric(reqs []*collectormetrics.ExportMetricsServiceRequest, name string) *metricspb.Metric {
+	for _, req := range reqs {
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					if m.Name == name {
+						return m
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func attrValue(attrs []*commonpb.KeyValue, key string) string {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value.GetStringValue()
+		}
+	}
+	return ""
+}
+
+func TestOTLPSink_GRPC(t *testing.T) {
+	t.Parallel()
+
+	recv := &otlpReceiver{}
+	addr := startGRPCReceiver(t, recv)
+	sink, err := NewOTLPSink(context.Background(), OTLPOptions{
+		Endpoint: addr, Protocol: OTLPProtocolGRPC, Insecure: true,
+		Timeout: 5 * time.Second, Serial: "122233344455", Source: "roof",
+	})
+	require.NoError(t, err)
+	defer func() { _ = sink.Close(context.Background()) }()
+
+	require.NoError(t, sink.WritePoint(context.Background(), otlpTestPoints()...))
+
+	reqs := recv.received()
+	require.Len(t, reqs, 1, "all points are sent in one batch")
+	res := reqs[0].ResourceMetrics[0].Resource.Attributes
+	assert.Equal(t, "122233344455", attrValue(res, "envoy.gateway.serial"))
+	assert.Equal(t, "roof", attrValue(res, "envoy.source"))
+	assert.Equal(t, "envoy-exporter", attrValue(res, "service.name"))
+
+	solar := findMetric(reqs, "envoy.energy_snapshot.solar_w")
+	require.NotNil(t, solar)
+	assert.Equal(t, "W", solar.Unit)
+	require.Len(t, solar.GetGauge().GetDataPoints(), 1)
+	assert.Equal(t, 4000.0, solar.GetGauge().DataPoints[0].GetAsDouble())
+
+	power := findMetric(reqs, "envoy.production.active_power")
+	require.NotNil(t, power, "CT lines share one metric")
+	dps := power.GetGauge().GetDataPoints()
+	require.Len(t, dps, 2)
+	assert.Equal(t, "1", attrValue(dps[1].Attributes, "line_idx"))
+	assert.Empty(t, attrValue(dps[1].Attributes, "source"), "source is a resource attribute")
+
+	inv := findMetric(reqs, "envoy.inverter.active_power")
+	require.NotNil(t, inv)
+	assert.Equal(t, "INV1", attrValue(inv.GetGauge().DataPoints[0].Attributes, "serial"))
+}
+
+func TestOTLPSink_HTTPBatchesAndRetries(t *testing.T) {
+	t.Parallel()
+
+	recv := &otlpReceiver{}
+	srv := httptest.NewServer(httpReceiverHandler(t, recv, 1))
+	defer srv.Close()
+
+	sink, err := NewOTLPSink(context.Background(), OTLPOptions{
+		Endpoint: srv.URL + "/v1/metrics", Protocol: OTLPProtocolHTTP,
+		Timeout: 5 * time.Second, BatchSize: 2, Serial: "122233344455",
+	})
+	require.NoError(t, err)
+	defer func() { _ = sink.Close(context.Background()) }()
+
+	pts := otlpTestPoints() // 4 points
+	require.NoError(t, sink.WritePoint(context.Background(), pts...), "the 503 is retried")
+
+	reqs := recv.received()
+	assert.Len(t, reqs, 2, "4 points in batches of 2")
+	assert.NotNil(t, findMetric(reqs, "envoy.energy_snapshot.battery_soc"))
+	assert.NotNil(t, findMetric(reqs, "envoy.inverter.active_power"))
+}
+
+func TestOTLPSink_SumsForLifetimeFields(t *testing.T) {
+	t.Parallel()
+
+	sink := &OTLPSink{start: time.Now()}
+	pt := influxdb2.NewPointWithMeasurement("energy").
+		AddTag(TagSource, "roof").
+		AddField("lifetime_wh", 123456.0).
+		AddField("grid_mode", "on-grid").
+		SetTime(time.Now())
+	rm := sink.resourceMetrics([]*influxdb2write.Point{pt})
+
+	metrics := rm.ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 1, "string fields are skipped")
+	assert.Equal(t, "envoy.energy.lifetime_wh", metrics[0].Name)
+	assert.Equal(t, "Wh", metrics[0].Unit)
+	sum, ok := metrics[0].Data.(metricdata.Sum[float64])
+	require.True(t, ok)
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t, metricdata.CumulativeTemporality, sum.Temporality)
+}
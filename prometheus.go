@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus self-metrics, served on /metrics next to the expvar counters.
+// They live in their own registry so only exporter metrics are exposed, plus
+// the standard Go runtime and process collectors.
+var (
+	promRegistry = prometheus.NewRegistry()
+	promFactory  = promauto.With(promRegistry)
+
+	promEndpointDuration = promFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "envoy_exporter",
+		Name:      "gateway_request_duration_seconds",
+		Help:      "Duration of gateway endpoint requests, successful or not.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint"})
+	promEndpointErrors = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "gateway_request_errors_total",
+		Help:      "Failed gateway endpoint requests by error class (timeout, auth, not_found, decode, other).",
+	}, []string{"endpoint", "class"})
+
+	promSinkDuration = promFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "envoy_exporter",
+		Name:      "sink_write_duration_seconds",
+		Help:      "Duration of point writes to each sink.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"sink"})
+	promSinkErrors = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "sink_write_errors_total",
+		Help:      "Failed point writes to each sink.",
+	}, []string{"sink"})
+
+	promScrapeDuration = promFactory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "envoy_exporter",
+		Name:      "scrape_duration_seconds",
+		Help:      "Duration of complete scrape cycles.",
+		Buckets:   []float64{0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	})
+	promScrapes = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "scrapes_total",
+		Help:      "Scrape cycles by result (ok, error).",
+	}, []string{"result"})
+	promPointsWritten = promFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "points_written_total",
+		Help:      "Points successfully written to the sinks.",
+	})
+
+	promConnectAttempts = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "gateway_connect_attempts_total",
+		Help:      "Gateway connection attempts by result (ok, error).",
+	}, []string{"result"})
+	promReconnects = promFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "gateway_reconnects_total",
+		Help:      "Reconnections to the gateway after a JWT refresh.",
+	})
+	promJWTRefreshes = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "envoy_exporter",
+		Name:      "jwt_refreshes_total",
+		Help:      "JWT fetch attempts by result (ok, error).",
+	}, []string{"result"})
+)
+
+func init() {
+	promRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newJWTCollector(components),
+	)
+}
+
+// Error classes used as the class label of envoy_exporter_gateway_request_errors_total.
+const (
+	ErrClassTimeout  = "timeout"
+	ErrClassAuth     = "auth"
+	ErrClassNotFound = "not_found"
+	ErrClassDecode   = "decode"
+	ErrClassOther    = "other"
+)
+
+// errorClass buckets a gateway error so failures can be told apart without
+// parsing error strings.
+func errorClass(err error) string {
+	var netErr net.Error
+	var jsonSyntax *json.SyntaxError
+	var jsonType *json.UnmarshalTypeError
+	var xmlSyntax *xml.SyntaxError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrClassTimeout
+	case gateway.IsUnauthorized(err):
+		return ErrClassAuth
+	case gateway.IsNotFound(err):
+		return ErrClassNotFound
+	case errors.As(err, &jsonSyntax), errors.As(err, &jsonType), errors.As(err, &xmlSyntax),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrClassDecode
+	default:
+		return ErrClassOther
+	}
+}
+
+// resultLabel is the result label value for err.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeEndpoint records the outcome of one gateway endpoint fetch in the
+// status tracker and the Prometheus metrics.
+func observeEndpoint(name string, dur time.Duration, err error) {
+	components.recordEndpoint(name, err)
+	promEndpointDuration.WithLabelValues(name).Observe(dur.Seconds())
+	if err != nil {
+		promEndpointErrors.WithLabelValues(name, errorClass(err)).Inc()
+	}
+}
+
+// jwtCollector exports the time left on the current JWT. It is computed at
+// collection time so the gauge counts down between refreshes, and is omitted
+// until the expiry is known.
+type jwtCollector struct {
+	tracker *componentTracker
+	desc    *prometheus.Desc
+}
+
+func newJWTCollector(t *componentTracker) *jwtCollector {
+	return &jwtCollector{
+		tracker: t,
+		desc: prometheus.NewDesc("envoy_exporter_jwt_expiry_seconds",
+			"Seconds until the gateway JWT expires; negative once expired.", nil, nil),
+	}
+}
+
+func (c *jwtCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c *jwtCollector) Collect(ch chan<- prometheus.Metric) {
+	expiry := c.tracker.jwtExpiryTime()
+	if expiry.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Until(expiry).Seconds())
+}
+
+// registerPrometheus adds /metrics to mux.
+func registerPrometheus(mux *http.ServeMux) {
+	mux.Handle("GET /metrics", promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{}))
+}
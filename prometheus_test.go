@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClass(t *testing.T) {
+	t.Parallel()
+
+	var syntaxErr *json.SyntaxError
+	decodeErr := fmt.Errorf("decode response /production.json: %w", json.Unmarshal([]byte("nope"), &struct{}{}))
+	require.ErrorAs(t, decodeErr, &syntaxErr)
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"deadline", fmt.Errorf("request /ivp/livedata/status: %w", context.DeadlineExceeded), ErrClassTimeout},
+		{"net timeout", fmt.Errorf("request: %w", timeoutError{}), ErrClassTimeout},
+		{"unauthorized", &gateway.Error{StatusCode: http.StatusUnauthorized}, ErrClassAuth},
+		{"not found", &gateway.Error{StatusCode: http.StatusNotFound}, ErrClassNotFound},
+		{"decode", decodeErr, ErrClassDecode},
+		{"other", errors.New("connection refused"), ErrClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, errorClass(tt.err))
+		})
+	}
+}
+
+func TestObserveEndpoint(t *testing.T) {
+	t.Parallel()
+
+	// Use an endpoint name no other test, or run with -count, touches so the
+	// global metrics can be asserted exactly.
+	name := fmt.Sprintf("test-observe-%d", time.Now().UnixNano())
+	observeEndpoint(name, 200*time.Millisecond, nil)
+	observeEndpoint(name, time.Second, &gateway.Error{StatusCode: http.StatusUnauthorized})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(promEndpointErrors.WithLabelValues(name, ErrClassAuth)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(promEndpointErrors.WithLabelValues(name, ErrClassTimeout)))
+
+	mux := http.NewServeMux()
+	registerPrometheus(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `envoy_exporter_gateway_request_duration_seconds_count{endpoint="`+name+`"} 2`,
+		"failed requests are timed too")
+}
+
+func TestTrackedWriter_ObservesSink(t *testing.T) {
+	t.Parallel()
+
+	failing := &MockPointWriter{WritePointFunc: func(context.Context, ...*influxdb2write.Point) error {
+		return errors.New("down")
+	}}
+	before := testutil.ToFloat64(promSinkErrors.WithLabelValues("test-sink"))
+	w := trackedWriter{name: "test-sink", next: failing}
+	require.Error(t, w.WritePoint(context.Background()))
+	assert.Equal(t, before+1, testutil.ToFloat64(promSinkErrors.WithLabelValues("test-sink")))
+}
+
+func TestJWTCollector(t *testing.T) {
+	t.Parallel()
+
+	tr := newComponentTracker()
+	c := newJWTCollector(tr)
+	assert.Equal(t, 0, testutil.CollectAndCount(c), "omitted until the expiry is known")
+
+	tr.setJWT(time.Now().Add(time.Hour), true)
+	require.Equal(t, 1, testutil.CollectAndCount(c))
+	assert.InDelta(t, 3600, testutil.ToFloat64(c), 5)
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	t.Parallel()
+
+	observeEndpoint("test-handler", 10*time.Millisecond, nil)
+	mux := http.NewServeMux()
+	registerPrometheus(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `envoy_exporter_gateway_request_duration_seconds_bucket{endpoint="test-handler"`)
+	assert.Contains(t, body, "go_goroutines")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// RollupConfig configures rollup points computed by the exporter. Rollups
+// are enabled when windows are set.
+type RollupConfig struct {
+	Windows []int `yaml:"windows"` // window sizes in seconds, e.g. [60, 3600]
+
+	// Write rollups to this InfluxDB bucket (v2) or database (v1, v3)
+	// instead of alongside the raw points.
+	InfluxDBBucket   string `yaml:"influxdb_bucket"`
+	InfluxDBDatabase string `yaml:"influxdb_database"`
+}
+
+// validate checks the windows and that a rollup bucket has an InfluxDB.
+func (c RollupConfig) validate(influxdb string) error {
+	seen := make(map[int]bool)
+	for _, w := range c.Windows {
+		if w <= 0 {
+			return fmt.Errorf("rollups: window %d must be positive", w)
+		}
+		if seen[w] {
+			return fmt.Errorf("rollups: duplicate window %d", w)
+		}
+		seen[w] = true
+	}
+	if (c.InfluxDBBucket != "" || c.InfluxDBDatabase != "") && influxdb == "" {
+		return fmt.Errorf("rollups: influxdb_bucket and influxdb_database require influxdb")
+	}
+	return nil
+}
+
+// durations returns the windows in ascending order.
+func (c RollupConfig) durations() []time.Duration {
+	ws := make([]time.Duration, len(c.Windows))
+	for i, w := range c.Windows {
+		ws[i] = time.Duration(w) * time.Second
+	}
+	slices.Sort(ws)
+	return ws
+}
+
+// windowLabel names a window in rollup measurement names: 1m, 1h, 90s.
+func windowLabel(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// rollupStats summarises one field over a window.
+type rollupStats struct {
+	min, max, sum, last float64
+	count               int
+}
+
+func (s *rollupStats) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	s.last = v
+	s.count++
+}
+
+// rollupWindow accumulates one series over one window.
+type rollupWindow struct {
+	name   string // measurement of the raw points
+	tags   map[string]string
+	start  time.Time
+	size   time.Duration
+	fields map[string]*rollupStats
+	order  []string // field keys in first-seen order
+}
+
+// point renders the window as <measurement>_<window> with min, mean, max
+// and last of each field, timestamped at the start of the window. It
+// returns nil when the window has no numeric fields.
+func (w *rollupWindow) point() *influxdb2write.Point {
+	if len(w.order) == 0 {
+		return nil
+	}
+	pt := influxdb2.NewPointWithMeasurement(w.name + "_" + windowLabel(w.size)).SetTime(w.start)
+	for _, k := range slices.Sorted(maps.Keys(w.tags)) {
+		pt.AddTag(k, w.tags[k])
+	}
+	for _, k := range w.order {
+		s := w.fields[k]
+		pt.AddField(k+"_min", s.min).
+			AddField(k+"_mean", s.sum/float64(s.count)).
+			AddField(k+"_max", s.max).
+			AddField(k+"_last", s.last)
+	}
+	return pt
+}
+
+// rollupKey identifies an open window.
+type rollupKey struct {
+	series string
+	size   time.Duration
+}
+
+// RollupWriter passes points on to next and folds their numeric and boolean
+// fields into windows of each size per series, aligned to multiples of the
+// size. Once any point arrives past the end of a window, the window's rollup
+// point is written to out, so series that stop reporting, like inverters at
+// night, are still closed by the next scrape. Points whose window has
+// already ended by the newest point seen, like the daily summaries, are not
+// rolled up.
+type RollupWriter struct {
+	next    PointWriter
+	out     PointWriter
+	windows []time.Duration
+
+	mu     sync.Mutex
+	open   map[rollupKey]*rollupWindow
+	closed map[rollupKey]time.Time // end of the last window written
+	newest time.Time               // latest point timestamp seen
+}
+
+// NewRollupWriter returns a RollupWriter for the given window sizes. Call
+// Flush on shutdown to write the partial windows.
+func NewRollupWriter(next, out PointWriter, windows []time.Duration) *RollupWriter {
+	return &RollupWriter{
+		next:    next,
+		out:     out,
+		windows: windows,
+		open:    make(map[rollupKey]*rollupWindow),
+		closed:  make(map[rollupKey]time.Time),
+	}
+}
+
+// WritePoint writes points to next and any rollups they complete to out.
+func (r *RollupWriter) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	rollups := r.fold(point)
+	err := r.next.WritePoint(ctx, point...)
+	if len(rollups) > 0 {
+		err = errors.Join(err, r.out.WritePoint(ctx, rollups...))
+	}
+	return err
+}
+
+// Flush writes the rollups of all open windows, though they are incomplete.
+func (r *RollupWriter) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	var rollups []*influxdb2write.Point
+	for _, k := range r.sortedKeys() {
+		if rp := r.open[k].point(); rp != nil {
+			rollups = append(rollups, rp)
+		}
+		delete(r.open, k)
+	}
+	r.mu.Unlock()
+	if len(rollups) == 0 {
+		return nil
+	}
+	return r.out.WritePoint(ctx, rollups...)
+}
+
+// fold adds points to their windows and returns the rollups of windows
+// that have ended by the newest point seen.
+func (r *RollupWriter) fold(points []*influxdb2write.Point) []*influxdb2write.Point {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rollups []*influxdb2write.Point
+	for _, pt := range points {
+		series := pt.Name() + "\x00" + canonicalTags(pt)
+		ts := pt.Time()
+		if ts.After(r.newest) {
+			r.newest = ts
+		}
+		for _, size := range r.windows {
+			k := rollupKey{series, size}
+			start := ts.Truncate(size)
+			if ts.Before(r.closed[k]) {
+				continue // late
+			}
+			w, ok := r.open[k]
+			if ok && start.Before(w.start) {
+				continue // late
+			}
+			if ok && start.After(w.start) {
+				if rp := w.point(); rp != nil {
+					rollups = append(rollups, rp)
+				}
+				r.closed[k] = w.start.Add(size)
+				ok = false
+			}
+			if !ok && !start.Add(size).After(r.newest) {
+				continue // late, and the window would close at once
+			}
+			if !ok {
+				w = &rollupWindow{name: pt.Name(), tags: tagsOf(pt), start: start, size: size, fields: make(map[string]*rollupStats)}
+				r.open[k] = w
+			}
+			for _, f := range pt.FieldList() {
+				v, ok := fieldFloat(f.Value)
+				if !ok {
+					continue
+				}
+				s, ok := w.fields[f.Key]
+				if !ok {
+					s = &rollupStats{}
+					w.fields[f.Key] = s
+					w.order = append(w.order, f.Key)
+				}
+				s.add(v)
+			}
+		}
+	}
+	return append(rollups, r.expire()...)
+}
+
+// expire closes the open windows that end at or before the newest point
+// seen and returns their rollups.
+func (r *RollupWriter) expire() []*influxdb2write.Point {
+	var rollups []*influxdb2write.Point
+	for _, k := range r.sortedKeys() {
+		w := r.open[k]
+		end := w.start.Add(w.size)
+		if end.After(r.newest) {
+			continue
+		}
+		if rp := w.point(); rp != nil {
+			rollups = append(rollups, rp)
+		}
+		r.closed[k] = end
+		delete(r.open, k)
+	}
+	return rollups
+}
+
+// sortedKeys orders the open windows by series and size.
+func (r *RollupWriter) sortedKeys() []rollupKey {
+	return slices.SortedFunc(maps.Keys(r.open), func(a, b rollupKey) int {
+		return cmp.Or(strings.Compare(a.series, b.series), cmp.Compare(a.size, b.size))
+	})
+}
+
+// tagsOf returns a copy of the point's tags.
+func tagsOf(pt *influxdb2write.Point) map[string]string {
+	tags := make(map[string]string, len(pt.TagList()))
+	for _, t := range pt.TagList() {
+		tags[t.Key] = t.Value
+	}
+	return tags
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollupConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, RollupConfig{}.validate(""))
+	assert.NoError(t, RollupConfig{Windows: []int{60, 3600}, InfluxDBBucket: "rollups"}.validate("http://influx:8086"))
+	assert.Error(t, RollupConfig{Windows: []int{0}}.validate(""))
+	assert.Error(t, RollupConfig{Windows: []int{60, 60}}.validate(""))
+	assert.Error(t, RollupConfig{Windows: []int{60}, InfluxDBBucket: "rollups"}.validate(""), "bucket without influxdb")
+
+	assert.Equal(t, []time.Duration{time.Minute, time.Hour}, RollupConfig{Windows: []int{3600, 60}}.durations())
+}
+
+func TestWindowLabel(t *testing.T) {
+	t.Parallel()
+
+	for d, want := range map[time.Duration]string{
+		time.Minute:      "1m",
+		5 * time.Minute:  "5m",
+		time.Hour:        "1h",
+		24 * time.Hour:   "1d",
+		90 * time.Second: "90s",
+	} {
+		assert.Equal(t, want, windowLabel(d))
+	}
+}
+
+func rollupTestPoint(w float64, online bool, t time.Time) *influxdb2write.Point {
+	return influxdb2.NewPointWithMeasurement("energy-snapshot").
+		AddTag("source", "home").
+		AddField("solar_w", w).
+		AddField("online", online).
+		AddField("mode", "on-grid").
+		SetTime(t)
+}
+
+func TestRollupWriter(t *testing.T) {
+	t.Parallel()
+
+	raw, out := &MockPointWriter{}, &MockPointWriter{}
+	r := NewRollupWriter(raw, out, []time.Duration{time.Minute, time.Hour})
+	ctx := context.Background()
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, w := range []float64{100, 300, 200} {
+		require.NoError(t, r.WritePoint(ctx, rollupTestPoint(w, i != 1, base.Add(time.Duration(i)*20*time.Second))))
+	}
+	assert.Len(t, raw.Written, 3, "raw points are passed through")
+	assert.Empty(t, out.Written, "the minute is not over")
+
+	require.NoError(t, r.WritePoint(ctx, rollupTestPoint(400, true, base.Add(time.Minute))))
+	require.Len(t, out.Written, 1)
+	pt := out.Written[0]
+	assert.Equal(t, "energy-snapshot_1m", pt.Name())
+	assert.Equal(t, base, pt.Time())
+	assert.Equal(t, "home", tagMap(pt)["source"])
+	assert.Equal(t, map[string]any{
+		"solar_w_min": 100.0, "solar_w_mean": 200.0, "solar_w_max": 300.0, "solar_w_last": 200.0,
+		"online_min": 0.0, "online_mean": 2.0 / 3, "online_max": 1.0, "online_last": 1.0,
+	}, fieldMap(pt), "strings are not rolled up")
+
+	// A daily summary for the previous day is not rolled up.
+	require.NoError(t, r.WritePoint(ctx, rollupTestPoint(5, true, base.Add(-12*time.Hour))))
+	assert.Len(t, out.Written, 1)
+	assert.Len(t, raw.Written, 5)
+
+	// Shutdown writes the partial minute and hour.
+	require.NoError(t, r.Flush(ctx))
+	require.Len(t, out.Written, 3)
+	assert.Equal(t, "energy-snapshot_1m", out.Written[1].Name())
+	assert.Equal(t, 400.0, fieldMap(out.Written[1])["solar_w_mean"])
+	assert.Equal(t, "energy-snapshot_1h", out.Written[2].Name())
+	assert.Equal(t, 250.0, fieldMap(out.Written[2])["solar_w_mean"])
+	require.NoError(t, r.Flush(ctx))
+	assert.Len(t, out.Written, 3, "nothing left to flush")
+}
+
+func TestRollupWriter_ClosesSilentSeries(t *testing.T) {
+	t.Parallel()
+
+	raw, out := &MockPointWriter{}, &MockPointWriter{}
+	r := NewRollupWriter(raw, out, []time.Duration{time.Minute})
+	ctx := context.Background()
+	base := time.Date(2026, 6, 1, 20, 0, 0, 0, time.UTC)
+	inverter := func(t time.Time) *influxdb2write.Point {
+		return influxdb2.NewPointWithMeasurement("inverter").AddTag("serial", "A").AddField("power_w", 12.0).SetTime(t)
+	}
+
+	require.NoError(t, r.WritePoint(ctx, rollupTestPoint(100, true, base), inverter(base)))
+	assert.Empty(t, out.Written)
+
+	// The inverter is no longer polled; the snapshot alone moves time on.
+	require.NoError(t, r.WritePoint(ctx, rollupTestPoint(0, true, base.Add(time.Minute))))
+	require.Len(t, out.Written, 2)
+	names := []string{out.Written[0].Name(), out.Written[1].Name()}
+	assert.ElementsMatch(t, []string{"energy-snapshot_1m", "inverter_1m"}, names)
+
+	// A late point for the closed window is not rolled up again.
+	require.NoError(t, r.WritePoint(ctx, inverter(base.Add(30*time.Second))))
+	require.NoError(t, r.Flush(ctx))
+	assert.Len(t, out.Written, 3, "only the open snapshot minute is flushed")
+}
+
+func TestRollupWriter_SkipsOldSeries(t *testing.T) {
+	t.Parallel()
+
+	raw, out := &MockPointWriter{}, &MockPointWriter{}
+	r := NewRollupWriter(raw, out, []time.Duration{time.Minute, time.Hour})
+	ctx := context.Background()
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	summary := influxdb2.NewPointWithMeasurement("daily-summary").
+		AddField("production_wh", 25000.0).
+		SetTime(base.Add(-12 * time.Hour))
+
+	require.NoError(t, r.WritePoint(ctx, rollupTestPoint(100, true, base)))
+	require.NoError(t, r.WritePoint(ctx, summary))
+	assert.Len(t, raw.Written, 2, "the summary is passed through")
+	assert.Empty(t, out.Written, "a new series with an old timestamp is not rolled up")
+
+	require.NoError(t, r.Flush(ctx))
+	require.Len(t, out.Written, 2, "only the snapshot minute and hour are flushed")
+	assert.ElementsMatch(t, []string{"energy-snapshot_1m", "energy-snapshot_1h"}, []string{out.Written[0].Name(), out.Written[1].Name()})
+}
+
+func TestRollupWriter_Errors(t *testing.T) {
+	t.Parallel()
+
+	raw := &MockPointWriter{WritePointFunc: func(context.Context, ...*influxdb2write.Point) error { return errors.New("raw down") }}
+	out := &MockPointWriter{}
+	r := NewRollupWriter(raw, out, []time.Duration{time.Minute})
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Error(t, r.WritePoint(context.Background(), rollupTestPoint(100, true, base)))
+	assert.Error(t, r.WritePoint(context.Background(), rollupTestPoint(100, true, base.Add(time.Minute))))
+	assert.Len(t, out.Written, 1, "rollups are written when the raw sink fails")
+}
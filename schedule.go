@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultDaylightMargin is how long before sunrise and after sunset is still
+// treated as day: inverters wake up and shut down in twilight.
+const defaultDaylightMargin = 30 * time.Minute
+
+// nightEndpoints are the gateway endpoints polled less, or not at all, at
+// night. Live data, meters, energy totals and batteries are polled around
+// the clock: consumption and storage keep changing after sunset.
+var nightEndpoints = []string{EndpointInverters, EndpointInverterDetails}
+
+// DaylightConfig configures the daylight-aware scrape schedule. It needs the
+// location.
+type DaylightConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	NightInterval int  `yaml:"night_interval"` // seconds between inverter polls at night; 0 skips them
+	Margin        *int `yaml:"margin"`         // minutes around sunrise and sunset treated as day; default 30
+}
+
+// validate checks that the schedule can be computed.
+func (c DaylightConfig) validate(loc *Location) error {
+	if !c.Enabled {
+		return nil
+	}
+	if loc == nil {
+		return fmt.Errorf("daylight: enabled requires location")
+	}
+	if c.NightInterval < 0 {
+		return fmt.Errorf("daylight: night_interval must not be negative")
+	}
+	if c.Margin != nil && *c.Margin < 0 {
+		return fmt.Errorf("daylight: margin must not be negative")
+	}
+	return nil
+}
+
+// scrapeSchedule decides which endpoints scrape polls. Like latest it is
+// process-wide; run configures it. Unconfigured, everything is polled on
+// every scrape.
+var scrapeSchedule = &daylightSchedule{}
+
+// daylightSchedule polls the night endpoints on every scrape during the day
+// and every night interval, if at all, at night.
+type daylightSchedule struct {
+	mu            sync.Mutex
+	loc           *Location
+	margin        time.Duration
+	nightInterval time.Duration
+	lastPoll      time.Time // of the night endpoints
+}
+
+// configure sets the schedule from cfg; a disabled cfg polls everything.
+func (d *daylightSchedule) configure(cfg DaylightConfig, loc *Location) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loc, d.lastPoll = nil, time.Time{}
+	if !cfg.Enabled || loc == nil {
+		return
+	}
+	l := *loc
+	d.loc = &l
+	d.margin = defaultDaylightMargin
+	if cfg.Margin != nil {
+		d.margin = time.Duration(*cfg.Margin) * time.Minute
+	}
+	d.nightInterval = time.Duration(cfg.NightInterval) * time.Second
+}
+
+// daylight reports whether t is within the margin of the sun being up, and
+// the sun events it was judged by.
+func (d *daylightSchedule) daylight(t time.Time) (bool, sunEvents) {
+	ev := sunEventsOn(*d.loc, t)
+	if ev.Sunrise.IsZero() {
+		return ev.Up, ev
+	}
+	return !t.Before(ev.Sunrise.Add(-d.margin)) && !t.After(ev.Sunset.Add(d.margin)), ev
+}
+
+// pollInverters reports whether the night endpoints are due at now, and if
+// so records that they were polled.
+func (d *daylightSchedule) pollInverters(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.loc == nil {
+		return true
+	}
+	due := true
+	if day, _ := d.daylight(now); !day {
+		due = d.nightInterval > 0 && (d.lastPoll.IsZero() || now.Sub(d.lastPoll) >= d.nightInterval)
+	}
+	if due {
+		d.lastPoll = now
+	}
+	return due
+}
+
+// scheduleStatus is the schedule section of /status.
+type scheduleStatus struct {
+	Daylight       bool      `json:"daylight"`
+	Sunrise        time.Time `json:"sunrise,omitzero"`
+	Sunset         time.Time `json:"sunset,omitzero"`
+	MarginS        float64   `json:"margin_seconds"`
+	NightIntervalS float64   `json:"night_interval_seconds"` // 0: not polled at night
+	NightEndpoints []string  `json:"night_endpoints"`
+	LastPoll       time.Time `json:"last_night_endpoint_poll,omitzero"`
+}
+
+// status returns the schedule at now, or nil when it is not configured.
+func (d *daylightSchedule) status(now time.Time) *scheduleStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.loc == nil {
+		return nil
+	}
+	day, ev := d.daylight(now)
+	return &scheduleStatus{
+		Daylight:       day,
+		Sunrise:        ev.Sunrise,
+		Sunset:         ev.Sunset,
+		MarginS:        d.margin.Seconds(),
+		NightIntervalS: d.nightInterval.Seconds(),
+		NightEndpoints: nightEndpoints,
+		LastPoll:       d.lastPoll,
+	}
+}
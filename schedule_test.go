@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaylightConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	loc := &Location{Latitude: 51.48}
+	assert.NoError(t, DaylightConfig{}.validate(nil))
+	assert.NoError(t, DaylightConfig{Enabled: true, NightInterval: 600}.validate(loc))
+	assert.Error(t, DaylightConfig{Enabled: true}.validate(nil), "no location")
+	assert.Error(t, DaylightConfig{Enabled: true, NightInterval: -1}.validate(loc))
+	assert.Error(t, DaylightConfig{Enabled: true, Margin: ptr(-5)}.validate(loc))
+}
+
+func TestDaylightSchedule(t *testing.T) {
+	t.Parallel()
+
+	d := &daylightSchedule{}
+	night := time.Date(2026, 6, 21, 0, 30, 0, 0, time.UTC) // Greenwich sunrise is at 03:43
+	assert.True(t, d.pollInverters(night), "unconfigured polls everything")
+	assert.Nil(t, d.status(night))
+
+	loc := &Location{Latitude: 51.48}
+	d.configure(DaylightConfig{Enabled: true}, loc)
+	assert.False(t, d.pollInverters(night), "skipped at night")
+	assert.True(t, d.pollInverters(time.Date(2026, 6, 21, 3, 30, 0, 0, time.UTC)), "within the margin of sunrise")
+	assert.True(t, d.pollInverters(time.Date(2026, 6, 21, 12, 0, 0, 0, time.UTC)))
+	assert.False(t, d.pollInverters(time.Date(2026, 6, 21, 21, 0, 0, 0, time.UTC)), "sunset at 20:21")
+
+	d.configure(DaylightConfig{Enabled: true, NightInterval: 600, Margin: ptr(0)}, loc)
+	assert.True(t, d.pollInverters(night), "first night poll")
+	assert.False(t, d.pollInverters(night.Add(5*time.Minute)))
+	assert.True(t, d.pollInverters(night.Add(10*time.Minute)))
+
+	st := d.status(night)
+	require.NotNil(t, st)
+	assert.False(t, st.Daylight)
+	assert.Equal(t, 3, st.Sunrise.Hour())
+	assert.Equal(t, 600.0, st.NightIntervalS)
+	assert.Zero(t, st.MarginS)
+	assert.Equal(t, night.Add(10*time.Minute), st.LastPoll)
+	assert.Equal(t, []string{EndpointInverters, EndpointInverterDetails}, st.NightEndpoints)
+
+	polar := &daylightSchedule{}
+	polar.configure(DaylightConfig{Enabled: true}, &Location{Latitude: 78})
+	assert.True(t, polar.pollInverters(night), "midnight sun")
+	assert.False(t, polar.pollInverters(time.Date(2026, 12, 21, 12, 0, 0, 0, time.UTC)), "polar night")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Schema layouts.
+const (
+	// SchemaLegacy embeds the CT line or device serial in the measurement
+	// name (production-line0, inverter-production-<serial>) and uses the
+	// short CT field names (P, Q, S, I_rms, V_rms).
+	SchemaLegacy = "legacy"
+	// SchemaNormalized uses one measurement per family, with the line and
+	// serial as tags only, and descriptive field names with units.
+	SchemaNormalized = "normalized"
+)
+
+// Point families; each extract function produces one.
+const (
+	FamilySnapshot = "energy-snapshot"
+	FamilyMeter    = "meter"
+	FamilyInverter = "inverter"
+	FamilyBattery  = "battery"
+	FamilyEnergy   = "energy"
+	FamilyDevice   = "device"
+	FamilyGateway  = "gateway"
+	FamilyEvent    = "event"
+	FamilyEnsemble = "ensemble"
+
+	FamilyDryContact       = "dry-contact"
+	FamilyBatteryAnalytics = "battery-analytics"
+	FamilyClipping         = "clipping"
+	FamilyClippingDaily    = "clipping-daily"
+	FamilyPerformanceDaily = "performance-daily"
+)
+
+// normalizedFields renames fields in the normalized layout, per family.
+var normalizedFields = map[string]map[string]string{
+	FamilyMeter: {
+		FieldP:    "active_power_w",
+		FieldQ:    "reactive_power_var",
+		FieldS:    "apparent_power_va",
+		FieldIrms: "current_a",
+		FieldVrms: "voltage_v",
+	},
+	FamilyInverter: {
+		FieldP: "power_w",
+	},
+}
+
+// reservedTags are set by the exporter itself and cannot be static tags.
+var reservedTags = []string{
+	TagSource, TagMeasurementType, TagLineIdx, TagSerial, TagPhase, TagMeter,
+	TagDeviceType, TagFirmware, TagPartNumber, TagSoftware, TagCompatibility, TagEvent,
+	TagContact, TagSuspect,
+	TagArray, TagRoofFace, TagAzimuth, TagTilt, TagPanelModel, TagInverterModel,
+}
+
+// Schema decides how readings are laid out as points: measurement names,
+// field names and tags. The zero value (with Source set) is the legacy
+// layout without templates.
+type Schema struct {
+	Layout  string                    // SchemaLegacy (default) or SchemaNormalized
+	Source  string                    // value of the source tag on every point
+	Tags    map[string]string         // static tags on every point
+	Devices map[string]DeviceMetadata // tags for device points, by serial
+
+	measurementTmpl *template.Template
+	fieldTmpl       *template.Template
+}
+
+// pointKey describes what a point holds, independent of the layout. It is
+// also the data passed to the measurement template.
+type pointKey struct {
+	Family string // FamilySnapshot, FamilyMeter, ...
+	Type   string // CT measurement type, e.g. production
+	Serial string // device serial, if any
+	Line   string // CT line index, if any
+	Legacy string // legacy measurement name
+	Name   string // measurement name in the configured layout, before the template
+}
+
+// fieldKey is the data passed to the field template.
+type fieldKey struct {
+	Family string
+	Key    string // field key in the legacy layout
+	Name   string // field name in the configured layout, before the template
+}
+
+// NewSchema validates the layout and parses the optional templates.
+// measurementTemplate and fieldTemplate are text/template strings evaluated
+// with a pointKey and fieldKey respectively, e.g. "envoy_{{.Family}}".
+func NewSchema(layout, source, measurementTemplate, fieldTemplate string) (*Schema, error) {
+	s := &Schema{Layout: layout, Source: source}
+	switch layout {
+	case "", SchemaLegacy, SchemaNormalized:
+	default:
+		return nil, fmt.Errorf("unknown schema %q: use %s or %s", layout, SchemaLegacy, SchemaNormalized)
+	}
+	var err error
+	if measurementTemplate != "" {
+		if s.measurementTmpl, err = template.New("measurement").Option("missingkey=error").Parse(measurementTemplate); err != nil {
+			return nil, fmt.Errorf("parse measurement_template: %w", err)
+		}
+		if _, err := s.render(s.measurementTmpl, pointKey{Family: FamilyInverter, Serial: "0", Name: FamilyInverter}); err != nil {
+			return nil, fmt.Errorf("measurement_template: %w", err)
+		}
+	}
+	if fieldTemplate != "" {
+		if s.fieldTmpl, err = template.New("field").Option("missingkey=error").Parse(fieldTemplate); err != nil {
+			return nil, fmt.Errorf("parse field_template: %w", err)
+		}
+		if _, err := s.render(s.fieldTmpl, fieldKey{Family: FamilyMeter, Key: FieldP, Name: FieldP}); err != nil {
+			return nil, fmt.Errorf("field_template: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// schemaFromConfig builds the Schema described by cfg, including its tags.
+func schemaFromConfig(cfg *Config) (*Schema, error) {
+	s, err := NewSchema(cfg.Schema, cfg.SourceTag, cfg.MeasurementTemplate, cfg.FieldTemplate)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cfg.Tags {
+		if k == "" || v == "" {
+			return nil, fmt.Errorf("tags: empty key or value in %q: %q", k, v)
+		}
+		if slices.Contains(reservedTags, k) {
+			return nil, fmt.Errorf("tags: %q is set by the exporter", k)
+		}
+	}
+	s.Tags = cfg.Tags
+	s.Devices = cfg.Devices
+	return s, nil
+}
+
+func (s *Schema) normalized() bool { return s.Layout == SchemaNormalized }
+
+func (s *Schema) render(t *template.Template, data any) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	out := strings.TrimSpace(sb.String())
+	if out == "" {
+		return "", fmt.Errorf("template %s produced an empty name", t.Name())
+	}
+	return out, nil
+}
+
+// measurement returns the measurement name for k. A template that fails
+// for a particular point falls back to the layout name.
+func (s *Schema) measurement(k pointKey) string {
+	k.Name = k.Legacy
+	if s.normalized() {
+		k.Name = k.Family
+	}
+	if s.measurementTmpl == nil {
+		return k.Name
+	}
+	name, err := s.render(s.measurementTmpl, k)
+	if err != nil {
+		return k.Name
+	}
+	return name
+}
+
+// field returns the field name for key in family.
+func (s *Schema) field(family, key string) string {
+	name := key
+	if s.normalized() {
+		if n, ok := normalizedFields[family][key]; ok {
+			name = n
+		}
+	}
+	if s.fieldTmpl == nil {
+		return name
+	}
+	out, err := s.render(s.fieldTmpl, fieldKey{Family: family, Key: key, Name: name})
+	if err != nil {
+		return name
+	}
+	return out
+}
+
+// newPoint starts a point for k with the source tag, static tags, the
+// device's metadata tags (for points with a serial) and the timestamp set.
+func (s *Schema) newPoint(k pointKey, t time.Time) *influxdb2write.Point {
+	pt := influxdb2.NewPointWithMeasurement(s.measurement(k)).
+		AddTag(TagSource, s.Source).
+		SetTime(t)
+	for _, key := range slices.Sorted(maps.Keys(s.Tags)) {
+		pt.AddTag(key, s.Tags[key])
+	}
+	if d, ok := s.Devices[k.Serial]; ok && k.Serial != "" {
+		d.addTags(pt)
+	}
+	return pt
+}
+
+// addTags writes the set metadata fields as tags.
+func (d DeviceMetadata) addTags(pt *influxdb2write.Point) {
+	if d.Array != "" {
+		pt.AddTag(TagArray, d.Array)
+	}
+	if d.RoofFace != "" {
+		pt.AddTag(TagRoofFace, d.RoofFace)
+	}
+	if d.Azimuth != nil {
+		pt.AddTag(TagAzimuth, strconv.FormatFloat(*d.Azimuth, 'f', -1, 64))
+	}
+	if d.Tilt != nil {
+		pt.AddTag(TagTilt, strconv.FormatFloat(*d.Tilt, 'f', -1, 64))
+	}
+	if d.PanelModel != "" {
+		pt.AddTag(TagPanelModel, d.PanelModel)
+	}
+	if d.InverterModel != "" {
+		pt.AddTag(TagInverterModel, d.InverterModel)
+	}
+}
+
+// renameFields applies the schema's field names to pt, whose fields were
+// added under their legacy keys.
+func (s *Schema) renameFields(family string, pt *influxdb2write.Point) *influxdb2write.Point {
+	if !s.normalized() && s.fieldTmpl == nil {
+		return pt
+	}
+	for _, f := range pt.FieldList() {
+		f.Key = s.field(family, f.Key)
+	}
+	return pt
+}
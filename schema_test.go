@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fieldKeys(pt *influxdb2write.Point) []string {
+	var keys []string
+	for _, f := range pt.FieldList() {
+		keys = append(keys, f.Key)
+	}
+	return keys
+}
+
+func tagValue(pt *influxdb2write.Point, key string) string {
+	for _, tag := range pt.TagList() {
+		if tag.Key == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+func schemaTestPoints(s *Schema) []*influxdb2write.Point {
+	now := time.Now()
+	pts := extractLiveDataPoints(makeLiveData(1000000, 0, 0, 1000000), s, nil, now)
+	pts = append(pts, extractCTPoints([]gateway.TypedCTReading{{
+		CTReading:       gateway.CTReading{Channels: []gateway.CTChannel{{ActivePower: 1}, {ActivePower: 2}}},
+		MeasurementType: MeasurementNetConsumption,
+	}}, s, now)...)
+	pts = append(pts, extractInverterPoints([]gateway.InverterReading{{SerialNumber: "INV1", LastReportWatts: 250}}, nil, s, now)...)
+	pts = append(pts, extractBatteryPoints([]gateway.BatteryStatus{{SerialNum: "BAT1"}}, s, now)...)
+	return pts
+}
+
+func TestSchema_Legacy(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewSchema("", "home", "", "")
+	require.NoError(t, err)
+	pts := schemaTestPoints(s)
+	require.Len(t, pts, 5)
+	assert.Equal(t, "energy-snapshot", pts[0].Name())
+	assert.Equal(t, "net-line1", pts[2].Name())
+	assert.Equal(t, []string{FieldP, FieldQ, FieldS, FieldIrms, FieldVrms}, fieldKeys(pts[2]))
+	assert.Equal(t, "inverter-production-INV1", pts[3].Name())
+	assert.Equal(t, "battery-BAT1", pts[4].Name())
+}
+
+func TestSchema_Normalized(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewSchema(SchemaNormalized, "home", "", "")
+	require.NoError(t, err)
+	pts := schemaTestPoints(s)
+	require.Len(t, pts, 5)
+
+	assert.Equal(t, "energy-snapshot", pts[0].Name())
+	assert.Equal(t, "meter", pts[1].Name())
+	assert.Equal(t, "meter", pts[2].Name(), "lines share one measurement")
+	assert.Equal(t, "1", tagValue(pts[2], TagLineIdx))
+	assert.Equal(t, MeasurementNetConsumption, tagValue(pts[2], TagMeasurementType))
+	assert.Equal(t, []string{"active_power_w", "reactive_power_var", "apparent_power_va", "current_a", "voltage_v"}, fieldKeys(pts[2]))
+
+	assert.Equal(t, "inverter", pts[3].Name())
+	assert.Equal(t, "INV1", tagValue(pts[3], TagSerial))
+	assert.Equal(t, []string{"power_w"}, fieldKeys(pts[3]))
+
+	assert.Equal(t, "battery", pts[4].Name())
+	assert.Equal(t, "home", tagValue(pts[4], TagSource))
+}
+
+func TestSchema_Templates(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewSchema(SchemaNormalized, "home", "envoy_{{.Name}}", "{{.Family}}.{{.Name}}")
+	require.NoError(t, err)
+	pts := schemaTestPoints(s)
+
+	assert.Equal(t, "envoy_meter", pts[1].Name())
+	assert.Equal(t, "meter.active_power_w", fieldKeys(pts[1])[0])
+	assert.Equal(t, "envoy_inverter", pts[3].Name())
+
+	// Legacy names and device data are available to templates too.
+	s, err = NewSchema(SchemaLegacy, "home", "{{if .Serial}}device_{{.Serial}}{{else}}{{.Legacy}}{{end}}", "")
+	require.NoError(t, err)
+	pts = schemaTestPoints(s)
+	assert.Equal(t, "energy-snapshot", pts[0].Name())
+	assert.Equal(t, "net-line0", pts[1].Name())
+	assert.Equal(t, "device_INV1", pts[3].Name())
+}
+
+func TestNewSchema_Errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewSchema("flat", "", "", "")
+	assert.Error(t, err, "unknown layout")
+	_, err = NewSchema("", "", "{{.Family", "")
+	assert.Error(t, err, "unparsable template")
+	_, err = NewSchema("", "", "{{.Nope}}", "")
+	assert.Error(t, err, "unknown template field")
+	_, err = NewSchema("", "", "", "{{if false}}x{{end}}")
+	assert.Error(t, err, "empty names are rejected")
+}
+
+func TestSchema_Tags(t *testing.T) {
+	t.Parallel()
+
+	azimuth, tilt := 180.0, 22.5
+	cfg := &Config{
+		SourceTag: "home",
+		Tags:      map[string]string{"site": "cabin", "owner": "sam"},
+		Devices: map[string]DeviceMetadata{
+			"INV1": {Array: "south-1", RoofFace: "south", Azimuth: &azimuth, Tilt: &tilt, PanelModel: "REC 405"},
+			"BAT1": {Array: "garage"},
+		},
+	}
+	s, err := schemaFromConfig(cfg)
+	require.NoError(t, err)
+	pts := schemaTestPoints(s)
+
+	for _, pt := range pts {
+		assert.Equal(t, "cabin", tagValue(pt, "site"), pt.Name())
+		assert.Equal(t, "sam", tagValue(pt, "owner"), pt.Name())
+		assert.Equal(t, "home", tagValue(pt, TagSource), pt.Name())
+	}
+	assert.Empty(t, tagValue(pts[1], TagArray), "meters have no device metadata")
+
+	inv := pts[3]
+	assert.Equal(t, "south-1", tagValue(inv, TagArray))
+	assert.Equal(t, "south", tagValue(inv, TagRoofFace))
+	assert.Equal(t, "180", tagValue(inv, TagAzimuth))
+	assert.Equal(t, "22.5", tagValue(inv, TagTilt))
+	assert.Equal(t, "REC 405", tagValue(inv, TagPanelModel))
+
+	bat := pts[4]
+	assert.Equal(t, "garage", tagValue(bat, TagArray))
+	assert.Empty(t, tagValue(bat, TagTilt), "unset metadata is not tagged")
+}
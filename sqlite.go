@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	_ "modernc.org/sqlite" // pure-Go driver; the Docker image is built with CGO_ENABLED=0
+)
+
+// sqliteSchema is the normalized storage layout for the embedded sink.
+//
+// A series is one measurement plus its tag set; series_tags repeats the tags
+// as rows so they can be filtered in SQL. Each field of a series gets a row in
+// fields, and raw samples reference the field. Numeric and boolean values are
+// stored in samples.value (booleans as 0/1); strings go to samples.text and are
+// not rolled up.
+//
+// The rollup tables hold one row per field per window. mean is sum/count.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS series (
+	id          INTEGER PRIMARY KEY,
+	measurement TEXT NOT NULL,
+	tags        TEXT NOT NULL,
+	UNIQUE (measurement, tags)
+);
+CREATE TABLE IF NOT EXISTS series_tags (
+	series_id INTEGER NOT NULL REFERENCES series(id),
+	key       TEXT NOT NULL,
+	value     TEXT NOT NULL,
+	PRIMARY KEY (series_id, key)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS fields (
+	id        INTEGER PRIMARY KEY,
+	series_id INTEGER NOT NULL REFERENCES series(id),
+	name      TEXT NOT NULL,
+	UNIQUE (series_id, name)
+);
+CREATE TABLE IF NOT EXISTS samples (
+	field_id INTEGER NOT NULL REFERENCES fields(id),
+	ts       INTEGER NOT NULL,
+	value    REAL,
+	text     TEXT,
+	PRIMARY KEY (field_id, ts)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS samples_ts ON samples (ts);
+CREATE TABLE IF NOT EXISTS rollup_5m (
+	field_id INTEGER NOT NULL REFERENCES fields(id),
+	bucket   INTEGER NOT NULL,
+	count    INTEGER NOT NULL,
+	sum      REAL NOT NULL,
+	min      REAL NOT NULL,
+	max      REAL NOT NULL,
+	last     REAL NOT NULL,
+	last_ts  INTEGER NOT NULL,
+	PRIMARY KEY (field_id, bucket)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS rollup_5m_bucket ON rollup_5m (bucket);
+CREATE TABLE IF NOT EXISTS rollup_1h (
+	field_id INTEGER NOT NULL REFERENCES fields(id),
+	bucket   INTEGER NOT NULL,
+	count    INTEGER NOT NULL,
+	sum      REAL NOT NULL,
+	min      REAL NOT NULL,
+	max      REAL NOT NULL,
+	last     REAL NOT NULL,
+	last_ts  INTEGER NOT NULL,
+	PRIMARY KEY (field_id, bucket)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS rollup_1h_bucket ON rollup_1h (bucket);
+`
+
+// sqliteRollups lists the rollup tables and their window sizes.
+var sqliteRollups = []struct {
+	table  string
+	window time.Duration
+}{
+	{"rollup_5m", 5 * time.Minute},
+	{"rollup_1h", time.Hour},
+}
+
+// SQLiteRetention controls how long each table keeps data. Zero keeps data forever.
+type SQLiteRetention struct {
+	Raw      time.Duration
+	Rollup5m time.Duration
+	Rollup1h time.Duration
+}
+
+// SQLiteSink writes points to an embedded SQLite database. It implements PointWriter.
+type SQLiteSink struct {
+	db        *sql.DB
+	retention SQLiteRetention
+
+	mu       sync.Mutex
+	seriesID map[string]int64 // measurement + canonical tags → series.id
+	fieldID  map[string]int64 // series.id + field name → fields.id
+}
+
+// OpenSQLiteSink opens (creating if needed) the database at path and applies the schema.
+func OpenSQLiteSink(path string, retention SQLiteRetention) (*SQLiteSink, error) {
+	dsn := "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database: %w", err)
+	}
+	// A single connection serialises writers; SQLite allows only one at a time anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create sqlite schema: %w", err)
+	}
+	return &SQLiteSink{
+		db:        db,
+		retention: retention,
+		seriesID:  make(map[string]int64),
+		fieldID:   make(map[string]int64),
+	}, nil
+}
+
+// Close closes the underlying database.
+func (s *SQLiteSink) Close() error {
+	return s.db.Close()
+}
+
+// WritePoint stores points and folds numeric values into the rollup tables.
+// All points are written in a single transaction.
+func (s *SQLiteSink) WritePoint(ctx context.Context, points ...*influxdb2write.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin sqlite transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }() // no-op after a successful commit
+
+	// Ids allocated inside the transaction are only cached once it commits.
+	newSeries := make(map[string]int64)
+	newFields := make(map[string]int64)
+
+	for _, pt := range points {
+		sid, err := s.series(ctx, tx, pt, newSeries)
+		if err != nil {
+			return err
+		}
+		ts := pt.Time()
+		for _, f := range pt.FieldList() {
+			fid, err := s.field(ctx, tx, sid, f.Key, newFields)
+			if err != nil {
+				return err
+			}
+			if err := insertSample(ctx, tx, fid, ts, f.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit sqlite transaction: %w", err)
+	}
+	for k, v := range newSeries {
+		s.seriesID[k] = v
+	}
+	for k, v := range newFields {
+		s.fieldID[k] = v
+	}
+	return nil
+}
+
+// series returns the id of the series for pt, creating it if necessary.
+func (s *SQLiteSink) series(ctx context.Context, tx *sql.Tx, pt *influxdb2write.Point, pending map[string]int64) (int64, error) {
+	tags := canonicalTags(pt)
+	key := pt.Name() + "\x00" + tags
+	if id, ok := s.seriesID[key]; ok {
+		return id, nil
+	}
+	if id, ok := pending[key]; ok {
+		return id, nil
+	}
+
+	var id int64
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO series (measurement, tags) VALUES (?, ?)
+		 ON CONFLICT (measurement, tags) DO UPDATE SET tags = excluded.tags
+		 RETURNING id`, pt.Name(), tags).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert series %s: %w", pt.Name(), err)
+	}
+	for _, tag := range pt.TagList() {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO series_tags (series_id, key, value) VALUES (?, ?, ?)`,
+			id, tag.Key, tag.Value); err != nil {
+			return 0, fmt.Errorf("insert series tag %s: %w", tag.Key, err)
+		}
+	}
+	pending[key] = id
+	return id, nil
+}
+
+// field returns the id of the named field within a series, creating it if necessary.
+func (s *SQLiteSink) field(ctx context.Context, tx *sql.Tx, seriesID int64, name string, pending map[string]int64) (int64, error) {
+	key := fmt.Sprintf("%d\x00%s", seriesID, name)
+	if id, ok := s.fieldID[key]; ok {
+		return id, nil
+	}
+	if id, ok := pending[key]; ok {
+		return id, nil
+	}
+
+	var id int64
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO fields (series_id, name) VALUES (?, ?)
+		 ON CONFLICT (series_id, name) DO UPDATE SET name = excluded.name
+		 RETURNING id`, seriesID, name).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert field %s: %w", name, err)
+	}
+	pending[key] = id
+	return id, nil
+}
+
+// insertSample stores one raw value. Numeric values are also added to every
+// rollup window; a duplicate (field, timestamp) is ignored so rollups are not
+// double-counted.
+func insertSample(ctx context.Context, tx *sql.Tx, fieldID int64, ts time.Time, v any) error {
+	num, isNum := fieldFloat(v)
+	var value, text any
+	if isNum {
+		value = num
+	} else {
+		text = fmt.Sprint(v)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT OR IGNORE INTO samples (field_id, ts, value, text) VALUES (?, ?, ?, ?)`,
+		fieldID, ts.UnixMilli(), value, text)
+	if err != nil {
+		return fmt.Errorf("insert sample: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 || !isNum {
+		return nil
+	}
+
+	for _, r := range sqliteRollups {
+		bucket := ts.Truncate(r.window).Unix()
+		_, err := tx.ExecContext(ctx, `INSERT INTO `+r.table+` (field_id, bucket, count, sum, min, max, last, last_ts)
+			VALUES (?, ?, 1, ?, ?, ?, ?, ?)
+			ON CONFLICT (field_id, bucket) DO UPDATE SET
+				count   = count + 1,
+				sum     = sum + excluded.sum,
+				min     = min(min, excluded.min),
+				max     = max(max, excluded.max),
+				last    = CASE WHEN excluded.last_ts >= last_ts THEN excluded.last ELSE last END,
+				last_ts = max(last_ts, excluded.last_ts)`,
+			fieldID, bucket, num, num, num, num, ts.UnixMilli())
+		if err != nil {
+			return fmt.Errorf("update %s: %w", r.table, err)
+		}
+	}
+	return nil
+}
+
+// Prune deletes rows older than the configured retention periods, measured from now.
+func (s *SQLiteSink) Prune(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.retention.Raw > 0 {
+		cutoff := now.Add(-s.retention.Raw).UnixMilli()
+		res, err := s.db.ExecContext(ctx, `DELETE FROM samples WHERE ts < ?`, cutoff)
+		if err != nil {
+			return fmt.Errorf("prune samples: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		slog.Debug("Pruned SQLite samples", "rows", n)
+	}
+	for _, r := range []struct {
+		table     string
+		retention time.Duration
+	}{
+		{"rollup_5m", s.retention.Rollup5m},
+		{"rollup_1h", s.retention.Rollup1h},
+	} {
+		if r.retention <= 0 {
+			continue
+		}
+		cutoff := now.Add(-r.retention).Unix()
+		res, err := s.db.ExecContext(ctx, `DELETE FROM `+r.table+` WHERE bucket < ?`, cutoff)
+		if err != nil {
+			return fmt.Errorf("prune %s: %w", r.table, err)
+		}
+		n, _ := res.RowsAffected()
+		slog.Debug("Pruned SQLite rollups", "table", r.table, "rows", n)
+	}
+	return nil
+}
+
+// RunRetention prunes old data immediately and then every interval until ctx is cancelled.
+func (s *SQLiteSink) RunRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Prune(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Error("SQLite retention pruning failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// canonicalTags renders a point's tags as a sorted "k=v,k=v" string so that
+// the same tag set always maps to the same series regardless of insertion order.
+func canonicalTags(pt *influxdb2write.Point) string {
+	pairs := make([]string, 0, len(pt.TagList()))
+	for _, tag := range pt.TagList() {
+		pairs = append(pairs, tag.Key+"="+tag.Value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestSQLiteSink(t *testing.T, retention SQLiteRetention) *SQLiteSink {
+	t.Helper()
+	sink, err := OpenSQLiteSink(filepath.Join(t.TempDir(), "envoy.db"), retention)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sink.Close() })
+	return sink
+}
+
+func TestSQLiteSink_WritePointNormalizes(t *testing.T) {
+	t.Parallel()
+	sink := openTestSQLiteSink(t, SQLiteRetention{})
+	ctx := context.Background()
+
+	ts := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	batteries := extractBatteryPoints([]gateway.BatteryStatus{{SerialNum: "BAT1", PercentFull: 80, GridMode: "on-grid"}}, &Schema{Source: "home"}, ts)
+	require.NoError(t, sink.WritePoint(ctx, batteries...))
+	// A second scrape of the same series reuses the series and field rows.
+	later := extractBatteryPoints([]gateway.BatteryStatus{{SerialNum: "BAT1", PercentFull: 81, GridMode: "on-grid"}}, &Schema{Source: "home"}, ts.Add(time.Minute))
+	require.NoError(t, sink.WritePoint(ctx, later...))
+
+	var series, fields, samples int
+	require.NoError(t, sink.db.QueryRow(`SELECT count(*) FROM series`).Scan(&series))
+	require.NoError(t, sink.db.QueryRow(`SELECT count(*) FROM fields`).Scan(&fields))
+	require.NoError(t, sink.db.QueryRow(`SELECT count(*) FROM samples`).Scan(&samples))
+	assert.Equal(t, 1, series)
+	assert.Equal(t, 6, fields)
+	assert.Equal(t, 12, samples)
+
+	var serial string
+	require.NoError(t, sink.db.QueryRow(`SELECT value FROM series_tags WHERE key = 'serial'`).Scan(&serial))
+	assert.Equal(t, "BAT1", serial)
+
+	var text string
+	require.NoError(t, sink.db.QueryRow(
+		`SELECT s.text FROM samples s JOIN fields f ON f.id = s.field_id WHERE f.name = 'grid_mode' LIMIT 1`).Scan(&text))
+	assert.Equal(t, "on-grid", text)
+}
+
+func TestSQLiteSink_Rollups(t *testing.T) {
+	t.Parallel()
+	sink := openTestSQLiteSink(t, SQLiteRetention{})
+	ctx := context.Background()
+
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, w := range []float64{100, 300, 200} {
+		pt := influxdb2.NewPointWithMeasurement("m").AddField("w", w).SetTime(base.Add(time.Duration(i) * time.Minute))
+		require.NoError(t, sink.WritePoint(ctx, pt))
+	}
+	// Rewriting an existing timestamp must not be double-counted.
+	dup := influxdb2.NewPointWithMeasurement("m").AddField("w", 999.0).SetTime(base)
+	require.NoError(t, sink.WritePoint(ctx, dup))
+	// Next 5-minute window.
+	next := influxdb2.NewPointWithMeasurement("m").AddField("w", 50.0).SetTime(base.Add(6 * time.Minute))
+	require.NoError(t, sink.WritePoint(ctx, next))
+
+	var count int
+	var sum, lo, hi, last float64
+	require.NoError(t, sink.db.QueryRow(
+		`SELECT count, sum, min, max, last FROM rollup_5m WHERE bucket = ?`, base.Unix()).Scan(&count, &sum, &lo, &hi, &last))
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 600.0, sum)
+	assert.Equal(t, 100.0, lo)
+	assert.Equal(t, 300.0, hi)
+	assert.Equal(t, 200.0, last)
+
+	var windows5m, windows1h int
+	require.NoError(t, sink.db.QueryRow(`SELECT count(*) FROM rollup_5m`).Scan(&windows5m))
+	require.NoError(t, sink.db.QueryRow(`SELECT count, max FROM rollup_1h`).Scan(&windows1h, &hi))
+	assert.Equal(t, 2, windows5m)
+	assert.Equal(t, 4, windows1h, "hourly window holds every sample")
+	assert.Equal(t, 300.0, hi)
+}
+
+func TestSQLiteSink_Prune(t *testing.T) {
+	t.Parallel()
+	sink := openTestSQLiteSink(t, SQLiteRetention{Raw: 24 * time.Hour, Rollup5m: 48 * time.Hour})
+	ctx := context.Background()
+
+	now := time.Date(2026, 6, 10, 0, 0, 0, 0, time.UTC)
+	old := influxdb2.NewPointWithMeasurement("m").AddField("w", 1.0).SetTime(now.Add(-36 * time.Hour))
+	recent := influxdb2.NewPointWithMeasurement("m").AddField("w", 2.0).SetTime(now.Add(-time.Hour))
+	require.NoError(t, sink.WritePoint(ctx, old, recent))
+
+	require.NoError(t, sink.Prune(ctx, now))
+
+	var samples, windows5m, windows1h int
+	require.NoError(t, sink.db.QueryRow(`SELECT count(*) FROM samples`).Scan(&samples))
+	require.NoError(t, sink.db.QueryRow(`SELECT count(*) FROM rollup_5m`).Scan(&windows5m))
+	require.NoError(t, sink.db.QueryRow(`SELECT count(*) FROM rollup_1h`).Scan(&windows1h))
+	assert.Equal(t, 1, samples, "raw sample older than 24h is pruned")
+	assert.Equal(t, 2, windows5m, "5m rollups are kept for 48h")
+	assert.Equal(t, 2, windows1h, "zero retention keeps hourly rollups forever")
+}
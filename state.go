@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+)
+
+// latest holds the most recent successful readings from each gateway endpoint.
+// scrape updates it; the HTTP handlers read it. Like the expvar metrics it is
+// process-wide because there is exactly one scrape loop per process.
+var latest = &scrapeStore{}
+
+// scrapeStore is a concurrency-safe record of the last successful readings and
+// the outcome of the last scrape. Each family carries its own timestamp because
+// endpoints can fail independently.
+type scrapeStore struct {
+	mu sync.RWMutex
+
+	snapshot   gateway.EnergySnapshot
+	snapshotAt time.Time
+
+	inverters   []gateway.InverterReading
+	invertersAt time.Time
+
+	batteries   []gateway.BatteryStatus
+	batteriesAt time.Time
+
+	meters   []gateway.TypedCTReading
+	metersAt time.Time
+
+	lastScrape       time.Time
+	lastScrapeDur    time.Duration
+	lastScrapePoints int
+	lastScrapeErr    bool
+
+	subs map[chan storeView]struct{} // notified after every scrape
+}
+
+// storeView is an immutable copy of a scrapeStore taken under its lock.
+type storeView struct {
+	Snapshot   gateway.EnergySnapshot
+	SnapshotAt time.Time
+
+	Inverters   []gateway.InverterReading
+	InvertersAt time.Time
+
+	Batteries   []gateway.BatteryStatus
+	BatteriesAt time.Time
+
+	Meters   []gateway.TypedCTReading
+	MetersAt time.Time
+
+	LastScrape       time.Time
+	LastScrapeDur    time.Duration
+	LastScrapePoints int
+	LastScrapeErr    bool
+}
+
+func (s *scrapeStore) setSnapshot(snap gateway.EnergySnapshot, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot, s.snapshotAt = snap, t
+}
+
+func (s *scrapeStore) setInverters(inv []gateway.InverterReading, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inverters, s.invertersAt = inv, t
+}
+
+func (s *scrapeStore) setBatteries(b []gateway.BatteryStatus, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batteries, s.batteriesAt = b, t
+}
+
+func (s *scrapeStore) setMeters(m []gateway.TypedCTReading, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meters, s.metersAt = m, t
+}
+
+// setResult records the outcome of a scrape and notifies subscribers.
+// A subscriber whose buffer is full is considered too slow: it is dropped and
+// its channel closed rather than letting it hold up the scrape loop.
+func (s *scrapeStore) setResult(t time.Time, dur time.Duration, r scrapeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastScrape, s.lastScrapeDur = t, dur
+	s.lastScrapePoints, s.lastScrapeErr = r.points, r.hasErr
+
+	if len(s.subs) == 0 {
+		return
+	}
+	v := s.viewLocked()
+	for ch := range s.subs {
+		select {
+		case ch <- v:
+		default:
+			delete(s.subs, ch)
+			close(ch)
+			metricStreamDropped.Add(1)
+		}
+	}
+}
+
+// subscribe registers for a storeView after every scrape. buf bounds how many
+// undelivered views may queue before the subscriber is dropped. The returned
+// channel is closed when the subscriber is dropped or cancel is called.
+func (s *scrapeStore) subscribe(buf int) (<-chan storeView, func()) {
+	ch := make(chan storeView, buf)
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[chan storeView]struct{})
+	}
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// view returns a copy of the store. Slices are shared but never mutated after
+// being stored, so callers may read them freely.
+func (s *scrapeStore) view() storeView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.viewLocked()
+}
+
+func (s *scrapeStore) viewLocked() storeView {
+	return storeView{
+		Snapshot:         s.snapshot,
+		SnapshotAt:       s.snapshotAt,
+		Inverters:        s.inverters,
+		InvertersAt:      s.invertersAt,
+		Batteries:        s.batteries,
+		BatteriesAt:      s.batteriesAt,
+		Meters:           s.meters,
+		MetersAt:         s.metersAt,
+		LastScrape:       s.lastScrape,
+		LastScrapeDur:    s.lastScrapeDur,
+		LastScrapePoints: s.lastScrapePoints,
+		LastScrapeErr:    s.lastScrapeErr,
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+)
+
+// Gateway endpoint names used for per-endpoint status.
+const (
+	EndpointLiveData  = "livedata"
+	EndpointMeters    = "meters"
+	EndpointInverters = "inverters"
+	EndpointBatteries = "batteries"
+	EndpointEnergy    = "energy"
+
+	EndpointInverterDetails = "inverter_details"
+	EndpointInventory       = "inventory"
+	EndpointInfo            = "info"
+	EndpointEnsemble        = "ensemble"
+)
+
+// Component states reported by /status.
+const (
+	StateUnknown      = "unknown"       // never attempted
+	StateOK           = "ok"            // last attempt succeeded
+	StateError        = "error"         // last attempt failed
+	StateNotInstalled = "not_installed" // gateway answered 404; hardware absent
+)
+
+// components tracks the health of every part of the exporter for /status and
+// /readyz. Like latest it is process-wide.
+var components = newComponentTracker()
+
+// componentStatus is the last known outcome for one component.
+type componentStatus struct {
+	State               string    `json:"state"`
+	LastSuccess         time.Time `json:"last_success,omitzero"`
+	LastFailure         time.Time `json:"last_failure,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+func (c *componentStatus) record(err error, now time.Time) {
+	if err == nil {
+		c.State = StateOK
+		c.LastSuccess = now
+		c.ConsecutiveFailures = 0
+		return
+	}
+	c.State = StateError
+	c.LastFailure = now
+	c.LastError = err.Error()
+	c.ConsecutiveFailures++
+}
+
+// componentTracker is a concurrency-safe registry of component status.
+type componentTracker struct {
+	mu        sync.RWMutex
+	started   time.Time
+	gateway   componentStatus
+	jwtExpiry time.Time
+	jwtAuto   bool // credentials configured, so the JWT is refreshed automatically
+	jwt       componentStatus
+	sinks     map[string]*componentStatus
+	endpoints map[string]*componentStatus
+	info      *GatewayInfo
+}
+
+func newComponentTracker() *componentTracker {
+	return &componentTracker{
+		started:   time.Now(),
+		gateway:   componentStatus{State: StateUnknown},
+		jwt:       componentStatus{State: StateUnknown},
+		sinks:     make(map[string]*componentStatus),
+		endpoints: make(map[string]*componentStatus),
+	}
+}
+
+// recordGateway records the outcome of a gateway connection attempt.
+func (t *componentTracker) recordGateway(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gateway.record(err, time.Now())
+}
+
+// setGatewayInfo records the gateway's identification and firmware.
+func (t *componentTracker) setGatewayInfo(info GatewayInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.info = &info
+}
+
+// setJWT records the expiry of the current JWT and whether it will be refreshed.
+func (t *componentTracker) setJWT(expiry time.Time, autoRefresh bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jwtExpiry, t.jwtAuto = expiry, autoRefresh
+}
+
+// jwtExpiryTime returns the expiry of the current JWT, or the zero time if unknown.
+func (t *componentTracker) jwtExpiryTime() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.jwtExpiry
+}
+
+// recordJWTRefresh records the outcome of a JWT refresh attempt.
+func (t *componentTracker) recordJWTRefresh(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jwt.record(err, time.Now())
+}
+
+// recordSink records the outcome of a write to the named sink.
+func (t *componentTracker) recordSink(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.sinks[name]
+	if !ok {
+		c = &componentStatus{}
+		t.sinks[name] = c
+	}
+	c.record(err, time.Now())
+}
+
+// recordEndpoint records the outcome of a gateway endpoint fetch. A 404 means
+// the hardware behind the endpoint is not installed: it is not a failure, but
+// no data was read either, so it is not a success.
+func (t *componentTracker) recordEndpoint(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.endpoints[name]
+	if !ok {
+		c = &componentStatus{}
+		t.endpoints[name] = c
+	}
+	if gateway.IsNotFound(err) {
+		c.State = StateNotInstalled
+		c.ConsecutiveFailures = 0
+		return
+	}
+	c.record(err, time.Now())
+}
+
+// lastLiveDataSuccess is the most recent successful read of live data, the
+// endpoint every scrape depends on.
+func (t *componentTracker) lastLiveDataSuccess() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if c, ok := t.endpoints[EndpointLiveData]; ok {
+		return c.LastSuccess
+	}
+	return time.Time{}
+}
+
+// ready reports whether the exporter is serving fresh gateway data: live
+// data must have been read successfully within staleAfter. Other endpoints
+// and sink failures do not affect readiness.
+func (t *componentTracker) ready(now time.Time, staleAfter time.Duration) (bool, string) {
+	last := t.lastLiveDataSuccess()
+	if last.IsZero() {
+		return false, "no successful live data read yet"
+	}
+	if now.Sub(last) > staleAfter {
+		return false, "last successful live data read was too long ago"
+	}
+	return true, "ok"
+}
+
+// exporterStatus is the /status document.
+type exporterStatus struct {
+	APIVersion      string                     `json:"api_version"`
+	GeneratedAt     time.Time                  `json:"generated_at"`
+	Ready           bool                       `json:"ready"`
+	ReadyReason     string                     `json:"ready_reason"`
+	UptimeSeconds   float64                    `json:"uptime_seconds"`
+	StaleAfterS     float64                    `json:"staleness_threshold_seconds"`
+	Gateway         componentStatus            `json:"gateway"`
+	GatewayInfo     *GatewayInfo               `json:"gateway_info,omitempty"`
+	JWT             jwtStatus                  `json:"jwt"`
+	Sinks           map[string]componentStatus `json:"sinks"`
+	Endpoints       map[string]componentStatus `json:"endpoints"`
+	LastScrape      time.Time                  `json:"last_scrape,omitzero"`
+	LastScrapeMS    int64                      `json:"last_scrape_duration_ms"`
+	LastScrapeError bool                       `json:"last_scrape_had_errors"`
+	Schedule        *scheduleStatus            `json:"schedule,omitempty"`
+}
+
+type jwtStatus struct {
+	componentStatus
+	Valid           bool      `json:"valid"`
+	ExpiresAt       time.Time `json:"expires_at,omitzero"`
+	SecondsToExpiry float64   `json:"seconds_to_expiry"`
+	AutoRefresh     bool      `json:"auto_refresh"`
+}
+
+// snapshot builds the /status document.
+func (t *componentTracker) snapshot(v storeView, now time.Time, staleAfter time.Duration) exporterStatus {
+	ready, reason := t.ready(now, staleAfter)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s := exporterStatus{
+		APIVersion:      apiVersion,
+		GeneratedAt:     now,
+		Ready:           ready,
+		ReadyReason:     reason,
+		UptimeSeconds:   now.Sub(t.started).Seconds(),
+		StaleAfterS:     staleAfter.Seconds(),
+		Gateway:         t.gateway,
+		GatewayInfo:     t.info,
+		Sinks:           make(map[string]componentStatus, len(t.sinks)),
+		Endpoints:       make(map[string]componentStatus, len(t.endpoints)),
+		LastScrape:      v.LastScrape,
+		LastScrapeMS:    v.LastScrapeDur.Milliseconds(),
+		LastScrapeError: v.LastScrapeErr,
+		JWT: jwtStatus{
+			componentStatus: t.jwt,
+			ExpiresAt:       t.jwtExpiry,
+			AutoRefresh:     t.jwtAuto,
+		},
+	}
+	if !t.jwtExpiry.IsZero() {
+		s.JWT.SecondsToExpiry = t.jwtExpiry.Sub(now).Seconds()
+		s.JWT.Valid = t.jwtExpiry.After(now)
+	}
+	for name, c := range t.sinks {
+		s.Sinks[name] = *c
+	}
+	for name, c := range t.endpoints {
+		s.Endpoints[name] = *c
+	}
+	return s
+}
+
+// registerStatus adds /livez, /readyz and /status to mux.
+//
+// /livez only reports that the process is serving HTTP; restarting cannot fix
+// a gateway or sink outage, so nothing else affects it. /readyz fails while no
+// fresh gateway data is available. /status returns the full component state.
+func registerStatus(mux *http.ServeMux, tracker *componentTracker, store *scrapeStore, staleAfter time.Duration) {
+	mux.HandleFunc("GET /livez", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, reason := tracker.ready(time.Now(), staleAfter)
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("not ready: " + reason + "\n"))
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		s := tracker.snapshot(store.view(), now, staleAfter)
+		s.Schedule = scrapeSchedule.status(now)
+		writeJSON(w, http.StatusOK, s)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComponentTracker_Endpoints(t *testing.T) {
+	t.Parallel()
+
+	tr := newComponentTracker()
+	tr.recordEndpoint(EndpointLiveData, nil)
+	tr.recordEndpoint(EndpointMeters, errors.New("boom"))
+	tr.recordEndpoint(EndpointMeters, errors.New("boom again"))
+	tr.recordEndpoint(EndpointBatteries, &gateway.Error{StatusCode: http.StatusNotFound, Endpoint: "/ivp/ensemble/inventory"})
+
+	s := tr.snapshot(storeView{}, time.Now(), time.Minute)
+	assert.Equal(t, StateOK, s.Endpoints[EndpointLiveData].State)
+	assert.Equal(t, StateError, s.Endpoints[EndpointMeters].State)
+	assert.Equal(t, 2, s.Endpoints[EndpointMeters].ConsecutiveFailures)
+	assert.Equal(t, "boom again", s.Endpoints[EndpointMeters].LastError)
+	assert.Equal(t, StateNotInstalled, s.Endpoints[EndpointBatteries].State)
+	assert.Equal(t, StateUnknown, s.Gateway.State)
+
+	tr.recordEndpoint(EndpointMeters, nil)
+	s = tr.snapshot(storeView{}, time.Now(), time.Minute)
+	assert.Equal(t, 0, s.Endpoints[EndpointMeters].ConsecutiveFailures, "success resets the failure count")
+	assert.Equal(t, "boom again", s.Endpoints[EndpointMeters].LastError, "last error is kept for diagnosis")
+}
+
+func TestComponentTracker_Ready(t *testing.T) {
+	t.Parallel()
+
+	tr := newComponentTracker()
+	ok, reason := tr.ready(time.Now(), time.Minute)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "no successful")
+
+	tr.recordEndpoint(EndpointBatteries, &gateway.Error{StatusCode: http.StatusNotFound})
+	tr.recordEndpoint(EndpointMeters, nil)
+	ok, _ = tr.ready(time.Now(), time.Minute)
+	assert.False(t, ok, "only live data counts; a 404 is not a read")
+	assert.True(t, tr.snapshot(storeView{}, time.Now(), time.Minute).Endpoints[EndpointBatteries].LastSuccess.IsZero())
+
+	tr.recordSink("influxdb", errors.New("down"))
+	tr.recordEndpoint(EndpointLiveData, nil)
+	ok, _ = tr.ready(time.Now(), time.Minute)
+	assert.True(t, ok, "sink failures do not affect readiness")
+
+	ok, reason = tr.ready(time.Now().Add(2*time.Minute), time.Minute)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "too long ago")
+}
+
+func TestComponentTracker_JWT(t *testing.T) {
+	t.Parallel()
+
+	tr := newComponentTracker()
+	now := time.Now()
+	tr.setJWT(now.Add(time.Hour), true)
+	tr.recordJWTRefresh(nil)
+
+	s := tr.snapshot(storeView{}, now, time.Minute)
+	assert.True(t, s.JWT.Valid)
+	assert.True(t, s.JWT.AutoRefresh)
+	assert.InDelta(t, 3600, s.JWT.SecondsToExpiry, 1)
+	assert.Equal(t, StateOK, s.JWT.State)
+
+	s = tr.snapshot(storeView{}, now.Add(2*time.Hour), time.Minute)
+	assert.False(t, s.JWT.Valid)
+}
+
+func TestStatusHandlers(t *testing.T) {
+	t.Parallel()
+
+	tr := newComponentTracker()
+	store := &scrapeStore{}
+	mux := http.NewServeMux()
+	registerStatus(mux, tr, store, time.Minute)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get("/livez").Code, "liveness never depends on the gateway")
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz").Code)
+
+	tr.recordGateway(nil)
+	tr.recordEndpoint(EndpointLiveData, nil)
+	tr.recordSink("sqlite", nil)
+	store.setResult(time.Now(), 1200*time.Millisecond, scrapeResult{points: 3})
+	assert.Equal(t, http.StatusOK, get("/readyz").Code)
+
+	w := get("/status")
+	require.Equal(t, http.StatusOK, w.Code)
+	var s exporterStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&s))
+	assert.True(t, s.Ready)
+	assert.Equal(t, 60.0, s.StaleAfterS)
+	assert.Equal(t, StateOK, s.Gateway.State)
+	assert.Equal(t, StateOK, s.Sinks["sqlite"].State)
+	assert.Equal(t, int64(1200), s.LastScrapeMS)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	metricStreamClients = expvar.NewInt("stream_clients")
+	metricStreamDropped = expvar.NewInt("stream_dropped_clients")
+)
+
+const (
+	// streamBuffer is how many scrapes may queue for a client before it is
+	// considered too slow and disconnected. EventSource clients reconnect
+	// automatically and receive the current state on reconnect.
+	streamBuffer = 4
+	// streamWriteTimeout bounds a single event write to a stalled connection.
+	streamWriteTimeout = 10 * time.Second
+	// streamRetry is the reconnect delay suggested to clients, in milliseconds.
+	streamRetry = 5000
+)
+
+// streamFamilies are the optional per-endpoint events a client can request
+// with ?families=inverters,batteries,meters (or ?families=all). The snapshot
+// event is always sent.
+var streamFamilies = []string{"inverters", "batteries", "meters"}
+
+// parseStreamFamilies validates the families query parameter.
+func parseStreamFamilies(raw string) (map[string]bool, error) {
+	want := make(map[string]bool)
+	if raw == "" {
+		return want, nil
+	}
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case "":
+		case "all":
+			for _, name := range streamFamilies {
+				want[name] = true
+			}
+		case "inverters", "batteries", "meters":
+			want[f] = true
+		default:
+			return nil, fmt.Errorf("unknown family %q; use %s or all", f, strings.Join(streamFamilies, ", "))
+		}
+	}
+	return want, nil
+}
+
+// streamEvent is one server-sent event.
+type streamEvent struct {
+	name string
+	data any
+}
+
+// streamEvents returns the events for a store view. With onlyFresh set, a
+// family is only included if it was read by the scrape that produced v, so an
+// endpoint that failed does not re-send its previous reading.
+func streamEvents(v storeView, families map[string]bool, onlyFresh bool, now time.Time, staleAfter time.Duration) []streamEvent {
+	include := func(at time.Time) bool {
+		return !at.IsZero() && (!onlyFresh || at.Equal(v.LastScrape))
+	}
+	var events []streamEvent
+	if include(v.SnapshotAt) {
+		events = append(events, streamEvent{"snapshot", newAPIResponse(toAPISnapshot(v.Snapshot), v.SnapshotAt, now, staleAfter)})
+	}
+	if families["inverters"] && include(v.InvertersAt) {
+		events = append(events, streamEvent{"inverters", newAPIResponse(toAPIInverters(v.Inverters), v.InvertersAt, now, staleAfter)})
+	}
+	if families["batteries"] && include(v.BatteriesAt) {
+		events = append(events, streamEvent{"batteries", newAPIResponse(toAPIBatteries(v.Batteries), v.BatteriesAt, now, staleAfter)})
+	}
+	if families["meters"] && include(v.MetersAt) {
+		events = append(events, streamEvent{"meters", newAPIResponse(toAPIMeters(v.Meters), v.MetersAt, now, staleAfter)})
+	}
+	return events
+}
+
+// streamHandler serves /api/v1/stream. Each client receives the current state
+// on connect, then one batch of events per scrape and a heartbeat event
+// whenever the connection has been idle for the heartbeat interval.
+func streamHandler(store *scrapeStore, staleAfter, heartbeat time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		families, err := parseStreamFamilies(r.URL.Query().Get("families"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{APIVersion: apiVersion, Error: err.Error()})
+			return
+		}
+
+		updates, cancel := store.subscribe(streamBuffer)
+		defer cancel()
+		metricStreamClients.Add(1)
+		defer metricStreamClients.Add(-1)
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+		w.WriteHeader(http.StatusOK)
+
+		// send writes one batch of events and flushes it. Write deadlines are
+		// best effort; not every ResponseWriter supports them.
+		send := func(id string, events []streamEvent) error {
+			_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			for _, ev := range events {
+				data, err := json.Marshal(ev.data)
+				if err != nil {
+					return err
+				}
+				if id != "" {
+					if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+						return err
+					}
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, data); err != nil {
+					return err
+				}
+			}
+			return rc.Flush()
+		}
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry); err != nil {
+			return
+		}
+		initial := store.view()
+		if err := send("", streamEvents(initial, families, false, time.Now(), staleAfter)); err != nil {
+			return
+		}
+
+		idle := time.NewTimer(heartbeat)
+		defer idle.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case v, ok := <-updates:
+				if !ok {
+					slog.Debug("Stream client too slow; disconnecting", "remote", r.RemoteAddr)
+					return
+				}
+				id := fmt.Sprint(v.LastScrape.UnixMilli())
+				if err := send(id, streamEvents(v, families, true, time.Now(), staleAfter)); err != nil {
+					return
+				}
+			case now := <-idle.C:
+				if err := send("", []streamEvent{{"heartbeat", map[string]any{"time": now}}}); err != nil {
+					return
+				}
+			}
+			idle.Reset(heartbeat)
+		}
+	}
+}
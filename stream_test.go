@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent is one parsed server-sent event.
+type sseEvent struct {
+	id, name, data string
+}
+
+// readSSE parses events from r onto the returned channel until r is exhausted.
+func readSSE(r *bufio.Reader) <-chan sseEvent {
+	out := make(chan sseEvent, 16)
+	go func() {
+		defer close(out)
+		var ev sseEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if ev.name != "" {
+					out <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return out
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		require.True(t, ok, "stream closed")
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return sseEvent{}
+	}
+}
+
+func openStream(t *testing.T, store *scrapeStore, heartbeat time.Duration, query string) <-chan sseEvent {
+	t.Helper()
+	srv := httptest.NewServer(streamHandler(store, 15*time.Second, heartbeat))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+query, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return readSSE(bufio.NewReader(resp.Body))
+}
+
+func TestStream_InitialStateAndScrapes(t *testing.T) {
+	t.Parallel()
+
+	store := &scrapeStore{}
+	t0 := time.Now()
+	store.setSnapshot(gateway.SnapshotFromLiveData(makeLiveData(1000000, 0, 0, 1000000)), t0)
+	store.setInverters([]gateway.InverterReading{{SerialNumber: "INV1"}}, t0)
+
+	events := openStream(t, store, time.Hour, "?families=inverters")
+
+	// Current state on connect.
+	ev := nextEvent(t, events)
+	assert.Equal(t, "snapshot", ev.name)
+	ev = nextEvent(t, events)
+	assert.Equal(t, "inverters", ev.name)
+
+	// A scrape in which only LiveData succeeded sends just the snapshot.
+	t1 := t0.Add(time.Second)
+	store.setSnapshot(gateway.SnapshotFromLiveData(makeLiveData(2000000, 0, 0, 2000000)), t1)
+	store.setResult(t1, time.Millisecond, scrapeResult{points: 1})
+
+	ev = nextEvent(t, events)
+	assert.Equal(t, "snapshot", ev.name)
+	assert.NotEmpty(t, ev.id)
+	var resp struct {
+		apiResponse
+		Data apiSnapshot `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(ev.data), &resp))
+	assert.Equal(t, 2000.0, resp.Data.SolarW)
+
+	// Next scrape refreshes inverters too.
+	t2 := t1.Add(time.Second)
+	store.setInverters([]gateway.InverterReading{{SerialNumber: "INV1", LastReportWatts: 10}}, t2)
+	store.setResult(t2, time.Millisecond, scrapeResult{points: 1})
+	ev = nextEvent(t, events)
+	assert.Equal(t, "inverters", ev.name, "stale snapshot is not re-sent")
+}
+
+func TestStream_Heartbeat(t *testing.T) {
+	t.Parallel()
+
+	events := openStream(t, &scrapeStore{}, 20*time.Millisecond, "")
+	ev := nextEvent(t, events)
+	assert.Equal(t, "heartbeat", ev.name)
+}
+
+func TestStream_BadFamily(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	streamHandler(&scrapeStore{}, time.Second, time.Second).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/stream?families=weather", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "weather")
+}
+
+func TestScrapeStore_DropsSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	store := &scrapeStore{}
+	slow, cancelSlow := store.subscribe(2)
+	defer cancelSlow()
+	fast, cancelFast := store.subscribe(2)
+	defer cancelFast()
+
+	for i := range 3 {
+		store.setResult(time.Now(), 0, scrapeResult{points: i})
+		<-fast // the fast subscriber keeps up
+	}
+
+	// Two buffered views, then the channel is closed.
+	<-slow
+	<-slow
+	_, ok := <-slow
+	assert.False(t, ok, "slow subscriber should be disconnected")
+
+	store.setResult(time.Now(), 0, scrapeResult{})
+	_, ok = <-fast
+	assert.True(t, ok, "fast subscriber is unaffected")
+}
+
+func TestParseStreamFamilies(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseStreamFamilies("all")
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+
+	got, err = parseStreamFamilies(" meters , batteries")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"meters": true, "batteries": true}, got)
+
+	_, err = parseStreamFamilies("snapshot,x")
+	assert.Error(t, err)
+}
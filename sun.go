@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Location is where the system is installed, for sun position calculations
+// and the day boundaries of daily summaries.
+type Location struct {
+	Latitude  float64 `yaml:"latitude"`  // degrees, north positive
+	Longitude float64 `yaml:"longitude"` // degrees, east positive
+	Timezone  string  `yaml:"timezone"`  // IANA name, e.g. America/Denver; default: from longitude
+}
+
+// validate checks that the coordinates are on the globe and the time zone
+// is known.
+func (l Location) validate() error {
+	if l.Latitude < -90 || l.Latitude > 90 {
+		return fmt.Errorf("location: latitude %v must be between -90 and 90", l.Latitude)
+	}
+	if l.Longitude < -180 || l.Longitude > 180 {
+		return fmt.Errorf("location: longitude %v must be between -180 and 180", l.Longitude)
+	}
+	if l.Timezone != "" {
+		if _, err := time.LoadLocation(l.Timezone); err != nil {
+			return fmt.Errorf("location: timezone: %w", err)
+		}
+	}
+	return nil
+}
+
+// zone returns the time zone whose midnight ends the day for daily
+// summaries: the configured time zone or, without one, the longitude's mean
+// solar time rounded to the hour. Without a location it is the exporter's
+// local zone, which in a container is usually UTC.
+func (l *Location) zone() *time.Location {
+	if l == nil {
+		return time.Local
+	}
+	if l.Timezone != "" {
+		if z, err := time.LoadLocation(l.Timezone); err == nil {
+			return z
+		}
+	}
+	h := int(math.Round(l.Longitude / 15))
+	return time.FixedZone(fmt.Sprintf("UTC%+d", h), h*3600)
+}
+
+// startOfDay returns midnight at the start of t's day in zone.
+func startOfDay(t time.Time, zone *time.Location) time.Time {
+	y, m, d := t.In(zone).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, zone)
+}
+
+// sunPosition is where the sun is in the sky, in degrees.
+type sunPosition struct {
+	Zenith  float64 // from straight up; above 90 the sun has set
+	Azimuth float64 // clockwise from north
+}
+
+// sunAt returns the position of the sun seen from loc at t, using the NOAA
+// approximation; it is within a fraction of a degree, far better than the
+// clear-sky model that uses it.
+func sunAt(loc Location, t time.Time) sunPosition {
+	decl, eqTime := solarDeclination(t)
+	u := t.UTC()
+	minutes := float64(u.Hour()*60+u.Minute()) + float64(u.Second())/60
+	trueSolar := minutes + eqTime + 4*loc.Longitude
+	hourAngle := radians(trueSolar/4 - 180)
+
+	lat := radians(loc.Latitude)
+	cosZenith := math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(hourAngle)
+	zenith := math.Acos(max(-1, min(1, cosZenith)))
+	azimuth := math.Atan2(math.Sin(hourAngle), math.Cos(hourAngle)*math.Sin(lat)-math.Tan(decl)*math.Cos(lat))
+	return sunPosition{
+		Zenith:  degrees(zenith),
+		Azimuth: math.Mod(degrees(azimuth)+180, 360),
+	}
+}
+
+// solarDeclination returns the sun's declination in radians and the
+// equation of time in minutes at t.
+func solarDeclination(t time.Time) (decl, eqTime float64) {
+	u := t.UTC()
+	daysInYear := 365.0
+	if y := u.Year(); y%4 == 0 && (y%100 != 0 || y%400 == 0) {
+		daysInYear = 366
+	}
+	g := 2 * math.Pi / daysInYear * (float64(u.YearDay()-1) + (float64(u.Hour())-12)/24)
+	eqTime = 229.18 * (0.000075 + 0.001868*math.Cos(g) - 0.032077*math.Sin(g) -
+		0.014615*math.Cos(2*g) - 0.040849*math.Sin(2*g))
+	decl = 0.006918 - 0.399912*math.Cos(g) + 0.070257*math.Sin(g) -
+		0.006758*math.Cos(2*g) + 0.000907*math.Sin(2*g) -
+		0.002697*math.Cos(3*g) + 0.00148*math.Sin(3*g)
+	return decl, eqTime
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
+
+// sunEvents is the day's sunrise and sunset. In polar day or night there is
+// neither, and Up tells which.
+type sunEvents struct {
+	Sunrise, Sunset time.Time // zero in polar day or night
+	Up              bool      // polar day; only meaningful without events
+}
+
+// sunEventsOn returns sunrise and sunset on the solar day at loc that
+// contains t, with the sun's upper limb on a refracted horizon.
+func sunEventsOn(loc Location, t time.Time) sunEvents {
+	// The date at the location's mean solar time, not the exporter's zone.
+	y, m, d := t.UTC().Add(time.Duration(loc.Longitude * 4 * float64(time.Minute))).Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	decl, eqTime := solarDeclination(midnight.Add(12 * time.Hour))
+
+	lat := radians(loc.Latitude)
+	cosHA := math.Cos(radians(90.833))/(math.Cos(lat)*math.Cos(decl)) - math.Tan(lat)*math.Tan(decl)
+	switch {
+	case cosHA > 1:
+		return sunEvents{}
+	case cosHA < -1:
+		return sunEvents{Up: true}
+	}
+	ha := degrees(math.Acos(cosHA))
+	at := func(minutes float64) time.Time {
+		return midnight.Add(time.Duration(minutes * float64(time.Minute)))
+	}
+	return sunEvents{
+		Sunrise: at(720 - 4*(loc.Longitude+ha) - eqTime),
+		Sunset:  at(720 - 4*(loc.Longitude-ha) - eqTime),
+	}
+}
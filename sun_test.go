@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSunAt(t *testing.T) {
+	t.Parallel()
+
+	// Solar noon at the June solstice: the sun is due south, 40° - 23.44°
+	// from the zenith.
+	loc := Location{Latitude: 40, Longitude: 0}
+	noon := time.Date(2026, 6, 21, 12, 2, 0, 0, time.UTC)
+	sun := sunAt(loc, noon)
+	assert.InDelta(t, 16.56, sun.Zenith, 0.3)
+	assert.InDelta(t, 180, sun.Azimuth, 2)
+
+	morning := sunAt(loc, noon.Add(-4*time.Hour))
+	assert.Less(t, morning.Azimuth, 180.0, "east of south in the morning")
+	assert.Greater(t, morning.Zenith, sun.Zenith)
+
+	// Same wall time 90° further west: before sunrise.
+	assert.Greater(t, sunAt(Location{Latitude: 40, Longitude: -90}, noon.Add(-4*time.Hour)).Zenith, 90.0)
+
+	// Southern hemisphere: the midday sun is to the north.
+	south := sunAt(Location{Latitude: -33.9, Longitude: 151.2}, time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC))
+	assert.True(t, south.Azimuth < 30 || south.Azimuth > 330, "azimuth %v", south.Azimuth)
+}
+
+func TestLocation_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, Location{Latitude: -33.9, Longitude: 151.2}.validate())
+	assert.Error(t, Location{Latitude: 91}.validate())
+	assert.Error(t, Location{Longitude: -181}.validate())
+	assert.NoError(t, Location{Latitude: 39.7, Longitude: -105, Timezone: "America/Denver"}.validate())
+	assert.Error(t, Location{Timezone: "Mars/Olympus_Mons"}.validate())
+}
+
+func TestLocation_Zone(t *testing.T) {
+	t.Parallel()
+
+	at := time.Date(2026, 7, 1, 5, 30, 0, 0, time.UTC)
+	denver := &Location{Latitude: 39.7, Longitude: -105, Timezone: "America/Denver"}
+	assert.Equal(t, time.Date(2026, 6, 30, 6, 0, 0, 0, time.UTC), startOfDay(at, denver.zone()).UTC(), "MDT is UTC-6")
+
+	noZone := &Location{Latitude: 39.7, Longitude: -105}
+	assert.Equal(t, time.Date(2026, 6, 30, 7, 0, 0, 0, time.UTC), startOfDay(at, noZone.zone()).UTC(), "UTC-7 from longitude")
+
+	var none *Location
+	assert.Equal(t, time.Local, none.zone())
+}
+
+func TestSunEventsOn(t *testing.T) {
+	t.Parallel()
+
+	// Greenwich at the June solstice: sunrise 03:43, sunset 20:21 UTC.
+	greenwich := Location{Latitude: 51.48, Longitude: 0}
+	ev := sunEventsOn(greenwich, time.Date(2026, 6, 21, 9, 0, 0, 0, time.UTC))
+	assert.WithinDuration(t, time.Date(2026, 6, 21, 3, 43, 0, 0, time.UTC), ev.Sunrise, 3*time.Minute)
+	assert.WithinDuration(t, time.Date(2026, 6, 21, 20, 21, 0, 0, time.UTC), ev.Sunset, 3*time.Minute)
+
+	// West of Greenwich the solar day, not the UTC date, is used.
+	denver := sunEventsOn(Location{Latitude: 39.74, Longitude: -104.99}, time.Date(2026, 6, 22, 3, 0, 0, 0, time.UTC))
+	assert.Equal(t, 21, denver.Sunrise.Add(-6*time.Hour).Day(), "evening of the 21st local time")
+
+	assert.True(t, sunEventsOn(Location{Latitude: 78}, time.Date(2026, 6, 21, 0, 0, 0, 0, time.UTC)).Up, "midnight sun")
+	night := sunEventsOn(Location{Latitude: 78}, time.Date(2026, 12, 21, 0, 0, 0, 0, time.UTC))
+	assert.False(t, night.Up)
+	assert.True(t, night.Sunrise.IsZero(), "polar night")
+}
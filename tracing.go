@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing exporters selectable with the tracing config key.
+const (
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+)
+
+const tracerName = "github.com/hobeone/envoy-exporter"
+
+// startSpan starts a span from the global tracer provider, which is a no-op
+// unless tracing is enabled. The provider is looked up on every call rather
+// than cached so that one installed after startup is always used.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span, if any, and ends it. A 404 from the gateway
+// means the hardware is not installed and is not treated as an error.
+func endSpan(span trace.Span, err error) {
+	switch {
+	case err == nil:
+	case gateway.IsNotFound(err):
+		span.SetAttributes(attribute.Bool("envoy.not_installed", true))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// newTracerProvider builds a tracer provider for cfg.Tracing. Spans are
+// batched; the stdout exporter writes pretty-printed JSON to w. OTLP settings
+// fall back to the otlp_* metrics settings when tracing_endpoint is unset.
+func newTracerProvider(ctx context.Context, cfg *Config, w io.Writer) (*sdktrace.TracerProvider, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch cfg.Tracing {
+	case TracingStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(w), stdouttrace.WithPrettyPrint())
+	case TracingOTLP:
+		if cfg.TracingEndpoint != "" {
+			exp, err = newOTLPTraceExporter(ctx, cfg.TracingEndpoint, cfg.TracingProtocol, cfg.TracingInsecure, nil)
+		} else {
+			exp, err = newOTLPTraceExporter(ctx, cfg.OTLPEndpoint, cfg.OTLPProtocol, cfg.OTLPInsecure, cfg.OTLPHeaders)
+		}
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Tracing)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", cfg.Tracing, err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp, sdktrace.WithBatchTimeout(5*time.Second)),
+		sdktrace.WithResource(otelResource(cfg.SerialNumber, cfg.SourceTag)),
+	), nil
+}
+
+func newOTLPTraceExporter(ctx context.Context, endpoint, protocol string, insecure bool, headers map[string]string) (sdktrace.SpanExporter, error) {
+	hasScheme := strings.Contains(endpoint, "://")
+	switch protocol {
+	case OTLPProtocolGRPC, "":
+		var o []otlptracegrpc.Option
+		if hasScheme {
+			o = append(o, otlptracegrpc.WithEndpointURL(endpoint))
+		} else {
+			o = append(o, otlptracegrpc.WithEndpoint(endpoint))
+		}
+		if insecure {
+			o = append(o, otlptracegrpc.WithInsecure())
+		}
+		if len(headers) > 0 {
+			o = append(o, otlptracegrpc.WithHeaders(headers))
+		}
+		return otlptracegrpc.New(ctx, o...)
+	case OTLPProtocolHTTP:
+		o := []otlptracehttp.Option{otlptracehttp.WithCompression(otlptracehttp.GzipCompression)}
+		if hasScheme {
+			o = append(o, otlptracehttp.WithEndpointURL(endpoint))
+		} else {
+			o = append(o, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if insecure {
+			o = append(o, otlptracehttp.WithInsecure())
+		}
+		if len(headers) > 0 {
+			o = append(o, otlptracehttp.WithHeaders(headers))
+		}
+		return otlptracehttp.New(ctx, o...)
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol %q", protocol)
+	}
+}
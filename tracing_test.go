@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	gateway "github.com/hobeone/enphase-gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a recording tracer provider for the duration of the
+// test. It swaps the global provider, so callers must not run in parallel.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestScrape_Traces(t *testing.T) {
+	// Not parallel: replaces the global tracer provider.
+	rec := recordSpans(t)
+
+	client := &MockEnvoyClient{
+		LiveDataFunc: func(ctx context.Context) (gateway.LiveData, error) {
+			return makeLiveData(1000000, 0, 0, 1000000), nil
+		},
+		InvertersFunc: func(ctx context.Context) ([]gateway.InverterReading, error) {
+			return nil, errors.New("inverter error")
+		},
+		BatteryInventoryFunc: func(ctx context.Context) ([]gateway.BatteryStatus, error) {
+			return nil, &gateway.Error{StatusCode: http.StatusNotFound}
+		},
+	}
+	w := trackedWriter{name: "test-trace", next: &MockPointWriter{}}
+	scrape(context.Background(), client, w, &Schema{Source: "test"})
+
+	spans := rec.Ended()
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		byName[s.Name()] = s
+	}
+	root, ok := byName["scrape"]
+	require.True(t, ok, "scrape span")
+	assert.Equal(t, codes.Error, root.Status().Code, "a failed endpoint marks the scrape")
+
+	for _, name := range []string{"gateway.livedata", "gateway.meters", "gateway.inverters", "gateway.batteries", "write"} {
+		s, ok := byName[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, root.SpanContext().SpanID(), s.Parent().SpanID(), "%s is a child of scrape", name)
+		}
+	}
+	assert.Equal(t, codes.Error, byName["gateway.inverters"].Status().Code)
+	assert.NotEqual(t, codes.Error, byName["gateway.batteries"].Status().Code, "404 means not installed")
+
+	sink, ok := byName["sink.test-trace"]
+	require.True(t, ok)
+	assert.Equal(t, byName["write"].SpanContext().SpanID(), sink.Parent().SpanID())
+}
+
+func TestConnectWithBackoff_Traces(t *testing.T) {
+	// Not parallel: replaces the global tracer provider.
+	rec := recordSpans(t)
+
+	calls := 0
+	factory := func(context.Context, *Config) (EnvoyClient, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("not ready")
+		}
+		return &MockEnvoyClient{}, nil
+	}
+	_, err := connectWithBackoff(context.Background(), &Config{Address: "envoy.local"}, factory, 0, 0)
+	require.NoError(t, err)
+
+	var connect sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		if s.Name() == "gateway.connect" {
+			connect = s
+		}
+	}
+	require.NotNil(t, connect)
+	assert.Len(t, connect.Events(), 2, "one event per failed attempt")
+	assert.Equal(t, codes.Unset, connect.Status().Code)
+}
+
+func TestConnectWithBackoff_TracesVerification(t *testing.T) {
+	// Not parallel: replaces the global tracer provider.
+	rec := recordSpans(t)
+
+	factory := func(ctx context.Context, _ *Config) (EnvoyClient, error) {
+		return verifyGateway(ctx, &MockEnvoyClient{})
+	}
+	_, err := connectWithBackoff(context.Background(), &Config{Address: "envoy.local"}, factory, 0, 0)
+	require.NoError(t, err)
+
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range rec.Ended() {
+		byName[s.Name()] = s
+	}
+	connect, ok := byName["gateway.connect"]
+	require.True(t, ok)
+	for _, name := range []string{"gateway.livedata", "gateway.info"} {
+		s, ok := byName[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, connect.SpanContext().SpanID(), s.Parent().SpanID(), "%s is a child of gateway.connect", name)
+		}
+	}
+}
+
+func TestNewTracerProvider_Stdout(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	tp, err := newTracerProvider(context.Background(), &Config{Tracing: TracingStdout, SerialNumber: "1234"}, &buf)
+	require.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "scrape")
+	span.End()
+	require.NoError(t, tp.Shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name": "scrape"`)
+	assert.Contains(t, buf.String(), "envoy.gateway.serial")
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Validation actions for values that break a rule.
+const (
+	ValidationDrop  = "drop"  // leave the field out of the point
+	ValidationClamp = "clamp" // replace it with the nearest acceptable value
+	ValidationTag   = "tag"   // write it, naming it in the suspect tag
+)
+
+// Validation rules, as counted in envoy_exporter_validation_rejected_total.
+const (
+	RuleNaN  = "nan"
+	RuleMin  = "min"
+	RuleMax  = "max"
+	RuleRate = "rate"
+)
+
+// TagSuspect lists, comma-separated, the fields of a point that broke a
+// validation rule with the tag action.
+const TagSuspect = "suspect"
+
+var promValidationRejected = promFactory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "envoy_exporter",
+	Name:      "validation_rejected_total",
+	Help:      "Field values that broke a validation rule, by field and rule (nan, min, max, rate).",
+}, []string{"field", "rule"})
+
+// ValidationConfig configures checks of field values before they are
+// written. NaN and infinite values are always dropped, as they cannot be
+// written; other rules apply to the listed fields.
+type ValidationConfig struct {
+	Action string                    `yaml:"action"` // drop (default), clamp or tag
+	Fields map[string]ValidationRule `yaml:"fields"` // by field name, in any measurement
+}
+
+// ValidationRule bounds one field. Unset limits are not checked.
+type ValidationRule struct {
+	Min     *float64 `yaml:"min"`
+	Max     *float64 `yaml:"max"`
+	MaxRate *float64 `yaml:"max_rate"` // largest change per second from the last accepted value
+	Action  string   `yaml:"action"`   // overrides the default action
+}
+
+// validate checks the actions and limits.
+func (c ValidationConfig) validate() error {
+	if err := validateAction(c.Action); err != nil {
+		return fmt.Errorf("validation: %w", err)
+	}
+	for field, r := range c.Fields {
+		if err := validateAction(r.Action); err != nil {
+			return fmt.Errorf("validation: field %q: %w", field, err)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return fmt.Errorf("validation: field %q: min %v is above max %v", field, *r.Min, *r.Max)
+		}
+		if r.MaxRate != nil && *r.MaxRate <= 0 {
+			return fmt.Errorf("validation: field %q: max_rate must be positive", field)
+		}
+	}
+	return nil
+}
+
+func validateAction(a string) error {
+	switch a {
+	case "", ValidationDrop, ValidationClamp, ValidationTag:
+		return nil
+	}
+	return fmt.Errorf("invalid action %q: use drop, clamp or tag", a)
+}
+
+// validationValue is a field value at a time.
+type validationValue struct {
+	v        float64
+	at       time.Time
+	rejected bool // by the rate rule; only set on the last value seen
+}
+
+// ValidatingWriter checks field values against their rules before passing
+// points to next. A value breaking a rule is dropped, clamped or tagged;
+// points left without fields are not written.
+//
+// The rate rule compares a value with the last accepted one. A change that
+// persists, i.e. a second value within the rate of the first rejected one,
+// is accepted, so a genuine step is only held back for one reading while a
+// one-reading glitch is filtered.
+type ValidatingWriter struct {
+	next   PointWriter
+	action string
+	rules  map[string]ValidationRule
+
+	mu       sync.Mutex
+	accepted map[string]validationValue // by series and field
+	seen     map[string]validationValue
+}
+
+// NewValidatingWriter returns a ValidatingWriter writing to next.
+func NewValidatingWriter(next PointWriter, cfg ValidationConfig) *ValidatingWriter {
+	action := cfg.Action
+	if action == "" {
+		action = ValidationDrop
+	}
+	return &ValidatingWriter{
+		next:     next,
+		action:   action,
+		rules:    cfg.Fields,
+		accepted: make(map[string]validationValue),
+		seen:     make(map[string]validationValue),
+	}
+}
+
+// WritePoint validates points and writes what is left to next.
+func (w *ValidatingWriter) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	points := w.check(point)
+	if len(points) == 0 {
+		return nil
+	}
+	return w.next.WritePoint(ctx, points...)
+}
+
+// check returns the points with their fields validated.
+func (w *ValidatingWriter) check(points []*influxdb2write.Point) []*influxdb2write.Point {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]*influxdb2write.Point, 0, len(points))
+	for _, pt := range points {
+		series := pt.Name() + "\x00" + canonicalTags(pt)
+		var keep []*lp.Field
+		var suspect []string
+		changed := false
+		for _, f := range pt.FieldList() {
+			v, action, rule := w.checkField(series, f.Key, f.Value, pt.Time())
+			if rule == "" {
+				keep = append(keep, f)
+				continue
+			}
+			promValidationRejected.WithLabelValues(f.Key, rule).Inc()
+			slog.Debug("Field failed validation", "measurement", pt.Name(), "field", f.Key,
+				"value", f.Value, "rule", rule, "action", action)
+			changed = true
+			switch action {
+			case ValidationClamp:
+				keep = append(keep, &lp.Field{Key: f.Key, Value: v})
+			case ValidationTag:
+				keep = append(keep, f)
+				suspect = append(suspect, f.Key)
+			}
+		}
+		switch {
+		case !changed:
+			out = append(out, pt)
+		case len(keep) > 0:
+			np := rebuildPoint(pt, keep)
+			if len(suspect) > 0 {
+				slices.Sort(suspect)
+				np.AddTag(TagSuspect, strings.Join(suspect, ","))
+			}
+			out = append(out, np)
+		}
+	}
+	return out
+}
+
+// checkField validates one value. It returns the rule broken, or "" if
+// none, the action to take and, for clamp, the replacement value.
+func (w *ValidatingWriter) checkField(series, key string, value any, at time.Time) (clamped any, action, rule string) {
+	f, ok := value.(float64)
+	if !ok {
+		if _, isBool := value.(bool); isBool {
+			return nil, "", ""
+		}
+		if f, ok = fieldFloat(value); !ok {
+			return nil, "", "" // strings
+		}
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, ValidationDrop, RuleNaN
+	}
+	r, ok := w.rules[key]
+	if !ok {
+		return nil, "", ""
+	}
+	action = w.action
+	if r.Action != "" {
+		action = r.Action
+	}
+
+	k := series + "\x00" + key
+	switch {
+	case r.Min != nil && f < *r.Min:
+		return w.reject(k, value, *r.Min, at, action, RuleMin)
+	case r.Max != nil && f > *r.Max:
+		return w.reject(k, value, *r.Max, at, action, RuleMax)
+	}
+	if r.MaxRate != nil {
+		last, haveLast := w.accepted[k]
+		prev := w.seen[k]
+		if haveLast && at.After(last.at) {
+			limit := *r.MaxRate * at.Sub(last.at).Seconds()
+			persists := prev.rejected && at.After(prev.at) && math.Abs(f-prev.v) <= *r.MaxRate*at.Sub(prev.at).Seconds()
+			if math.Abs(f-last.v) > limit && !persists {
+				w.seen[k] = validationValue{v: f, at: at, rejected: true}
+				return w.reject(k, value, last.v+math.Copysign(limit, f-last.v), at, action, RuleRate)
+			}
+		}
+	}
+	w.accepted[k] = validationValue{v: f, at: at}
+	w.seen[k] = validationValue{v: f, at: at}
+	return nil, "", ""
+}
+
+// reject returns the outcome for a value that broke rule. A clamped value
+// becomes the last accepted one, of the field's original type.
+func (w *ValidatingWriter) reject(k string, value any, bound float64, at time.Time, action, rule string) (any, string, string) {
+	if action != ValidationClamp {
+		return nil, action, rule
+	}
+	w.accepted[k] = validationValue{v: bound, at: at}
+	switch value.(type) {
+	case int64:
+		return int64(math.Round(bound)), action, rule
+	case uint64:
+		return uint64(max(0, math.Round(bound))), action, rule
+	}
+	return bound, action, rule
+}
+
+// rebuildPoint returns a copy of pt with only the given fields.
+func rebuildPoint(pt *influxdb2write.Point, fields []*lp.Field) *influxdb2write.Point {
+	np := influxdb2.NewPointWithMeasurement(pt.Name()).SetTime(pt.Time())
+	for _, t := range pt.TagList() {
+		np.AddTag(t.Key, t.Value)
+	}
+	for _, f := range fields {
+		np.AddField(f.Key, f.Value)
+	}
+	return np
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ValidationConfig{}.validate())
+	assert.NoError(t, ValidationConfig{Action: ValidationTag, Fields: map[string]ValidationRule{
+		"battery_soc": {Min: ptr(0.0), Max: ptr(100.0), MaxRate: ptr(1.0), Action: ValidationClamp},
+	}}.validate())
+	assert.Error(t, ValidationConfig{Action: "ignore"}.validate())
+	assert.Error(t, ValidationConfig{Fields: map[string]ValidationRule{"solar_w": {Action: "ignore"}}}.validate())
+	assert.Error(t, ValidationConfig{Fields: map[string]ValidationRule{"solar_w": {Min: ptr(10.0), Max: ptr(0.0)}}}.validate())
+	assert.Error(t, ValidationConfig{Fields: map[string]ValidationRule{"solar_w": {MaxRate: ptr(0.0)}}}.validate())
+}
+
+func validationTestPoint(solar float64, soc int64, t time.Time) *influxdb2write.Point {
+	return influxdb2.NewPointWithMeasurement("energy-snapshot").
+		AddTag("source", "home").
+		AddField("solar_w", solar).
+		AddField("battery_soc", soc).
+		SetTime(t)
+}
+
+func TestValidatingWriter_Bounds(t *testing.T) {
+	t.Parallel()
+
+	cfg := ValidationConfig{Fields: map[string]ValidationRule{
+		"solar_w":     {Min: ptr(-50.0), Max: ptr(12000.0)},
+		"battery_soc": {Min: ptr(0.0), Max: ptr(100.0), Action: ValidationClamp},
+	}}
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	next := &MockPointWriter{}
+	w := NewValidatingWriter(next, cfg)
+	require.NoError(t, w.WritePoint(context.Background(), validationTestPoint(-4000, 120, now)))
+	require.Len(t, next.Written, 1)
+	assert.Equal(t, map[string]any{"battery_soc": int64(100)}, fieldMap(next.Written[0]), "solar dropped, SOC clamped")
+	assert.Equal(t, "home", tagMap(next.Written[0])["source"])
+
+	cfg.Action = ValidationTag
+	next = &MockPointWriter{}
+	w = NewValidatingWriter(next, cfg)
+	require.NoError(t, w.WritePoint(context.Background(), validationTestPoint(-4000, 50, now)))
+	require.Len(t, next.Written, 1)
+	assert.Equal(t, -4000.0, fieldMap(next.Written[0])["solar_w"])
+	assert.Equal(t, "solar_w", tagMap(next.Written[0])[TagSuspect])
+
+	// Points whose only field is dropped are not written; valid ones pass as they are.
+	cfg.Action = ""
+	next = &MockPointWriter{}
+	w = NewValidatingWriter(next, cfg)
+	pt := influxdb2.NewPointWithMeasurement("energy-snapshot").AddField("solar_w", 50000.0).SetTime(now)
+	ok := validationTestPoint(3000, 50, now)
+	require.NoError(t, w.WritePoint(context.Background(), pt, ok))
+	assert.Equal(t, []*influxdb2write.Point{ok}, next.Written)
+}
+
+func TestValidatingWriter_NaN(t *testing.T) {
+	t.Parallel()
+
+	next := &MockPointWriter{}
+	w := NewValidatingWriter(next, ValidationConfig{Action: ValidationClamp})
+	pt := influxdb2.NewPointWithMeasurement("inverter").
+		AddField("power_w", math.NaN()).
+		AddField("temperature_c", math.Inf(1)).
+		AddField("dc_voltage_v", 36.5).
+		AddField("producing", true).
+		SetTime(time.Now())
+	require.NoError(t, w.WritePoint(context.Background(), pt))
+	require.Len(t, next.Written, 1)
+	assert.Equal(t, map[string]any{"dc_voltage_v": 36.5, "producing": true}, fieldMap(next.Written[0]), "NaN cannot be clamped")
+}
+
+func TestValidatingWriter_NaNTag(t *testing.T) {
+	t.Parallel()
+
+	next := &MockPointWriter{}
+	w := NewValidatingWriter(next, ValidationConfig{Action: ValidationTag, Fields: map[string]ValidationRule{
+		"power_w": {Max: ptr(400.0)},
+	}})
+	pt := influxdb2.NewPointWithMeasurement("inverter").
+		AddField("power_w", math.NaN()).
+		AddField("temperature_c", math.Inf(-1)).
+		AddField("dc_voltage_v", 36.5).
+		SetTime(time.Now())
+	require.NoError(t, w.WritePoint(context.Background(), pt))
+	require.Len(t, next.Written, 1)
+	assert.Equal(t, map[string]any{"dc_voltage_v": 36.5}, fieldMap(next.Written[0]), "NaN cannot be written, so is dropped")
+	assert.NotContains(t, tagMap(next.Written[0]), TagSuspect)
+}
+
+func TestValidatingWriter_Rate(t *testing.T) {
+	t.Parallel()
+
+	next := &MockPointWriter{}
+	w := NewValidatingWriter(next, ValidationConfig{Fields: map[string]ValidationRule{
+		"battery_soc": {MaxRate: ptr(0.1)}, // 3 points per 30 s
+	}})
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	socs := func() []any {
+		var out []any
+		for _, pt := range next.Written {
+			out = append(out, fieldMap(pt)["battery_soc"])
+		}
+		return out
+	}
+
+	// A one-reading glitch to 0 is dropped; a step to 20 that persists is
+	// accepted on its second reading.
+	for i, soc := range []int64{80, 0, 79, 20, 20, 21} {
+		require.NoError(t, w.WritePoint(context.Background(), validationTestPoint(1000, soc, base.Add(time.Duration(i)*30*time.Second))))
+	}
+	assert.Equal(t, []any{int64(80), nil, int64(79), nil, int64(20), int64(21)}, socs())
+}
+
+func TestValidatingWriter_RateClamp(t *testing.T) {
+	t.Parallel()
+
+	next := &MockPointWriter{}
+	w := NewValidatingWriter(next, ValidationConfig{Fields: map[string]ValidationRule{
+		"solar_w": {MaxRate: ptr(10.0), Action: ValidationClamp},
+	}})
+	base := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, w.WritePoint(context.Background(), validationTestPoint(1000, 50, base)))
+	require.NoError(t, w.WritePoint(context.Background(), validationTestPoint(9000, 50, base.Add(10*time.Second))))
+	assert.Equal(t, 1100.0, fieldMap(next.Written[1])["solar_w"])
+}